@@ -0,0 +1,98 @@
+package tokenest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// datasetTokens holds the o200k_base counts of the files in datasets/test, from
+// report/testAccuracy-20260107-113910Z.md.
+var datasetTokens = map[string]int{
+	"adversary_tokenx_01_alnum_run.txt":   1375,
+	"adversary_tokenx_02_alnum_run.txt":   1353,
+	"adversary_tokenx_03_alnum_run.txt":   1346,
+	"adversary_tokenx_04_alnum_run.txt":   1344,
+	"adversary_tokenx_05_alnum_run.txt":   34650,
+	"adversary_tokenx_05_hex_stream.txt":  1139,
+	"adversary_weighted_01_alnum_run.txt": 1375,
+	"adversary_weighted_02_alnum_run.txt": 1346,
+	"adversary_weighted_03_alnum_run.txt": 1344,
+	"adversary_weighted_04_alnum_run.txt": 1353,
+	"adversary_weighted_05_alnum_run.txt": 34776,
+	"adversary_weighted_05_base64.txt":    1363,
+	"analects_zh.txt":                     26457,
+	"bible_kjv_en.txt":                    13489,
+	"candide_fr.txt":                      14186,
+	"capital_en.txt":                      10446,
+	"faust_de.txt":                        13804,
+	"golang_net_http_server.go":           13160,
+	"mixed3_01_zh_en_code.txt":            25534,
+	"mixed3_02_zh_en_code.txt":            25819,
+	"mixed3_03_zh_en_code.txt":            25129,
+	"mixed5_01_zh_en_de_fr_code.txt":      20867,
+	"mixed5_02_zh_en_de_fr_code.txt":      20994,
+	"mixed5_03_zh_en_de_fr_code.txt":      21062,
+	"mixed5_04_zh_en_de_fr_code.txt":      20594,
+	"mixed5_05_zh_en_de_fr_code.txt":      20695,
+	"toxic_base64.txt":                    34260,
+	"toxic_log.txt":                       22256,
+	"toxic_markdown_table.txt":            24705,
+	"toxic_minified_js.txt":               23553,
+	"toxic_minified_json.txt":             19955,
+}
+
+// datasetError returns the relative error of the estimate for the named dataset under opts.
+func datasetError(t *testing.T, name string, opts Options) float64 {
+	t.Helper()
+	want, ok := datasetTokens[name]
+	if !ok {
+		t.Fatalf("no o200k_base count for %s", name)
+	}
+	data, err := os.ReadFile(filepath.Join("datasets", "test", name))
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	got := EstimateText(string(data), opts).Tokens
+	return float64(got-want) / float64(want)
+}
+
+func TestFastCJKDatasetAccuracy(t *testing.T) {
+	for _, name := range []string{"analects_zh.txt", "mixed3_01_zh_en_code.txt", "mixed3_02_zh_en_code.txt", "mixed3_03_zh_en_code.txt"} {
+		if err := datasetError(t, name, Options{Strategy: StrategyFast}); err < -0.05 || err > 0.05 {
+			t.Fatalf("%s: expected Fast within 5%% of o200k_base, got %+.1f%%", name, err*100)
+		}
+	}
+}
+
+func TestWeightedCJKDatasetAccuracy(t *testing.T) {
+	for _, name := range []string{"analects_zh.txt", "mixed3_01_zh_en_code.txt", "mixed3_02_zh_en_code.txt", "mixed3_03_zh_en_code.txt"} {
+		if err := datasetError(t, name, Options{Strategy: StrategyWeighted}); err < -0.08 || err > 0.08 {
+			t.Fatalf("%s: expected Weighted within 8%% of o200k_base, got %+.1f%%", name, err*100)
+		}
+	}
+}
+
+func TestWeightedDigitDatasetAccuracy(t *testing.T) {
+	for _, name := range []string{"toxic_log.txt", "golang_net_http_server.go"} {
+		if err := datasetError(t, name, Options{Strategy: StrategyWeighted}); err < -0.05 || err > 0.05 {
+			t.Fatalf("%s: expected Weighted within 5%% of o200k_base, got %+.1f%%", name, err*100)
+		}
+	}
+}
+
+func TestZRDatasetAccuracy(t *testing.T) {
+	for _, name := range []string{"analects_zh.txt", "golang_net_http_server.go", "toxic_log.txt", "toxic_markdown_table.txt", "toxic_minified_json.txt"} {
+		if err := datasetError(t, name, Options{Strategy: StrategyZR}); err < -0.07 || err > 0.07 {
+			t.Fatalf("%s: expected ZR within 7%% of o200k_base, got %+.1f%%", name, err*100)
+		}
+	}
+}
+
+func TestWeightedMinifiedDatasetAccuracy(t *testing.T) {
+	for _, name := range []string{"toxic_minified_json.txt", "toxic_minified_js.txt"} {
+		if err := datasetError(t, name, Options{Strategy: StrategyWeighted}); err < -0.10 || err > 0.10 {
+			t.Fatalf("%s: expected Weighted within 10%% of o200k_base, got %+.1f%%", name, err*100)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package tokenest
+
+import "encoding/json"
+
+// Anthropic content block types handled by EstimateAnthropicContent.
+const (
+	ContentBlockText     = "text"
+	ContentBlockToolUse  = "tool_use"
+	ContentBlockThinking = "thinking"
+)
+
+// ContentBlock mirrors an Anthropic Messages API content block. It can be decoded
+// directly from a response's "content" array.
+type ContentBlock struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	Thinking string          `json:"thinking,omitempty"`
+	Name     string          `json:"name,omitempty"`
+	Input    json.RawMessage `json:"input,omitempty"`
+}
+
+// EstimateAnthropicContent estimates tokens for Anthropic content blocks.
+// Text blocks are estimated as prose, tool_use blocks from their name and JSON input,
+// and thinking blocks from their thinking text unless Options.ExcludeThinking is set.
+// Unknown block types fall back to their Text field.
+func EstimateAnthropicContent(blocks []ContentBlock, opts Options) Result {
+	multiplier := opts.GlobalMultiplier
+	opts.GlobalMultiplier = 1.0
+	observer := opts.Observer
+	opts.Observer = nil
+	explain := opts.Explain
+	opts.Explain = false
+
+	// Resolve Auto from the total content size so every block uses the same strategy.
+	size := 0
+	for _, block := range blocks {
+		size += len(block.Name) + len(block.Input) + len(block.Thinking) + len(block.Text)
+	}
+	opts.Strategy = effectiveTextStrategy(opts.Strategy, size)
+
+	result := Result{
+		Strategy: opts.Strategy,
+		Profile:  resolveProfile(opts),
+	}
+	// estimate returns the tokens of one part, keeping the lowest confidence of all parts.
+	estimated := false
+	estimate := func(text string) int {
+		res := EstimateText(text, opts)
+		if !estimated || res.Confidence < result.Confidence {
+			result.Confidence = res.Confidence
+		}
+		estimated = true
+		return res.Tokens
+	}
+
+	perType := make(map[string]int)
+	order := make([]string, 0, 3)
+	total := 0
+	for _, block := range blocks {
+		tokens := 0
+		switch block.Type {
+		case ContentBlockToolUse:
+			tokens = estimate(block.Name) + estimate(string(block.Input))
+		case ContentBlockThinking:
+			if opts.ExcludeThinking {
+				continue
+			}
+			tokens = estimate(block.Thinking)
+		default:
+			tokens = estimate(block.Text)
+		}
+
+		if _, ok := perType[block.Type]; !ok {
+			order = append(order, block.Type)
+		}
+		perType[block.Type] += tokens
+		total += tokens
+	}
+
+	if explain {
+		result.Breakdown = make([]CategoryBreakdown, 0, len(order))
+		for _, blockType := range order {
+			tokens := float64(perType[blockType])
+			result.Breakdown = append(result.Breakdown, CategoryBreakdown{
+				Category:  blockType,
+				BaseUnits: tokens,
+				Weight:    1,
+				Tokens:    tokens,
+			})
+		}
+	}
+
+	result.Tokens = applyMultiplier(total, multiplier)
+	result.setRange()
+	return notify(observer, result)
+}
@@ -0,0 +1,44 @@
+package tokenest
+
+import (
+	"runtime"
+	"sync"
+)
+
+// batchParallelThreshold is the batch size above which EstimateTextBatch fans out across
+// goroutines.
+const batchParallelThreshold = 1024
+
+// EstimateTextBatch estimates each text with the same options. The profile is resolved once for
+// the whole batch; StrategyAuto still resolves per text by size. Batches larger than 1024 texts
+// are split across GOMAXPROCS goroutines; results[i] always corresponds to texts[i].
+func EstimateTextBatch(texts []string, opts Options) []Result {
+	opts.Profile = resolveProfile(opts)
+
+	results := make([]Result, len(texts))
+	workers := runtime.GOMAXPROCS(0)
+	if len(texts) <= batchParallelThreshold || workers <= 1 {
+		for i, text := range texts {
+			results[i] = EstimateText(text, opts)
+		}
+		return results
+	}
+
+	chunk := (len(texts) + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < len(texts); start += chunk {
+		end := start + chunk
+		if end > len(texts) {
+			end = len(texts)
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				results[i] = EstimateText(texts[i], opts)
+			}
+		}(start, end)
+	}
+	wg.Wait()
+	return results
+}
@@ -1,6 +1,8 @@
 package tokenest
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -31,3 +33,64 @@ func BenchmarkWeighted(b *testing.B) {
 		_ = EstimateText(text, opts)
 	}
 }
+
+func BenchmarkWeightedEnglish(b *testing.B) {
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog while considering tokenization. ", 128)
+	opts := Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = EstimateText(text, opts)
+	}
+}
+
+func BenchmarkLanguageConfigMatchASCII(b *testing.B) {
+	segment := "considering"
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = getLanguageSpecificCharsPerToken(segment)
+	}
+}
+
+func BenchmarkEstimateTextBatch(b *testing.B) {
+	texts := make([]string, 4096)
+	for i := range texts {
+		texts[i] = "short message number " + strings.Repeat("x", i%32)
+	}
+	opts := Options{Strategy: StrategyWeighted, Model: "gpt-4o"}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = EstimateTextBatch(texts, opts)
+	}
+}
+
+func BenchmarkWeightedExplain(b *testing.B) {
+	text := "Hello 你好 123, world! " + strings.Repeat("mixed text 42; ", 16)
+	opts := Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI, Explain: true}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = EstimateText(text, opts)
+	}
+}
+
+func BenchmarkWeightedBible(b *testing.B) {
+	benchmarkWeightedDataset(b, "bible_kjv_en.txt")
+}
+
+func BenchmarkWeightedFaust(b *testing.B) {
+	benchmarkWeightedDataset(b, "faust_de.txt")
+}
+
+func benchmarkWeightedDataset(b *testing.B, name string) {
+	data, err := os.ReadFile(filepath.Join("datasets", "test", name))
+	if err != nil {
+		b.Skipf("dataset not available: %v", err)
+	}
+	text := string(data)
+	opts := Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = EstimateText(text, opts)
+	}
+}
@@ -3,8 +3,12 @@ package tokenest
 import (
 	"container/list"
 	"encoding/binary"
+	"hash"
+	"hash/fnv"
 	"hash/maphash"
+	"io"
 	"math"
+	"sort"
 	"sync"
 )
 
@@ -13,25 +17,41 @@ const defaultCacheMinTextBytes = 512
 var cacheSeed = maphash.MakeSeed()
 
 type cacheEntry struct {
-	key   uint64
-	value Result
+	key    uint64
+	value  Result
+	weight int
 }
 
 type lruCache struct {
-	mu    sync.Mutex
-	cap   int
-	ll    *list.List
-	items map[uint64]*list.Element
+	mu       sync.Mutex
+	cap      int
+	maxBytes int
+	bytes    int
+	ll       *list.List
+	items    map[uint64]*list.Element
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
 }
 
-func newLRU(size int) *lruCache {
-	if size <= 0 {
+// newBoundedLRU returns an LRU capped by entry count and, when maxBytes > 0, by the summed
+// weight of its entries. A zero bound is unlimited; nil is returned if both are unlimited.
+func newBoundedLRU(size, maxBytes int) *lruCache {
+	if size < 0 {
+		size = 0
+	}
+	if maxBytes < 0 {
+		maxBytes = 0
+	}
+	if size == 0 && maxBytes == 0 {
 		return nil
 	}
 	return &lruCache{
-		cap:   size,
-		ll:    list.New(),
-		items: make(map[uint64]*list.Element, size),
+		cap:      size,
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[uint64]*list.Element, min(size, 1024)),
 	}
 }
 
@@ -42,70 +62,213 @@ func (c *lruCache) Get(key uint64) (Result, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if elem, ok := c.items[key]; ok {
+		c.hits++
 		c.ll.MoveToFront(elem)
 		return elem.Value.(cacheEntry).value, true
 	}
+	c.misses++
 	return Result{}, false
 }
 
-func (c *lruCache) Add(key uint64, value Result) {
+// Add stores value under key with the given weight (the input size in bytes), evicting from
+// the back until both bounds hold. Entries heavier than the byte budget are not stored, and
+// evict any older entry for key so it is not served in their place.
+func (c *lruCache) Add(key uint64, value Result, weight int) {
 	if c == nil {
 		return
 	}
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.maxBytes > 0 && weight > c.maxBytes {
+		if elem, ok := c.items[key]; ok {
+			c.evict(elem)
+		}
+		return
+	}
 	if elem, ok := c.items[key]; ok {
-		elem.Value = cacheEntry{key: key, value: value}
+		c.bytes += weight - elem.Value.(cacheEntry).weight
+		elem.Value = cacheEntry{key: key, value: value, weight: weight}
 		c.ll.MoveToFront(elem)
-		return
+	} else {
+		c.items[key] = c.ll.PushFront(cacheEntry{key: key, value: value, weight: weight})
+		c.bytes += weight
 	}
 
-	elem := c.ll.PushFront(cacheEntry{key: key, value: value})
-	c.items[key] = elem
-
-	if c.ll.Len() > c.cap {
+	for c.overBudget() {
 		back := c.ll.Back()
-		if back != nil {
-			c.ll.Remove(back)
-			entry := back.Value.(cacheEntry)
-			delete(c.items, entry.key)
+		if back == nil {
+			break
 		}
+		c.evict(back)
+	}
+}
+
+// evict removes elem and counts the eviction. c.mu must be held.
+func (c *lruCache) evict(elem *list.Element) {
+	c.ll.Remove(elem)
+	entry := elem.Value.(cacheEntry)
+	delete(c.items, entry.key)
+	c.bytes -= entry.weight
+	c.evictions++
+}
+
+// Clear removes all entries. Cumulative counters are kept.
+func (c *lruCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	clear(c.items)
+	c.bytes = 0
+}
+
+func (c *lruCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+func (c *lruCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Entries:   c.ll.Len(),
+		Bytes:     c.bytes,
+	}
+}
+
+func (c *lruCache) overBudget() bool {
+	if c.cap > 0 && c.ll.Len() > c.cap {
+		return true
 	}
+	return c.maxBytes > 0 && c.bytes > c.maxBytes
+}
+
+// CacheStats is a snapshot of cache counters. Hits, Misses, and Evictions are cumulative;
+// inputs shorter than the minimum cached size bypass the cache and are not counted.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+
+	// Entries is the current number of cached results.
+	Entries int
+
+	// Bytes is the summed size of the inputs behind the current entries.
+	Bytes int
+}
+
+// CacheStatsProvider is implemented by estimators returned from WithCache and WithCacheOptions.
+// Use a type assertion to read the counters:
+//
+//	if p, ok := est.(tokenest.CacheStatsProvider); ok {
+//		stats := p.Stats()
+//	}
+type CacheStatsProvider interface {
+	Stats() CacheStats
+}
+
+// Clearable is implemented by estimators returned from WithCache and WithCacheOptions, so
+// long-running services can drop stale results after reloading configuration.
+type Clearable interface {
+	// Clear removes all cached results. Hit/miss/eviction counters are kept.
+	Clear()
+	// Len reports the current number of cached results.
+	Len() int
+}
+
+// CacheConfig configures WithCacheOptions.
+type CacheConfig struct {
+	// MaxEntries caps the number of cached results. Zero means no entry limit.
+	MaxEntries int
+
+	// MaxBytes caps the summed size of the inputs whose results are cached, as a proxy for the
+	// memory a large-document workload pins. Inputs larger than MaxBytes are never cached.
+	// Zero means no byte limit.
+	MaxBytes int
+
+	// MinTextBytes is the smallest input cached. Zero uses the default (512).
+	MinTextBytes int
+
+	// DeterministicKeys derives cache keys with FNV-1a instead of a per-process random seed,
+	// so the same input and options produce the same key in every process (e.g., for a shared
+	// or persistent cache layer). The keys equal EstimateKey, EstimateBytesKey, and
+	// EstimateInputKey. They are slightly slower to compute and predictable to anyone who can
+	// choose the inputs.
+	DeterministicKeys bool
 }
 
 // WithCache wraps an estimator with an LRU cache. Caching is opt-in and disabled by default.
 func WithCache(inner Estimator, size int) Estimator {
+	return WithCacheOptions(inner, CacheConfig{MaxEntries: size})
+}
+
+// WithCacheOptions wraps an estimator with an LRU cache bounded by entry count and/or input
+// bytes. If both MaxEntries and MaxBytes are zero, inner is returned unwrapped.
+func WithCacheOptions(inner Estimator, cfg CacheConfig) Estimator {
 	if inner == nil {
 		inner = DefaultEstimator()
 	}
-	cache := newLRU(size)
+	cache := newBoundedLRU(cfg.MaxEntries, cfg.MaxBytes)
 	if cache == nil {
 		return inner
 	}
+	minTextSize := cfg.MinTextBytes
+	if minTextSize <= 0 {
+		minTextSize = defaultCacheMinTextBytes
+	}
 	return &cachedEstimator{
-		inner:       inner,
-		cache:       cache,
-		minTextSize: defaultCacheMinTextBytes,
+		inner:         inner,
+		cache:         cache,
+		minTextSize:   minTextSize,
+		deterministic: cfg.DeterministicKeys,
 	}
 }
 
 type cachedEstimator struct {
-	inner       Estimator
-	cache       *lruCache
-	minTextSize int
+	inner         Estimator
+	cache         *lruCache
+	minTextSize   int
+	deterministic bool
+}
+
+func (c *cachedEstimator) newHash() hash.Hash64 {
+	if c.deterministic {
+		return fnv.New64a()
+	}
+	h := &maphash.Hash{}
+	h.SetSeed(cacheSeed)
+	return h
+}
+
+// Stats reports the cache counters.
+func (c *cachedEstimator) Stats() CacheStats {
+	return c.cache.Stats()
+}
+
+// Clear removes all cached results.
+func (c *cachedEstimator) Clear() {
+	c.cache.Clear()
+}
+
+// Len reports the current number of cached results.
+func (c *cachedEstimator) Len() int {
+	return c.cache.Len()
 }
 
 func (c *cachedEstimator) EstimateBytes(data []byte, opts Options) Result {
 	if len(data) < c.minTextSize {
 		return c.inner.EstimateBytes(data, opts)
 	}
-	key := cacheKeyBytes(data, opts)
+	key := cacheKeyBytes(c.newHash(), data, opts)
 	if val, ok := c.cache.Get(key); ok {
-		return val
+		return notify(opts.Observer, val)
 	}
 	val := c.inner.EstimateBytes(data, opts)
-	c.cache.Add(key, val)
+	c.cache.Add(key, val, len(data))
 	return val
 }
 
@@ -113,12 +276,12 @@ func (c *cachedEstimator) EstimateText(text string, opts Options) Result {
 	if len(text) < c.minTextSize {
 		return c.inner.EstimateText(text, opts)
 	}
-	key := cacheKeyText(text, opts)
+	key := cacheKeyText(c.newHash(), text, opts)
 	if val, ok := c.cache.Get(key); ok {
-		return val
+		return notify(opts.Observer, val)
 	}
 	val := c.inner.EstimateText(text, opts)
-	c.cache.Add(key, val)
+	c.cache.Add(key, val, len(text))
 	return val
 }
 
@@ -126,81 +289,204 @@ func (c *cachedEstimator) EstimateInput(text string, images ImageCounts, message
 	if len(text) < c.minTextSize {
 		return c.inner.EstimateInput(text, images, messageCount, opts)
 	}
-	key := cacheKeyInput(text, images, messageCount, opts)
+	key := cacheKeyInput(c.newHash(), text, images, messageCount, opts)
 	if val, ok := c.cache.Get(key); ok {
-		return val
+		return notify(opts.Observer, val)
 	}
 	val := c.inner.EstimateInput(text, images, messageCount, opts)
-	c.cache.Add(key, val)
+	c.cache.Add(key, val, len(text))
 	return val
 }
 
 func (c *cachedEstimator) EstimateOutput(text string, opts Options) Result {
-	return c.EstimateText(text, opts)
+	if len(text) < c.minTextSize {
+		return c.inner.EstimateOutput(text, opts)
+	}
+	key := cacheKeyOutput(c.newHash(), text, opts)
+	if val, ok := c.cache.Get(key); ok {
+		return notify(opts.Observer, val)
+	}
+	val := c.inner.EstimateOutput(text, opts)
+	c.cache.Add(key, val, len(text))
+	return val
+}
+
+// EstimateKey returns the deterministic cache key for EstimateText(text, opts), the same key a
+// WithCacheOptions cache with DeterministicKeys uses, so an external cache can share entries
+// with it. Keys cover the input and every option that affects the result, with StrategyAuto and
+// ProfileAuto resolved first, so options that estimate identically may still differ in key.
+//
+// Keys are stable across processes and platforms for a given version of this package. They are
+// not stable across versions: a release may add options to the key or change estimates, and
+// either makes old entries stale. Namespace external entries by the module version.
+func EstimateKey(text string, opts Options) uint64 {
+	return cacheKeyText(fnv.New64a(), text, opts)
+}
+
+// EstimateBytesKey is EstimateKey for EstimateBytes(data, opts).
+func EstimateBytesKey(data []byte, opts Options) uint64 {
+	return cacheKeyBytes(fnv.New64a(), data, opts)
 }
 
-func cacheKeyBytes(data []byte, opts Options) uint64 {
+// EstimateInputKey is EstimateKey for EstimateInput(text, images, messageCount, opts).
+func EstimateInputKey(text string, images ImageCounts, messageCount int, opts Options) uint64 {
+	return cacheKeyInput(fnv.New64a(), text, images, messageCount, opts)
+}
+
+func cacheKeyBytes(h hash.Hash64, data []byte, opts Options) uint64 {
 	strategy := effectiveBytesStrategy(opts.Strategy)
 	profile := resolveProfile(opts)
-	return hashKey(strategy, profile, opts, data, ImageCounts{}, 0, 'b')
+	return hashKey(h, strategy, profile, opts, data, ImageCounts{}, 0, 'b')
+}
+
+func cacheKeyText(h hash.Hash64, text string, opts Options) uint64 {
+	strategy := effectiveTextStrategy(opts.Strategy, len(text))
+	profile := resolveProfile(opts)
+	return hashKey(h, strategy, profile, opts, []byte(text), ImageCounts{}, 0, 't')
 }
 
-func cacheKeyText(text string, opts Options) uint64 {
-	strategy := effectiveTextStrategy(opts.Strategy)
+func cacheKeyInput(h hash.Hash64, text string, images ImageCounts, messageCount int, opts Options) uint64 {
+	strategy := effectiveTextStrategy(opts.Strategy, len(text))
 	profile := resolveProfile(opts)
-	return hashKey(strategy, profile, opts, []byte(text), ImageCounts{}, 0, 't')
+	return hashKey(h, strategy, profile, opts, []byte(text), images, messageCount, 'i')
 }
 
-func cacheKeyInput(text string, images ImageCounts, messageCount int, opts Options) uint64 {
-	strategy := effectiveTextStrategy(opts.Strategy)
+func cacheKeyOutput(h hash.Hash64, text string, opts Options) uint64 {
+	strategy := effectiveTextStrategy(opts.Strategy, len(text))
 	profile := resolveProfile(opts)
-	return hashKey(strategy, profile, opts, []byte(text), images, messageCount, 'i')
+	return hashKey(h, strategy, profile, opts, []byte(text), ImageCounts{}, 0, 'o')
 }
 
 func effectiveBytesStrategy(strategy Strategy) Strategy {
+	strategy = defaultStrategy(strategy)
 	if strategy == StrategyAuto {
 		return StrategyUltraFast
 	}
 	return strategy
 }
 
-func effectiveTextStrategy(strategy Strategy) Strategy {
-	if strategy == StrategyAuto {
-		return StrategyFast
+// effectiveTextStrategy resolves StrategyAuto for size bytes of text (see AutoWeightedMaxBytes).
+func effectiveTextStrategy(strategy Strategy, size int) Strategy {
+	strategy = defaultStrategy(strategy)
+	if strategy != StrategyAuto {
+		return strategy
 	}
-	return strategy
+	if size < AutoWeightedMaxBytes {
+		return StrategyWeighted
+	}
+	return StrategyFast
 }
 
-func hashKey(strategy Strategy, profile Profile, opts Options, data []byte, images ImageCounts, messageCount int, kind byte) uint64 {
-	var h maphash.Hash
-	h.SetSeed(cacheSeed)
-
-	writeUint64(&h, uint64(kind))
-	writeUint64(&h, uint64(strategy))
-	writeUint64(&h, uint64(profile))
-	writeUint64(&h, math.Float64bits(opts.GlobalMultiplier))
-	writeUint64(&h, boolToUint64(opts.Explain))
-	writeUint64(&h, uint64(messageCount))
-	writeUint64(&h, uint64(images.LowDetail))
-	writeUint64(&h, uint64(images.HighDetail))
-	writeUint64(&h, uint64(images.Unknown))
-	writeUint64(&h, uint64(BaseOverhead))
-	writeUint64(&h, uint64(PerMessageOverhead))
-	writeUint64(&h, uint64(ImageTokensLow))
-	writeUint64(&h, uint64(ImageTokensHigh))
-	writeUint64(&h, uint64(ImageTokensDefault))
+func hashKey(h hash.Hash64, strategy Strategy, profile Profile, opts Options, data []byte, images ImageCounts, messageCount int, kind byte) uint64 {
+	writeUint64(h, uint64(kind))
+	writeUint64(h, uint64(strategy))
+	writeUint64(h, uint64(profile))
+	writeUint64(h, math.Float64bits(opts.GlobalMultiplier))
+	writeUint64(h, math.Float64bits(opts.FastSampleFraction))
+	writeUint64(h, uint64(opts.FastSampleWindows))
+	writeUint64(h, boolToUint64(opts.Explain))
+	// Key Normalize by the normalizer generation, so entries cached before a RegisterNormalizer
+	// call stop matching.
+	var normalizer uint64
+	if opts.Normalize {
+		_, generation := currentNormalizer()
+		normalizer = generation + 1
+	}
+	writeUint64(h, normalizer)
+	// Hash the Strict outcome rather than the flag: whether it warns also depends on Model,
+	// ProviderType, and SetDefaults, which the resolved strategy and profile do not capture.
+	var strict string
+	if err := opts.strictError(strategy); err != nil {
+		strict = err.Error()
+	}
+	writeString(h, strict)
+	writeWeights(h, opts.CustomWeights)
+	writeRuneRanges(h, opts.CJKRanges)
+	writeString(h, opts.Language)
+	// Registered languages change Weighted estimates, so entries cached before a
+	// RegisterLanguage call stop matching.
+	writeUint64(h, currentLanguages().fingerprint)
+	writeUint64(h, uint64(opts.ShortTokenThreshold))
+	writeSpecialTokens(h, opts.SpecialTokens)
+	writeUint64(h, opts.ZRConfig.Fingerprint())
+	writeUint64(h, uint64(messageCount))
+	writeUint64(h, uint64(images.LowDetail))
+	writeUint64(h, uint64(images.HighDetail))
+	writeUint64(h, uint64(images.Unknown))
+	overhead := InputOverhead(opts)
+	writeUint64(h, uint64(overhead.Base))
+	writeUint64(h, uint64(overhead.PerMessage))
+	writeUint64(h, uint64(overhead.ImageLow))
+	writeUint64(h, uint64(overhead.ImageHigh))
+	writeUint64(h, uint64(overhead.ImageDefault))
+	writeUint64(h, uint64(OutputOverhead(opts)))
 
 	h.Write(data)
 
 	return h.Sum64()
 }
 
-func writeUint64(h *maphash.Hash, v uint64) {
+func writeUint64(h hash.Hash64, v uint64) {
 	var buf [8]byte
 	binary.LittleEndian.PutUint64(buf[:], v)
 	h.Write(buf[:])
 }
 
+func writeString(h hash.Hash64, s string) {
+	writeUint64(h, uint64(len(s)))
+	io.WriteString(h, s)
+}
+
+func writeWeights(h hash.Hash64, w *Weights) {
+	if w == nil {
+		writeUint64(h, 0)
+		return
+	}
+	writeUint64(h, 1)
+	writeUint64(h, math.Float64bits(w.BaseFactor))
+	writeUint64(h, math.Float64bits(w.CJKRatioFactor))
+	writeUint64(h, math.Float64bits(w.PunctRatioFactor))
+	writeUint64(h, math.Float64bits(w.DigitRatioFactor))
+	writeUint64(h, math.Float64bits(w.EmbeddedDigitRatioFactor))
+	writeUint64(h, math.Float64bits(w.URLDelimRatioFactor))
+	writeUint64(h, math.Float64bits(w.MathRatioFactor))
+	writeUint64(h, math.Float64bits(w.ClampMin))
+	writeUint64(h, math.Float64bits(w.ClampMax))
+	writeUint64(h, uint64(w.ShortTokenThreshold))
+	writeUint64(h, math.Float64bits(w.CharsPerToken))
+	writeUint64(h, uint64(w.DigitsPerToken))
+	writeUint64(h, math.Float64bits(w.SymbolsPerToken))
+	writeUint64(h, uint64(w.WhitespaceRunesPerToken))
+	writeUint64(h, math.Float64bits(w.HangulTokensPerSyllable))
+	writeString(h, w.Punctuation)
+}
+
+func writeRuneRanges(h hash.Hash64, ranges []RuneRange) {
+	if ranges == nil {
+		writeUint64(h, 0)
+		return
+	}
+	writeUint64(h, uint64(len(ranges))+1)
+	for _, rr := range ranges {
+		writeUint64(h, uint64(rr.Lo))
+		writeUint64(h, uint64(rr.Hi))
+	}
+}
+
+// writeSpecialTokens hashes special in key order so equal maps produce equal keys.
+func writeSpecialTokens(h hash.Hash64, special map[string]int) {
+	keys := make([]string, 0, len(special))
+	for key := range special {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	writeUint64(h, uint64(len(keys)))
+	for _, key := range keys {
+		writeString(h, key)
+		writeUint64(h, uint64(special[key]))
+	}
+}
+
 func boolToUint64(v bool) uint64 {
 	if v {
 		return 1
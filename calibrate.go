@@ -0,0 +1,27 @@
+package tokenest
+
+// CalibrateMultiplier fits a GlobalMultiplier to observed token counts: it estimates each
+// sample with strategy and profile and returns sum(actual) / sum(estimates), so a strategy
+// that underestimates a workload by 8% yields about 1.087. Plug the result into
+// Options.GlobalMultiplier with the same Strategy and Profile. Because the multiplier scales
+// totals, it corrects the aggregate bias of the workload, not the error of individual samples;
+// use tools/fit to refit the underlying weights.
+//
+// samples and actual are paired by index; extra entries in the longer slice are ignored, as
+// are pairs with a negative actual count. It returns 1.0 when there is nothing to fit, such as
+// when no pair remains or every estimate is zero.
+func CalibrateMultiplier(samples []string, actual []int, strategy Strategy, profile Profile) float64 {
+	opts := Options{Strategy: strategy, Profile: profile}
+	var estimated, observed int64
+	for i := 0; i < len(samples) && i < len(actual); i++ {
+		if actual[i] < 0 {
+			continue
+		}
+		estimated += int64(EstimateText(samples[i], opts).Tokens)
+		observed += int64(actual[i])
+	}
+	if estimated == 0 || observed == 0 {
+		return 1.0
+	}
+	return float64(observed) / float64(estimated)
+}
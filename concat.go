@@ -0,0 +1,137 @@
+package tokenest
+
+import "strings"
+
+// EstimateConcat estimates parts as if they were joined with sep (strings.Join(parts, sep)),
+// counting sep once per gap, without allocating the joined text. With StrategyAuto the
+// strategy is resolved from the joined size.
+//
+// Weighted matches EstimateText on the joined text: parts are segmented in place and only the
+// segments that touch a separator are re-estimated together with it. UltraFast and Fast stream
+// the parts through an Accumulator, so Fast counts every rune like EstimateReader instead of
+// sampling. ZR classifies the whole input at once and joins the parts, as does Weighted with a
+// URLDelimRatioFactor or MathRatioFactor, whose counts depend on the surrounding text.
+// Normalize and SpecialTokens apply to each part and to sep separately; UltraFast ignores both,
+// as in EstimateText.
+func EstimateConcat(parts []string, sep string, opts Options) Result {
+	size := 0
+	for _, part := range parts {
+		size += len(part)
+	}
+	if len(parts) > 1 {
+		size += (len(parts) - 1) * len(sep)
+	}
+	strategy := effectiveTextStrategy(opts.Strategy, size)
+	opts.Strategy = strategy
+	if strategy == StrategyZR || strategy == StrategyWeighted && weightsForOptions(opts).contextual() {
+		return EstimateText(strings.Join(parts, sep), opts)
+	}
+
+	multiplier := opts.GlobalMultiplier
+	opts.GlobalMultiplier = 1.0
+	observer := opts.Observer
+	opts.Observer = nil
+
+	specialTokens, specialMatches := 0, 0
+	prepare := func(text string) string {
+		if opts.Normalize && strategy != StrategyUltraFast {
+			text = normalizeText(text)
+		}
+		text, tokens, matches := extractSpecialTokens(text, specialTokensFor(opts, strategy))
+		specialTokens += tokens
+		specialMatches += matches
+		return text
+	}
+	sep = prepare(sep)
+
+	var tokens int
+	var breakdown []CategoryBreakdown
+	switch strategy {
+	case StrategyWeighted:
+		var c weightedConcat
+		c.params = segmentationForOptions(opts)
+		for i, part := range parts {
+			if i > 0 {
+				c.carry += sep
+			}
+			c.add(prepare(part))
+		}
+		c.estimate(c.carry)
+		if opts.Explain {
+			breakdown = make([]CategoryBreakdown, 0)
+		}
+		tokens = applyWeightedTuning(c.baseTokens, c.stats, weightsForOptions(opts), opts.Explain, &breakdown)
+	default:
+		acc := NewAccumulator(opts)
+		for i, part := range parts {
+			if i > 0 {
+				acc.Write([]byte(sep))
+			}
+			acc.Write([]byte(prepare(part)))
+		}
+		tokens = acc.Tokens()
+	}
+	tokens += specialTokens
+	if opts.Explain {
+		breakdown = appendSpecialTokensBreakdown(breakdown, specialTokens, specialMatches)
+	}
+
+	result := Result{
+		Tokens:    applyMultiplier(tokens, multiplier),
+		Strategy:  strategy,
+		Profile:   resolveProfile(opts),
+		Breakdown: breakdown,
+	}
+	head := ""
+	if len(parts) > 0 {
+		head = parts[0]
+	}
+	result.setConfidence(strategy, quickContent(head))
+	return notify(observer, result)
+}
+
+// weightedConcat accumulates the tokenx base count and stats of a sequence of texts as if they
+// were concatenated. Segment boundaries inside a text are boundaries of the joined text too, so
+// only carry, the text since the last boundary known to be final, is estimated across joins.
+type weightedConcat struct {
+	params     tokenXParams
+	baseTokens int
+	stats      tokenXStats
+	carry      string
+}
+
+func (c *weightedConcat) add(text string) {
+	classes := c.params.classTable()
+	head := firstTokenXSegmentEnd(text, classes)
+	if head == len(text) {
+		// A single segment may still merge with whatever follows.
+		c.carry += text
+		return
+	}
+	c.estimate(c.carry + text[:head])
+	tail := lastTokenXSegmentStart(text, classes)
+	c.estimate(text[head:tail])
+	c.carry = text[tail:]
+}
+
+func (c *weightedConcat) estimate(text string) {
+	forEachTokenXSegment(text, c.params.classTable(), func(start, end int) {
+		c.baseTokens += estimateTokenXSegment(text[start:end], c.params, &c.stats)
+	})
+}
+
+// firstTokenXSegmentEnd returns the byte offset where the leading tokenx segment of text ends.
+func firstTokenXSegmentEnd(text string, classes *tokenXClasses) int {
+	var first tokenXSegmentType
+	for idx, r := range text {
+		current := classes.typeAt(text, idx, r)
+		if idx == 0 {
+			first = current
+			continue
+		}
+		if current != first {
+			return idx
+		}
+	}
+	return len(text)
+}
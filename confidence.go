@@ -0,0 +1,135 @@
+package tokenest
+
+import "math"
+
+// errorBand returns the expected relative error of strategy on content. The bands are coarse
+// and derived from the accuracy reports: sampling/byte heuristics drift on code and structured
+// text, and every strategy struggles on base64/hex blobs.
+func errorBand(strategy Strategy, content ContentType) float64 {
+	blob := content == ContentBase64 || content == ContentHex
+	structured := content == ContentCode || content == ContentJSON || content == ContentLog || content == ContentMarkdown
+
+	switch strategy {
+	case StrategyUltraFast:
+		switch {
+		case blob:
+			return 0.6
+		case structured:
+			return 0.3
+		default:
+			return 0.25
+		}
+	case StrategyFast:
+		switch {
+		case blob:
+			return 0.5
+		case structured:
+			return 0.2
+		default:
+			return 0.15
+		}
+	case StrategyWeighted, StrategyZR:
+		switch {
+		case blob:
+			return 0.35
+		case structured:
+			return 0.12
+		default:
+			return 0.08
+		}
+	default:
+		return 0.6
+	}
+}
+
+// setConfidence fills Confidence, MinTokens, and MaxTokens from the band for strategy and content.
+func (r *Result) setConfidence(strategy Strategy, content ContentType) {
+	r.Confidence = 1 - errorBand(strategy, content)
+	r.setRange()
+}
+
+// setRange recomputes MinTokens/MaxTokens around Tokens from Confidence.
+func (r *Result) setRange() {
+	band := 1 - r.Confidence
+	r.MinTokens = saturatingInt(math.Floor(float64(r.Tokens) * (1 - band)))
+	r.MaxTokens = saturatingInt(math.Ceil(float64(r.Tokens) * (1 + band)))
+}
+
+// confidenceSampleBytes bounds the head window classified for confidence, keeping the
+// UltraFast path O(1).
+const confidenceSampleBytes = 128
+
+const (
+	byteClassSpace uint8 = 1 << iota
+	byteClassHex
+	byteClassBase64
+	byteClassStructural
+)
+
+var byteClasses = func() (classes [256]uint8) {
+	for b := 0; b < 256; b++ {
+		r := rune(b)
+		switch r {
+		case ' ', '\t', '\r', '\n':
+			classes[b] |= byteClassSpace
+		case '{', '}', '[', ']', ';', '=', '<', '>', '"':
+			classes[b] |= byteClassStructural
+		}
+		if isHexRune(r) {
+			classes[b] |= byteClassHex
+		}
+		if isBase64Rune(r) {
+			classes[b] |= byteClassBase64
+		}
+	}
+	return classes
+}()
+
+// quickContent is a byte-level classifier over the head of the input used for confidence bands.
+// It only separates blobs (hex/base64), structured text (JSON/code/markup), and prose, and
+// reports structured text as ContentCode. Use SniffContentType for a full classification.
+func quickContent[T string | []byte](data T) ContentType {
+	n := len(data)
+	if n > confidenceSampleBytes {
+		n = confidenceSampleBytes
+	}
+	if n == 0 {
+		return ContentUnknown
+	}
+
+	space, hex, b64, structural := 0, 0, 0, 0
+	first := byte(0)
+	for i := 0; i < n; i++ {
+		class := byteClasses[data[i]]
+		if class&byteClassSpace != 0 {
+			space++
+			continue
+		}
+		if first == 0 {
+			first = data[i]
+		}
+		if class&byteClassHex != 0 {
+			hex++
+		}
+		if class&byteClassBase64 != 0 {
+			b64++
+		}
+		if class&byteClassStructural != 0 {
+			structural++
+		}
+	}
+
+	visible := n - space
+	if visible >= sniffBlobMinRunes && space == 0 {
+		if float64(hex)/float64(visible) >= sniffBlobMinRatio {
+			return ContentHex
+		}
+		if float64(b64)/float64(visible) >= sniffBlobMinRatio {
+			return ContentBase64
+		}
+	}
+	if first == '{' || first == '[' || (visible > 0 && float64(structural)/float64(visible) >= sniffCodePunctDensity) {
+		return ContentCode
+	}
+	return ContentProse
+}
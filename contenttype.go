@@ -0,0 +1,224 @@
+package tokenest
+
+import "strings"
+
+// ContentType is a coarse classification of text content.
+type ContentType int
+
+const (
+	// ContentUnknown is returned for empty input.
+	ContentUnknown ContentType = iota
+
+	// ContentProse is natural-language text (the fallback).
+	ContentProse
+
+	// ContentJSON is a JSON object or array, including minified JSON.
+	ContentJSON
+
+	// ContentMarkdown is text dominated by headings, lists, tables, or fences.
+	ContentMarkdown
+
+	// ContentCode is source code, including minified scripts.
+	ContentCode
+
+	// ContentLog is line-oriented log output with leading timestamps or levels.
+	ContentLog
+
+	// ContentBase64 is an unbroken base64 blob.
+	ContentBase64
+
+	// ContentHex is an unbroken hexadecimal stream.
+	ContentHex
+)
+
+func (c ContentType) String() string {
+	switch c {
+	case ContentUnknown:
+		return "unknown"
+	case ContentProse:
+		return "prose"
+	case ContentJSON:
+		return "json"
+	case ContentMarkdown:
+		return "markdown"
+	case ContentCode:
+		return "code"
+	case ContentLog:
+		return "log"
+	case ContentBase64:
+		return "base64"
+	case ContentHex:
+		return "hex"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	sniffBlobMinRunes         = 32
+	sniffBlobMinRatio         = 0.98
+	sniffLogLineRatio         = 0.5
+	sniffMarkdownLineRatio    = 0.3
+	sniffCodeLineRatio        = 0.3
+	sniffCodePunctDensity     = 0.08
+	sniffMinifiedLineMinRunes = 200
+)
+
+// SniffContentType classifies text with cheap heuristics over the same head/mid/tail sample
+// used by the Fast strategy, so the cost is O(min(n, 1000)).
+func SniffContentType(text string) ContentType {
+	sample := sampleFastText(text)
+	trimmed := strings.TrimSpace(sample)
+	if trimmed == "" {
+		return ContentUnknown
+	}
+
+	if looksLikeJSON(trimmed) {
+		return ContentJSON
+	}
+
+	total, hex, b64 := 0, 0, 0
+	for _, r := range trimmed {
+		if r == '\n' || r == '\r' {
+			continue
+		}
+		total++
+		if isHexRune(r) {
+			hex++
+		}
+		if isBase64Rune(r) {
+			b64++
+		}
+	}
+	if total >= sniffBlobMinRunes {
+		if float64(hex)/float64(total) >= sniffBlobMinRatio {
+			return ContentHex
+		}
+		if float64(b64)/float64(total) >= sniffBlobMinRatio {
+			return ContentBase64
+		}
+	}
+
+	lines, logLines, mdLines, codeLines := 0, 0, 0, 0
+	for _, line := range strings.Split(trimmed, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		lines++
+		if isLogLine(line) {
+			logLines++
+		}
+		if isMarkdownLine(line) {
+			mdLines++
+		}
+		if isCodeLine(line) {
+			codeLines++
+		}
+	}
+	if lines > 0 {
+		switch {
+		case float64(logLines)/float64(lines) >= sniffLogLineRatio:
+			return ContentLog
+		case float64(mdLines)/float64(lines) >= sniffMarkdownLineRatio:
+			return ContentMarkdown
+		case float64(codeLines)/float64(lines) >= sniffCodeLineRatio:
+			return ContentCode
+		}
+	}
+
+	if lines <= 2 && total >= sniffMinifiedLineMinRunes && codePunctDensity(trimmed) >= sniffCodePunctDensity {
+		return ContentCode
+	}
+
+	return ContentProse
+}
+
+// looksLikeJSON checks the opening of the sample only, since long inputs may be truncated.
+func looksLikeJSON(trimmed string) bool {
+	rest := strings.TrimLeft(trimmed[1:], " \t\r\n")
+	if rest == "" {
+		return trimmed[0] == '{' || trimmed[0] == '['
+	}
+	next := rest[0]
+	switch trimmed[0] {
+	case '{':
+		return next == '"' || next == '}'
+	case '[':
+		return next == '{' || next == '[' || next == '"' || next == ']' || next == '-' || (next >= '0' && next <= '9')
+	default:
+		return false
+	}
+}
+
+func isHexRune(r rune) bool {
+	return (r >= '0' && r <= '9') || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F')
+}
+
+func isBase64Rune(r rune) bool {
+	return (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
+		r == '+' || r == '/' || r == '=' || r == '-' || r == '_'
+}
+
+func isLogLine(line string) bool {
+	line = strings.TrimPrefix(line, "[")
+	if len(line) >= 5 && isASCIIDigits(line[:4]) && (line[4] == '-' || line[4] == '/') {
+		return true
+	}
+	for _, level := range []string{"TRACE", "DEBUG", "INFO", "WARN", "ERROR", "FATAL"} {
+		if strings.HasPrefix(line, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func isMarkdownLine(line string) bool {
+	for _, prefix := range []string{"#", "- ", "* ", "> ", "|", "```"} {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	dot := strings.Index(line, ". ")
+	return dot > 0 && dot <= 3 && isASCIIDigits(line[:dot])
+}
+
+func isCodeLine(line string) bool {
+	switch line[len(line)-1] {
+	case ';', '{', '}':
+		return true
+	}
+	for _, prefix := range []string{"func ", "def ", "class ", "import ", "package ", "return ", "const ", "var ", "let ", "#include", "//"} {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func codePunctDensity(text string) float64 {
+	total, punct := 0, 0
+	for _, r := range text {
+		total++
+		switch r {
+		case '{', '}', '(', ')', ';', '=':
+			punct++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(punct) / float64(total)
+}
+
+func isASCIIDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,35 @@
+package tokenest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSniffContentTypeDatasets(t *testing.T) {
+	cases := map[string]ContentType{
+		"toxic_minified_json.txt":            ContentJSON,
+		"toxic_minified_js.txt":              ContentCode,
+		"toxic_log.txt":                      ContentLog,
+		"toxic_markdown_table.txt":           ContentMarkdown,
+		"toxic_base64.txt":                   ContentBase64,
+		"adversary_tokenx_05_hex_stream.txt": ContentHex,
+		"golang_net_http_server.go":          ContentCode,
+		"bible_kjv_en.txt":                   ContentProse,
+	}
+	for name, want := range cases {
+		data, err := os.ReadFile(filepath.Join("datasets", "test", name))
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		if got := SniffContentType(string(data)); got != want {
+			t.Errorf("%s: expected %v, got %v", name, want, got)
+		}
+	}
+}
+
+func TestSniffContentTypeEmpty(t *testing.T) {
+	if got := SniffContentType("  \n"); got != ContentUnknown {
+		t.Fatalf("expected ContentUnknown, got %v", got)
+	}
+}
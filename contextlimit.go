@@ -0,0 +1,107 @@
+package tokenest
+
+import (
+	"context"
+	"strings"
+
+	zrstrategy "github.com/EZ-Api/tokenest/strategy"
+)
+
+// contextLimits maps model-name prefixes to context window sizes in tokens.
+// The longest matching prefix wins, so specific variants can override their family.
+var contextLimits = []struct {
+	prefix string
+	limit  int
+}{
+	{"gpt-3.5-turbo", 16_385},
+	{"gpt-4", 8_192},
+	{"gpt-4-32k", 32_768},
+	{"gpt-4-turbo", 128_000},
+	{"gpt-4o", 128_000},
+	{"chatgpt-4o", 128_000},
+	{"gpt-4.1", 1_047_576},
+	{"gpt-4.5", 128_000},
+	{"gpt-5", 400_000},
+	{"o1", 200_000},
+	{"o1-mini", 128_000},
+	{"o3", 200_000},
+	{"o4-mini", 200_000},
+	{"claude", 200_000},
+	{"gemini-1.0-pro", 32_760},
+	{"gemini-pro", 32_760},
+	{"gemini-1.5-flash", 1_048_576},
+	{"gemini-1.5-pro", 2_097_152},
+	{"gemini-2.0", 1_048_576},
+	{"gemini-2.5", 1_048_576},
+}
+
+// ContextLimit returns the context window, in tokens, of a known model such as "gpt-4o",
+// "claude-3-5-sonnet-20241022", or "gemini-1.5-pro". Matching is by case-insensitive prefix
+// after any "provider/" path (e.g., "models/gemini-1.5-pro"); the longest prefix wins.
+func ContextLimit(model string) (int, bool) {
+	model = strings.ToLower(strings.TrimSpace(model))
+	if i := strings.LastIndexByte(model, '/'); i >= 0 {
+		model = model[i+1:]
+	}
+	if model == "" {
+		return 0, false
+	}
+
+	limit, best := 0, -1
+	for _, entry := range contextLimits {
+		if strings.HasPrefix(model, entry.prefix) && len(entry.prefix) > best {
+			limit, best = entry.limit, len(entry.prefix)
+		}
+	}
+	return limit, best >= 0
+}
+
+// FitsContext estimates text and compares it with model's context window (see ContextLimit).
+// An empty opts.Model is set to model for profile resolution. For unknown models limit is 0
+// and fits is true; use ContextLimit to tell them apart.
+func FitsContext(text string, model string, opts Options) (fits bool, estimated int, limit int) {
+	if opts.Model == "" {
+		opts.Model = model
+	}
+	estimated = EstimateText(text, opts).Tokens
+
+	limit, ok := ContextLimit(model)
+	if !ok {
+		return true, estimated, 0
+	}
+	return estimated <= limit, estimated, limit
+}
+
+// ExceedsTokens reports whether EstimateText(text, opts).Tokens > limit, stopping as soon as
+// the answer is known, for admission control on payloads that may be far over a cap.
+// UltraFast answers from the byte length. Weighted and ZR can never estimate below their clamp
+// floor (ClampMin, or ZR's 0.5, times the running tokenx base count), so they stop segmenting
+// once that floor is over limit; only inputs near or under limit are scanned in full. Fast
+// samples a bounded prefix/middle/suffix anyway and simply estimates. Normalize and
+// SpecialTokens (except under UltraFast) are applied first, as in EstimateText, and so still
+// scan the whole input.
+func ExceedsTokens(text string, limit int, opts Options) bool {
+	opts.Observer = nil
+	strategy := effectiveTextStrategy(opts.Strategy, len(text))
+	if strategy != StrategyUltraFast && strategy != StrategyWeighted && strategy != StrategyZR {
+		return EstimateText(text, opts).Tokens > limit
+	}
+
+	if opts.Normalize && strategy != StrategyUltraFast {
+		text = normalizeText(text)
+	}
+	text, specialTokens, _ := extractSpecialTokens(text, specialTokensFor(opts, strategy))
+	exceeds := func(tokens int) bool {
+		return applyMultiplier(tokens+specialTokens, opts.GlobalMultiplier) > limit
+	}
+
+	switch strategy {
+	case StrategyUltraFast:
+		return exceeds(estimateUltraFastText(text))
+	case StrategyWeighted:
+		return weightedExceeds(text, weightsForOptions(opts), segmentationForOptions(opts), exceeds)
+	default:
+		exceeded, _ := zrstrategy.ExceedsZRCtx(context.Background(), text, opts.ZRConfig, exceeds)
+		return exceeded
+	}
+}
@@ -0,0 +1,35 @@
+package tokenest
+
+// defaults holds what StrategyAuto and ProfileAuto resolve to when set by SetDefaults.
+// ProfileAuto and StrategyAuto here mean the built-in resolution.
+var defaults struct {
+	strategy Strategy
+	profile  Profile
+}
+
+// SetDefaults changes what StrategyAuto and ProfileAuto resolve to process-wide, so a codebase
+// that always estimates the same way can configure it once at startup and pass Options{}
+// thereafter:
+//
+//	tokenest.SetDefaults(tokenest.Options{Strategy: tokenest.StrategyWeighted, Profile: tokenest.ProfileOpenAI})
+//
+// Only opts.Strategy and opts.Profile are used. A non-Auto Strategy replaces the size- and
+// input-based choice for every entry point, including EstimateBytes, EstimateReader, and
+// EstimateTools; a non-Auto Profile is used when a call names neither a Profile nor a Model or
+// ProviderType. Explicit values in a call's Options always win. Passing Auto for either field
+// restores the built-in resolution.
+//
+// Call it during initialization, before estimating. It is not safe to call concurrently with
+// estimation, and results cached before it (see WithCache) are not invalidated.
+func SetDefaults(opts Options) {
+	defaults.strategy = opts.Strategy
+	defaults.profile = opts.Profile
+}
+
+// defaultStrategy resolves StrategyAuto to the SetDefaults strategy, if any.
+func defaultStrategy(strategy Strategy) Strategy {
+	if strategy == StrategyAuto {
+		return defaults.strategy
+	}
+	return strategy
+}
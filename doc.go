@@ -7,7 +7,13 @@
 //   - ZR: O(n) categorical tuning with ZR coefficients for mixed inputs (opt-in)
 //
 // The library supports automatic strategy selection and model-aware profiles for
-// different providers (OpenAI, Claude, Gemini).
+// different providers (OpenAI, Claude, Gemini, Qwen).
+//
+// Empty input is 0 tokens in every strategy. Non-empty input made only of whitespace costs one
+// token per 16 runes, rounded up, in every strategy: tokenizers merge a short whitespace run
+// ("   ", "\n\n\n", "\t\t") into one token, but have no single token for long runs. Within
+// other text, Weighted charges indentation only when Weights.WhitespaceRunesPerToken is set.
+// The global multiplier applies on top as usual.
 //
 // Basic usage:
 //
@@ -0,0 +1,31 @@
+package tokenest
+
+import "errors"
+
+// Sentinel errors returned by validation paths. Returned errors wrap one of these together with
+// the offending value, so callers should compare with errors.Is.
+var (
+	// ErrInvalidMultiplier reports a GlobalMultiplier that is negative, NaN, or infinite.
+	ErrInvalidMultiplier = errors.New("tokenest: invalid multiplier")
+
+	// ErrUnknownStrategy reports a Strategy value outside the defined constants.
+	ErrUnknownStrategy = errors.New("tokenest: unknown strategy")
+
+	// ErrUnknownProfile reports a Profile value or name that does not map to a known profile.
+	ErrUnknownProfile = errors.New("tokenest: unknown profile")
+
+	// ErrConfigInvalid reports a configuration file or value that cannot be parsed.
+	ErrConfigInvalid = errors.New("tokenest: invalid config")
+
+	// ErrIneffectiveOption reports an Options value that is out of range or ignored by the
+	// selected strategy (see Options.Validate).
+	ErrIneffectiveOption = errors.New("tokenest: ineffective option")
+
+	// ErrStrict reports an estimate that Options.Strict refused because the options could not
+	// be honored exactly: they fail Validate, or the strategy or profile was left to automatic
+	// resolution.
+	ErrStrict = errors.New("tokenest: strict mode")
+
+	// ErrInvalidJSON reports EstimateJSON input that is not a single valid JSON value.
+	ErrInvalidJSON = errors.New("tokenest: invalid JSON")
+)
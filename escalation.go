@@ -0,0 +1,114 @@
+package tokenest
+
+// defaultEscalationFraction is the UltraFast/Fast disagreement WithEscalation uses when no
+// predicate is given.
+const defaultEscalationFraction = 0.15
+
+// WithEscalation returns an Estimator that answers with fast and switches to accurate for
+// inputs predicate reports as hard. Both estimators receive the caller's Options unchanged,
+// so they usually pin their strategy (see WithStrategy). EstimateBytes passes the data to
+// predicate as text. A nil predicate uses EscalateOnDisagreement(0.15), and nil estimators
+// use DefaultEstimator.
+//
+//	est := tokenest.WithEscalation(
+//	    tokenest.WithStrategy(nil, tokenest.StrategyFast),
+//	    tokenest.WithStrategy(nil, tokenest.StrategyWeighted),
+//	    tokenest.EscalateOnDisagreement(0.1),
+//	)
+func WithEscalation(fast, accurate Estimator, predicate func(text string) bool) Estimator {
+	if fast == nil {
+		fast = DefaultEstimator()
+	}
+	if accurate == nil {
+		accurate = DefaultEstimator()
+	}
+	if predicate == nil {
+		predicate = EscalateOnDisagreement(defaultEscalationFraction)
+	}
+	return escalatingEstimator{fast: fast, accurate: accurate, predicate: predicate}
+}
+
+// EscalateOnDisagreement returns a WithEscalation predicate that reports text as hard when the
+// UltraFast and Fast estimates differ by more than fraction of the larger one. The two agree
+// on plain prose; punctuation-dense text such as JSON or code, base64-like runs, long
+// whitespace runs, and invalid UTF-8 pull Fast away from bytes/4. Han-heavy text escalates
+// too: Fast costs each Han character per rune, while UltraFast's bytes/4 undercounts them.
+// Both estimates are cheap, so the predicate adds little to a Fast call.
+func EscalateOnDisagreement(fraction float64) func(text string) bool {
+	return func(text string) bool {
+		ultra := estimateUltraFastText(text)
+		fast := estimateFast(text, 0, 0, false, nil)
+		larger := max(ultra, fast)
+		if larger == 0 {
+			return false
+		}
+		diff := ultra - fast
+		if diff < 0 {
+			diff = -diff
+		}
+		return float64(diff) > fraction*float64(larger)
+	}
+}
+
+type escalatingEstimator struct {
+	fast      Estimator
+	accurate  Estimator
+	predicate func(text string) bool
+}
+
+func (e escalatingEstimator) pick(text string) Estimator {
+	if e.predicate(text) {
+		return e.accurate
+	}
+	return e.fast
+}
+
+func (e escalatingEstimator) EstimateBytes(data []byte, opts Options) Result {
+	return e.pick(string(data)).EstimateBytes(data, opts)
+}
+
+func (e escalatingEstimator) EstimateText(text string, opts Options) Result {
+	return e.pick(text).EstimateText(text, opts)
+}
+
+func (e escalatingEstimator) EstimateInput(text string, images ImageCounts, messageCount int, opts Options) Result {
+	return e.pick(text).EstimateInput(text, images, messageCount, opts)
+}
+
+func (e escalatingEstimator) EstimateOutput(text string, opts Options) Result {
+	return e.pick(text).EstimateOutput(text, opts)
+}
+
+// WithStrategy returns an Estimator that calls inner with Options.Strategy set to strategy,
+// for composing estimators with WithEscalation. A nil inner uses DefaultEstimator.
+func WithStrategy(inner Estimator, strategy Strategy) Estimator {
+	if inner == nil {
+		inner = DefaultEstimator()
+	}
+	return strategyEstimator{inner: inner, strategy: strategy}
+}
+
+type strategyEstimator struct {
+	inner    Estimator
+	strategy Strategy
+}
+
+func (s strategyEstimator) EstimateBytes(data []byte, opts Options) Result {
+	opts.Strategy = s.strategy
+	return s.inner.EstimateBytes(data, opts)
+}
+
+func (s strategyEstimator) EstimateText(text string, opts Options) Result {
+	opts.Strategy = s.strategy
+	return s.inner.EstimateText(text, opts)
+}
+
+func (s strategyEstimator) EstimateInput(text string, images ImageCounts, messageCount int, opts Options) Result {
+	opts.Strategy = s.strategy
+	return s.inner.EstimateInput(text, images, messageCount, opts)
+}
+
+func (s strategyEstimator) EstimateOutput(text string, opts Options) Result {
+	opts.Strategy = s.strategy
+	return s.inner.EstimateOutput(text, opts)
+}
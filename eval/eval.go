@@ -0,0 +1,100 @@
+// Package eval measures tokenest's accuracy against reference token counts, computing the same
+// error metrics the fit tool reports (MAE, MAPE, P50/P90 absolute percentage error, and the
+// underestimate rate) so they can run as regression checks in CI.
+package eval
+
+import (
+	"math"
+
+	"github.com/EZ-Api/tokenest"
+)
+
+// Metrics summarizes estimation error over a set of samples. Percentages are in 0-100.
+type Metrics struct {
+	Count     int     `json:"count"`
+	MAE       float64 `json:"mae"`
+	MAPE      float64 `json:"mape"`
+	P50APE    float64 `json:"p50_ape"`
+	P90APE    float64 `json:"p90_ape"`
+	UnderRate float64 `json:"under_rate"`
+}
+
+// Sample is one evaluation input.
+type Sample struct {
+	// Name identifies the sample in reports; it is not used by Evaluate.
+	Name string
+	Text string
+}
+
+// Evaluate estimates every sample's text with opts and compares it with actual, the reference
+// count (typically a real tokenizer).
+func Evaluate(samples []Sample, actual func(string) int, opts tokenest.Options) Metrics {
+	acc := NewAccumulator()
+	for _, sample := range samples {
+		acc.Add(float64(tokenest.EstimateText(sample.Text, opts).Tokens), float64(actual(sample.Text)))
+	}
+	return acc.Metrics()
+}
+
+// Accumulator computes Metrics over a stream of (predicted, actual) pairs in constant memory.
+type Accumulator struct {
+	count  int
+	under  int
+	sumAbs float64
+	sumAPE float64
+	q50    *Quantile
+	q90    *Quantile
+}
+
+// NewAccumulator returns an empty Accumulator.
+func NewAccumulator() *Accumulator {
+	return &Accumulator{
+		q50: NewQuantile(0.50),
+		q90: NewQuantile(0.90),
+	}
+}
+
+// Add records one prediction. Negative predictions count as zero; samples with a non-positive
+// actual count contribute zero percentage error.
+func (a *Accumulator) Add(predicted, actual float64) {
+	if predicted < 0 {
+		predicted = 0
+	}
+
+	absErr := math.Abs(predicted - actual)
+	a.sumAbs += absErr
+
+	ape := 0.0
+	if actual > 0 {
+		ape = absErr / actual * 100
+	}
+	a.sumAPE += ape
+	a.q50.Add(ape)
+	a.q90.Add(ape)
+
+	if predicted < actual {
+		a.under++
+	}
+	a.count++
+}
+
+// Metrics returns the metrics so far; the zero Metrics before any Add.
+func (a *Accumulator) Metrics() Metrics {
+	if a.count == 0 {
+		return Metrics{}
+	}
+
+	m := Metrics{
+		Count:     a.count,
+		MAE:       a.sumAbs / float64(a.count),
+		MAPE:      a.sumAPE / float64(a.count),
+		UnderRate: float64(a.under) / float64(a.count),
+	}
+	if v, ok := a.q50.Value(); ok {
+		m.P50APE = v
+	}
+	if v, ok := a.q90.Value(); ok {
+		m.P90APE = v
+	}
+	return m
+}
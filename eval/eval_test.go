@@ -0,0 +1,59 @@
+package eval
+
+import (
+	"math"
+	"testing"
+
+	"github.com/EZ-Api/tokenest"
+)
+
+func TestQuantileApproximatesExact(t *testing.T) {
+	q50 := NewQuantile(0.50)
+	q90 := NewQuantile(0.90)
+	if _, ok := q50.Value(); ok {
+		t.Fatal("expected no value before the first observation")
+	}
+	// A fixed permutation of 0..999, so the estimator does not see sorted input.
+	for i := 0; i < 1000; i++ {
+		x := float64((i * 617) % 1000)
+		q50.Add(x)
+		q90.Add(x)
+	}
+	if v, _ := q50.Value(); math.Abs(v-500) > 25 {
+		t.Fatalf("expected P50 near 500, got %v", v)
+	}
+	if v, _ := q90.Value(); math.Abs(v-900) > 25 {
+		t.Fatalf("expected P90 near 900, got %v", v)
+	}
+}
+
+func TestAccumulatorMetrics(t *testing.T) {
+	acc := NewAccumulator()
+	acc.Add(90, 100)
+	acc.Add(110, 100)
+	acc.Add(-5, 10)
+	m := acc.Metrics()
+	if m.Count != 3 || m.MAE != 10 || math.Abs(m.MAPE-(10+10+100)/3.0) > 1e-9 {
+		t.Fatalf("unexpected metrics %+v", m)
+	}
+	if math.Abs(m.UnderRate-2.0/3) > 1e-9 || m.P50APE != 10 {
+		t.Fatalf("unexpected under rate or P50 in %+v", m)
+	}
+	if got := NewAccumulator().Metrics(); got != (Metrics{}) {
+		t.Fatalf("expected zero metrics when empty, got %+v", got)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	samples := []Sample{{Name: "a", Text: "hello world"}, {Name: "b", Text: "你好世界"}}
+	opts := tokenest.Options{Strategy: tokenest.StrategyWeighted}
+	exact := func(text string) int { return tokenest.EstimateText(text, opts).Tokens }
+	if m := Evaluate(samples, exact, opts); m.Count != 2 || m.MAE != 0 || m.UnderRate != 0 {
+		t.Fatalf("expected zero error against itself, got %+v", m)
+	}
+
+	double := func(text string) int { return 2 * exact(text) }
+	if m := Evaluate(samples, double, opts); m.UnderRate != 1 || math.Abs(m.MAPE-50) > 1e-9 {
+		t.Fatalf("expected 50%% underestimate, got %+v", m)
+	}
+}
@@ -1,15 +1,15 @@
-package main
+package eval
 
 import (
 	"math"
 	"sort"
 )
 
-// p2Quantile implements the P² streaming quantile estimator.
+// Quantile implements the P² streaming quantile estimator.
 // It estimates the p-quantile without storing all observations.
 //
 // Reference: Jain & Chlamtac (1985), also described on Wikipedia.
-type p2Quantile struct {
+type Quantile struct {
 	p     float64
 	count int
 
@@ -25,14 +25,16 @@ type p2Quantile struct {
 	boot []float64
 }
 
-func newP2Quantile(p float64) *p2Quantile {
-	return &p2Quantile{
+// NewQuantile returns an estimator for the p-quantile, 0 < p < 1 (e.g. 0.9 for P90).
+func NewQuantile(p float64) *Quantile {
+	return &Quantile{
 		p:    p,
 		boot: make([]float64, 0, 5),
 	}
 }
 
-func (e *p2Quantile) Add(x float64) {
+// Add records one observation.
+func (e *Quantile) Add(x float64) {
 	e.count++
 	if len(e.boot) < 5 {
 		e.boot = append(e.boot, x)
@@ -101,7 +103,9 @@ func (e *p2Quantile) Add(x float64) {
 	}
 }
 
-func (e *p2Quantile) Value() (float64, bool) {
+// Value returns the current estimate, or false before the first observation. With fewer than
+// five observations it is the exact nearest-rank quantile.
+func (e *Quantile) Value() (float64, bool) {
 	if len(e.boot) == 0 {
 		return 0, false
 	}
@@ -120,7 +124,7 @@ func (e *p2Quantile) Value() (float64, bool) {
 	return e.q[2], true
 }
 
-func (e *p2Quantile) parabolic(i int, d int) float64 {
+func (e *Quantile) parabolic(i int, d int) float64 {
 	ni := float64(e.n[i])
 	ni1 := float64(e.n[i-1])
 	ni2 := float64(e.n[i+1])
@@ -133,7 +137,7 @@ func (e *p2Quantile) parabolic(i int, d int) float64 {
 	return qi + dn/(ni2-ni1)*((ni-ni1+dn)*(q2-qi)/(ni2-ni)+(ni2-ni-dn)*(qi-q1)/(ni-ni1))
 }
 
-func (e *p2Quantile) linear(i int, d int) float64 {
+func (e *Quantile) linear(i int, d int) float64 {
 	if d > 0 {
 		return e.q[i] + (e.q[i+1]-e.q[i])/float64(e.n[i+1]-e.n[i])
 	}
@@ -0,0 +1,99 @@
+// Package exact provides a tokenizer-backed tokenest.Estimator that returns exact token counts
+// via tiktoken-go. It lives in its own module so the core tokenest package stays dependency-free.
+//
+// The estimator is a drop-in replacement for tokenest.DefaultEstimator and composes with
+// tokenest.WithCache:
+//
+//	est, err := exact.New(tokenest.EncodingO200K)
+//	if err != nil {
+//		return err
+//	}
+//	cached := tokenest.WithCache(est, 1024)
+//
+// Strategy, Profile, and GlobalMultiplier in tokenest.Options are ignored since the count is
+// exact; Overhead still applies to EstimateInput and EstimateOutput. Encodings are loaded by tiktoken-go, which
+// downloads the BPE ranks on first use unless an offline loader is configured.
+package exact
+
+import (
+	"fmt"
+
+	"github.com/EZ-Api/tokenest"
+	"github.com/pkoukk/tiktoken-go"
+)
+
+// Estimator counts tokens exactly with a tiktoken encoding.
+type Estimator struct {
+	enc      *tiktoken.Tiktoken
+	encoding string
+}
+
+var _ tokenest.Estimator = (*Estimator)(nil)
+
+// New returns an Estimator for the named encoding (e.g., "o200k_base", "cl100k_base").
+func New(encoding string) (*Estimator, error) {
+	enc, err := tiktoken.GetEncoding(encoding)
+	if err != nil {
+		return nil, fmt.Errorf("exact: load encoding %q: %w", encoding, err)
+	}
+	return &Estimator{enc: enc, encoding: encoding}, nil
+}
+
+// NewForModel returns an Estimator for the encoding used by an OpenAI model, as reported by
+// tokenest.EncodingForModel. Non-OpenAI or unknown models return an error.
+func NewForModel(model string) (*Estimator, error) {
+	encoding := tokenest.EncodingForModel(model)
+	if encoding == "" {
+		return nil, fmt.Errorf("exact: no known encoding for model %q", model)
+	}
+	return New(encoding)
+}
+
+// Encoding returns the name of the encoding this Estimator uses.
+func (e *Estimator) Encoding() string {
+	return e.encoding
+}
+
+// Count returns the exact token count of text. Special-token text is counted as ordinary text.
+func (e *Estimator) Count(text string) int {
+	if text == "" {
+		return 0
+	}
+	return len(e.enc.EncodeOrdinary(text))
+}
+
+func (e *Estimator) EstimateBytes(data []byte, opts tokenest.Options) tokenest.Result {
+	return exactResult(e.Count(string(data)), opts)
+}
+
+func (e *Estimator) EstimateText(text string, opts tokenest.Options) tokenest.Result {
+	return exactResult(e.Count(text), opts)
+}
+
+// EstimateInput adds image and message overhead (tokenest.InputOverhead) to the exact text count.
+func (e *Estimator) EstimateInput(text string, images tokenest.ImageCounts, messageCount int, opts tokenest.Options) tokenest.Result {
+	overhead := tokenest.InputOverhead(opts)
+
+	tokens := e.Count(text) +
+		images.LowDetail*overhead.ImageLow +
+		images.HighDetail*overhead.ImageHigh +
+		images.Unknown*overhead.ImageDefault +
+		overhead.Base + messageCount*overhead.PerMessage
+	return exactResult(tokens, opts)
+}
+
+// EstimateOutput adds tokenest.OutputOverhead to the exact text count.
+func (e *Estimator) EstimateOutput(text string, opts tokenest.Options) tokenest.Result {
+	return exactResult(e.Count(text)+tokenest.OutputOverhead(opts), opts)
+}
+
+func exactResult(tokens int, opts tokenest.Options) tokenest.Result {
+	return tokenest.Result{
+		Tokens:     tokens,
+		Strategy:   opts.Strategy,
+		Profile:    opts.Profile,
+		Confidence: 1,
+		MinTokens:  tokens,
+		MaxTokens:  tokens,
+	}
+}
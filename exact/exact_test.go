@@ -0,0 +1,9 @@
+package exact
+
+import "testing"
+
+func TestNewForModelUnknown(t *testing.T) {
+	if _, err := NewForModel("claude-3-opus"); err == nil {
+		t.Fatal("expected error for model without an OpenAI encoding")
+	}
+}
@@ -0,0 +1,15 @@
+module github.com/EZ-Api/tokenest/exact
+
+go 1.24.5
+
+require (
+	github.com/EZ-Api/tokenest v0.0.0
+	github.com/pkoukk/tiktoken-go v0.1.8
+)
+
+require (
+	github.com/dlclark/regexp2 v1.10.0 // indirect
+	github.com/google/uuid v1.3.0 // indirect
+)
+
+replace github.com/EZ-Api/tokenest => ../
@@ -0,0 +1,97 @@
+package tokenest
+
+import "unicode/utf8"
+
+// Segment categories reported by ExplainRanges.
+const (
+	SegmentCategoryWhitespace = "whitespace"
+	SegmentCategoryCJK        = "cjk"
+	SegmentCategoryHangul     = "hangul"
+	SegmentCategoryEmoji      = "emoji"
+	SegmentCategoryNumeric    = "numeric"
+	SegmentCategoryShort      = "short"
+	SegmentCategoryRepeated   = "repeated"
+	SegmentCategoryPunct      = "punct"
+	SegmentCategoryBase64     = "base64"
+	SegmentCategoryAlnum      = "alnum"
+	SegmentCategoryOther      = "other"
+	SegmentCategoryInvalid    = "invalid_utf8"
+)
+
+// Segment is one span of the Weighted (tokenx) segmentation.
+type Segment struct {
+	// Start and End are byte offsets into the input (half-open).
+	Start int
+	End   int
+
+	// Category is one of the SegmentCategory* constants.
+	Category string
+
+	// Runes is the segment's rune count.
+	Runes int
+
+	// Tokens is the base token units the segment contributes before profile tuning.
+	Tokens int
+}
+
+// Segments returns the Weighted segmentation of text using the OpenAI profile's segmentation
+// parameters. Segments are contiguous, cover the whole input, and their Tokens sum to the base
+// count that Weighted tunes for that profile.
+func Segments(text string) []Segment {
+	return tokenXSegments(text, weightsForProfile(ProfileOpenAI).segmentation())
+}
+
+// CategoryRange describes one Weighted segment of the input.
+type CategoryRange struct {
+	// Start and End are byte offsets into the input (half-open).
+	Start int
+	End   int
+
+	// Category is one of the SegmentCategory* constants.
+	Category string
+
+	// Tokens is the segment's base token count before profile tuning.
+	Tokens int
+}
+
+// ExplainRanges returns the Weighted segmentation of text as ordered, contiguous byte ranges.
+// The ranges cover the whole input and align exactly with the Weighted segmenter's boundaries.
+// Segment thresholds follow the weights resolved from opts.
+func ExplainRanges(text string, opts Options) []CategoryRange {
+	segments := tokenXSegments(text, segmentationForOptions(opts))
+	if segments == nil {
+		return nil
+	}
+
+	ranges := make([]CategoryRange, len(segments))
+	for i, seg := range segments {
+		ranges[i] = CategoryRange{
+			Start:    seg.Start,
+			End:      seg.End,
+			Category: seg.Category,
+			Tokens:   seg.Tokens,
+		}
+	}
+	return ranges
+}
+
+func tokenXSegments(text string, params tokenXParams) []Segment {
+	if text == "" {
+		return nil
+	}
+
+	segments := make([]Segment, 0)
+	var stats tokenXStats
+	forEachTokenXSegment(text, params.classTable(), func(start, end int) {
+		segment := text[start:end]
+		tokens, category := costTokenXSegment(segment, params, &stats)
+		segments = append(segments, Segment{
+			Start:    start,
+			End:      end,
+			Category: category,
+			Runes:    utf8.RuneCountInString(segment),
+			Tokens:   tokens,
+		})
+	})
+	return segments
+}
@@ -0,0 +1,99 @@
+package tokenest
+
+import (
+	"fmt"
+	"runtime/debug"
+	"slices"
+)
+
+// FallbackConfig configures WithFallbackOptions.
+type FallbackConfig struct {
+	// Fallback returns the Result used in place of a panicked estimate. Nil returns a zero
+	// Result. A panic in Fallback itself is not recovered.
+	Fallback func() Result
+
+	// OnPanic, if set, receives each recovered value with the stack of the panicking goroutine,
+	// e.g. to log it with the caller's logger. It also receives a panic in Options.Observer while
+	// the fallback Result is reported. Nil drops them; the panic is still added to
+	// Result.Warnings.
+	OnPanic func(recovered any, stack []byte)
+}
+
+// WithFallback wraps an estimator so that a panic in any of its methods, such as from a custom
+// Estimator or a malformed Weights table, is recovered instead of crashing the caller. The call
+// returns fallback() with the panic appended to Result.Warnings and reported to
+// Options.Observer. It is WithFallbackOptions with only FallbackConfig.Fallback set.
+func WithFallback(inner Estimator, fallback func() Result) Estimator {
+	return WithFallbackOptions(inner, FallbackConfig{Fallback: fallback})
+}
+
+// WithFallbackOptions is WithFallback with an OnPanic hook for the recovered values. Nothing is
+// written to the standard logger. Wrap the outermost estimator (e.g.,
+// WithFallbackOptions(WithCache(est, n), cfg)) to cover every layer.
+func WithFallbackOptions(inner Estimator, cfg FallbackConfig) Estimator {
+	if inner == nil {
+		inner = DefaultEstimator()
+	}
+	return fallbackEstimator{inner: inner, cfg: cfg}
+}
+
+type fallbackEstimator struct {
+	inner Estimator
+	cfg   FallbackConfig
+}
+
+func (f fallbackEstimator) EstimateBytes(data []byte, opts Options) (res Result) {
+	defer f.recover(opts, &res)
+	return f.inner.EstimateBytes(data, opts)
+}
+
+func (f fallbackEstimator) EstimateText(text string, opts Options) (res Result) {
+	defer f.recover(opts, &res)
+	return f.inner.EstimateText(text, opts)
+}
+
+func (f fallbackEstimator) EstimateInput(text string, images ImageCounts, messageCount int, opts Options) (res Result) {
+	defer f.recover(opts, &res)
+	return f.inner.EstimateInput(text, images, messageCount, opts)
+}
+
+func (f fallbackEstimator) EstimateOutput(text string, opts Options) (res Result) {
+	defer f.recover(opts, &res)
+	return f.inner.EstimateOutput(text, opts)
+}
+
+// recover replaces *res with the fallback Result if the inner estimate panicked. It must be
+// deferred directly so that its recover call stops the panic.
+func (f fallbackEstimator) recover(opts Options, res *Result) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	f.report(r)
+
+	var result Result
+	if f.cfg.Fallback != nil {
+		result = f.cfg.Fallback()
+	}
+	// Clip so the warning never writes into a Warnings slice the fallback shares between calls.
+	result.Warnings = append(slices.Clip(result.Warnings), fmt.Sprintf("fallback: estimator panicked: %v", r))
+	*res = result
+	f.observe(opts.Observer, result)
+}
+
+// observe notifies observer of the fallback Result. The first panic may have come from observer
+// itself, so a second one is recovered here rather than escaping the wrapper.
+func (f fallbackEstimator) observe(observer func(Result), res Result) {
+	defer func() {
+		if r := recover(); r != nil {
+			f.report(r)
+		}
+	}()
+	notify(observer, res)
+}
+
+func (f fallbackEstimator) report(r any) {
+	if f.cfg.OnPanic != nil {
+		f.cfg.OnPanic(r, debug.Stack())
+	}
+}
@@ -1,51 +1,186 @@
 package tokenest
 
-import "math"
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
 
 const (
 	fastSampleTotal = 1000
 	fastHeadSize    = 256
 	fastMidSize     = 256
 	fastTailSize    = 256
+
+	// fastDefaultWindows is the number of windows in the fixed head/mid/tail sample.
+	fastDefaultWindows = 3
+
+	// fastCJKBytesPerRune is the UTF-8 width of runes counted by isCJKFast.
+	fastCJKBytesPerRune = 3
+	// fastCJKTokensPerRune is the cost of each rune counted by isCJKFast, fitted against the
+	// o200k_base counts of analects_zh.txt and the mixed3_*_zh_en_code.txt datasets.
+	fastCJKTokensPerRune = 1.04
+
+	// fastSampleWindow is the window size used when sampling by fraction.
+	fastSampleWindow = 256
+	// fastSampleMaxBytes caps the sample size when sampling by fraction.
+	fastSampleMaxBytes = 64 * 1024
+
+	// fastBase64MinRun is the shortest run of base64-alphabet runes Fast costs at
+	// base64CharsPerToken. It is longer than Weighted's segment minimum because Fast runs
+	// also span '+', '/', and '='.
+	fastBase64MinRun = 32
 )
 
+// estimateUltraFast is EstimateByteLen, except that whitespace-only input costs
+// blankInputTokens. The whitespace check stops at the first other rune.
 func estimateUltraFast(data []byte) int {
-	if len(data) == 0 {
-		return 0
+	if len(data) > 0 && len(bytes.TrimLeftFunc(data, unicode.IsSpace)) == 0 {
+		return blankInputTokens(utf8.RuneCount(data))
 	}
-	return (len(data) + 3) / 4
+	return EstimateByteLen(len(data))
 }
 
-func estimateFast(text string) int {
+// estimateUltraFastText is estimateUltraFast for text, without copying it to a byte slice.
+func estimateUltraFastText(text string) int {
+	if text != "" && strings.TrimLeftFunc(text, unicode.IsSpace) == "" {
+		return blankInputTokens(utf8.RuneCountInString(text))
+	}
+	return EstimateByteLen(len(text))
+}
+
+// Fast explain categories. They report sample ratios rather than token contributions, so
+// their Weight and Tokens are zero.
+const (
+	fastCategoryCJKRatio   = "sampled_cjk_ratio"
+	fastCategoryPunctRatio = "sampled_punct_ratio"
+)
+
+func estimateFast(text string, sampleFraction float64, sampleWindows int, explain bool, breakdown *[]CategoryBreakdown) int {
 	if text == "" {
 		return 0
 	}
 
-	sample := sampleFastText(text)
+	sample := strings.Join(fastSamplePlan(text, sampleFraction, sampleWindows), "")
 	if sample == "" {
 		return 0
 	}
 
-	totalRunes := 0
-	cjkCount := 0
-	punctCount := 0
-	for _, r := range sample {
-		totalRunes++
-		if isCJKFast(r) {
-			cjkCount++
+	var counts fastCounts
+	for i, r := range sample {
+		if isInvalidByteAt(sample, i, r) {
+			counts.addInvalid()
+			continue
 		}
-		if isFastPunct(r) {
-			punctCount++
+		counts.add(r)
+	}
+	if explain && breakdown != nil {
+		*breakdown = counts.appendRatios(*breakdown)
+	}
+	return counts.tokens(len(text), len(sample))
+}
+
+// fastCounts accumulates the rune statistics Fast derives its estimate from.
+type fastCounts struct {
+	totalRunes int
+	cjk        int
+	punct      int
+	space      int
+	// excessSpace counts whitespace bytes after the first in each whitespace run. Like Weighted,
+	// which costs whitespace segments at zero, Fast treats runs of indentation or padding as
+	// compressed into the neighbouring tokens instead of spending bytes/divisor on them.
+	excessSpace int
+	// excessSpaceRunes counts the same whitespace in runes, for the rune-based punctuation ratio.
+	excessSpaceRunes int
+	inSpace          bool
+	// invalid counts bytes that are not valid UTF-8, each costed as one token.
+	invalid int
+	base64  fastBase64Counter
+}
+
+func (c *fastCounts) add(r rune) {
+	c.totalRunes++
+	if isCJKFast(r) {
+		c.cjk++
+	}
+	if isFastPunct(r) {
+		c.punct++
+	}
+	if unicode.IsSpace(r) {
+		c.space++
+		if c.inSpace {
+			c.excessSpace += utf8.RuneLen(r)
+			c.excessSpaceRunes++
 		}
+		c.inSpace = true
+	} else {
+		c.inSpace = false
 	}
-	if totalRunes == 0 {
+	c.base64.add(r)
+}
+
+// appendRatios records the sampled CJK and punctuation shares of all sampled runes as
+// zero-token breakdown entries whose BaseUnits hold the ratio.
+func (c fastCounts) appendRatios(breakdown []CategoryBreakdown) []CategoryBreakdown {
+	if c.totalRunes == 0 {
+		return breakdown
+	}
+	total := float64(c.totalRunes)
+	return append(breakdown,
+		CategoryBreakdown{Category: fastCategoryCJKRatio, BaseUnits: float64(c.cjk) / total},
+		CategoryBreakdown{Category: fastCategoryPunctRatio, BaseUnits: float64(c.punct) / total},
+	)
+}
+
+// blank reports whether every counted rune was whitespace.
+func (c fastCounts) blank() bool {
+	return c.totalRunes > 0 && c.space == c.totalRunes
+}
+
+// addInvalid counts one byte that is not valid UTF-8.
+func (c *fastCounts) addInvalid() {
+	c.totalRunes++
+	c.invalid++
+	c.inSpace = false
+	c.base64.finish()
+}
+
+// tokens converts the counts of a sampleBytes-byte sample into a Fast estimate for bytesLen
+// bytes. CJK runes (3 bytes each in UTF-8) are costed per rune, base64 runs at
+// base64CharsPerToken, invalid bytes at one token each, and excess whitespace not at all; the
+// remaining byte mass is divided by a punctuation-adjusted bytes-per-token divisor. c is not
+// modified, so counting can continue.
+func (c fastCounts) tokens(bytesLen, sampleBytes int) int {
+	if c.totalRunes == 0 || sampleBytes == 0 {
 		return 0
 	}
+	if c.blank() {
+		// Scale the sampled run to the whole input, as the byte mass is scaled below.
+		return blankInputTokens(int(math.Ceil(float64(c.space) * float64(bytesLen) / float64(sampleBytes))))
+	}
+	// Count the open base64 run without closing it on the caller's copy.
+	b64 := c.base64
+	b64.finish()
+
+	cjkBytesFraction := math.Min(float64(c.cjk*fastCJKBytesPerRune)/float64(sampleBytes), 1)
+	// Base64 runes are ASCII, so each one is a single byte.
+	b64BytesFraction := math.Min(float64(b64.runes)/float64(sampleBytes), 1-cjkBytesFraction)
+	invalidBytesFraction := math.Min(float64(c.invalid)/float64(sampleBytes), 1-cjkBytesFraction-b64BytesFraction)
+	spaceBytesFraction := math.Min(float64(c.excessSpace)/float64(sampleBytes), 1-cjkBytesFraction-b64BytesFraction-invalidBytesFraction)
+	cjkBytes := float64(bytesLen) * cjkBytesFraction
+	b64Bytes := float64(bytesLen) * b64BytesFraction
+	invalidBytes := float64(bytesLen) * invalidBytesFraction
+	otherBytes := float64(bytesLen) * (1 - cjkBytesFraction - b64BytesFraction - invalidBytesFraction - spaceBytesFraction)
 
-	cjkRatio := float64(cjkCount) / float64(totalRunes)
-	punctRatio := float64(punctCount) / float64(totalRunes)
+	punctRatio := 0.0
+	if otherRunes := c.totalRunes - c.cjk - b64.runes - c.invalid - c.excessSpaceRunes; otherRunes > 0 {
+		punctRatio = float64(c.punct-b64.symbols) / float64(otherRunes)
+	}
 
-	divisor := 4.0 - (cjkRatio * 1.5) - (punctRatio * 1.0)
+	divisor := 4.0 - (punctRatio * 1.0)
 	if divisor < 2.0 {
 		divisor = 2.0
 	}
@@ -53,8 +188,35 @@ func estimateFast(text string) int {
 		divisor = 4.0
 	}
 
-	bytesLen := float64(len(text))
-	return int(math.Ceil(bytesLen / divisor))
+	cjkTokens := cjkBytes / fastCJKBytesPerRune * fastCJKTokensPerRune
+	b64Tokens := b64Bytes / base64CharsPerToken
+	return int(math.Ceil(cjkTokens + b64Tokens + invalidBytes + otherBytes/divisor))
+}
+
+// fastBase64Counter totals the runes in base64-like runs of a rune stream. Runs are delimited
+// by runes outside the base64 alphabet and only count when they pass base64Run.qualifies.
+type fastBase64Counter struct {
+	run     base64Run
+	runes   int
+	symbols int
+}
+
+func (c *fastBase64Counter) add(r rune) {
+	class := base64Class(r)
+	if class == 0 {
+		c.finish()
+		return
+	}
+	c.run.add(class)
+}
+
+// finish closes the current run, counting it if it qualifies.
+func (c *fastBase64Counter) finish() {
+	if c.run.qualifies(fastBase64MinRun) {
+		c.runes += c.run.length
+		c.symbols += c.run.symbols
+	}
+	c.run = base64Run{}
 }
 
 func sampleFastText(text string) string {
@@ -62,15 +224,128 @@ func sampleFastText(text string) string {
 		return text
 	}
 
-	head := safeSlice(text, 0, fastHeadSize)
+	head, mid, tail := fastSampleWindows(text)
+	return head + mid + tail
+}
+
+func fastSampleWindows(text string) (head, mid, tail string) {
+	head = safeSlice(text, 0, fastHeadSize)
 	midStart := len(text)/2 - fastMidSize/2
 	midEnd := midStart + fastMidSize
-	mid := safeSlice(text, midStart, midEnd)
-	tail := safeSlice(text, len(text)-fastTailSize, len(text))
+	mid = safeSlice(text, midStart, midEnd)
+	tail = safeSlice(text, len(text)-fastTailSize, len(text))
+	return head, mid, tail
+}
 
-	return head + mid + tail
+const (
+	// fastDivergenceCJK and fastDivergencePunct are the max-min spreads of per-window
+	// ratios beyond which the single Fast divisor is considered unreliable.
+	fastDivergenceCJK   = 0.3
+	fastDivergencePunct = 0.2
+)
+
+// fastSampleWarnings reports when the windows Fast sampled, as planned by fastSamplePlan, have
+// diverging CJK or punctuation ratios. A single window covers the whole input and is always
+// representative.
+func fastSampleWarnings(windows []string) []string {
+	if len(windows) < 2 {
+		return nil
+	}
+
+	minCJK, maxCJK := 1.0, 0.0
+	minPunct, maxPunct := 1.0, 0.0
+	for _, window := range windows {
+		total, cjk, punct := 0, 0, 0
+		for _, r := range window {
+			total++
+			if isCJKFast(r) {
+				cjk++
+			}
+			if isFastPunct(r) {
+				punct++
+			}
+		}
+		if total == 0 {
+			continue
+		}
+		cjkRatio := float64(cjk) / float64(total)
+		punctRatio := float64(punct) / float64(total)
+		minCJK, maxCJK = math.Min(minCJK, cjkRatio), math.Max(maxCJK, cjkRatio)
+		minPunct, maxPunct = math.Min(minPunct, punctRatio), math.Max(maxPunct, punctRatio)
+	}
+
+	var warnings []string
+	if maxCJK-minCJK > fastDivergenceCJK {
+		warnings = append(warnings, fmt.Sprintf("fast: sample windows diverge on CJK ratio (%.2f-%.2f); consider StrategyWeighted", minCJK, maxCJK))
+	}
+	if maxPunct-minPunct > fastDivergencePunct {
+		warnings = append(warnings, fmt.Sprintf("fast: sample windows diverge on punctuation ratio (%.2f-%.2f); consider StrategyWeighted", minPunct, maxPunct))
+	}
+	return warnings
+}
+
+// fastSamplePlan returns the windows Fast samples from text: by fraction when sampleFraction is
+// set, otherwise sampleWindows evenly spaced windows, otherwise the fixed head/mid/tail
+// windows. Text that fits in the sample is returned whole as the only window.
+func fastSamplePlan(text string, sampleFraction float64, sampleWindows int) []string {
+	if sampleFraction > 0 {
+		return fastFractionWindows(text, sampleFraction)
+	}
+	if sampleWindows > fastDefaultWindows {
+		return fastEvenWindows(text, sampleWindows)
+	}
+	if len(text) <= fastSampleTotal {
+		return []string{text}
+	}
+	head, mid, tail := fastSampleWindows(text)
+	return []string{head, mid, tail}
+}
+
+// fastFractionWindows samples roughly sampleFraction of text (bounded below by the fixed
+// sample size and above by fastSampleMaxBytes) as evenly spaced windows across the document.
+func fastFractionWindows(text string, sampleFraction float64) []string {
+	size := int(sampleFraction * float64(len(text)))
+	if size < fastSampleTotal {
+		size = fastSampleTotal
+	}
+	if size > fastSampleMaxBytes {
+		size = fastSampleMaxBytes
+	}
+	if len(text) <= size {
+		return []string{text}
+	}
+	return evenWindows(text, size/fastSampleWindow)
+}
+
+// fastEvenWindows takes the given number of evenly spaced windows, capped at
+// fastSampleMaxBytes in total. Text no longer than the sample is returned whole.
+func fastEvenWindows(text string, windows int) []string {
+	if windows > fastSampleMaxBytes/fastSampleWindow {
+		windows = fastSampleMaxBytes / fastSampleWindow
+	}
+	if len(text) <= windows*fastSampleWindow {
+		return []string{text}
+	}
+	return evenWindows(text, windows)
 }
 
+// evenWindows returns windows fastSampleWindow-byte slices spread across text, the first at
+// the start and the last ending at the end. It requires len(text) > windows*fastSampleWindow.
+func evenWindows(text string, windows int) []string {
+	stride := len(text) / windows
+	out := make([]string, windows)
+	for i := range out {
+		start := i * stride
+		if i == windows-1 {
+			start = len(text) - fastSampleWindow
+		}
+		out[i] = safeSlice(text, start, start+fastSampleWindow)
+	}
+	return out
+}
+
+// safeSlice returns text[start:end] widened outward to whole runes, so a window that starts or
+// ends inside a multi-byte rune keeps that rune instead of shrinking (or collapsing to empty).
 func safeSlice(text string, start, end int) string {
 	if start < 0 {
 		start = 0
@@ -85,25 +360,25 @@ func safeSlice(text string, start, end int) string {
 		start = end
 	}
 
-	start = adjustLeftToRuneBoundary(text, start)
-	end = adjustRightToRuneBoundary(text, end)
-	if end < start {
-		end = start
-	}
-
+	start = expandStartToRuneBoundary(text, start)
+	end = expandEndToRuneBoundary(text, end)
 	return text[start:end]
 }
 
-func adjustLeftToRuneBoundary(text string, idx int) int {
-	for idx < len(text) && isContinuationByte(text[idx]) {
-		idx++
+// expandStartToRuneBoundary moves idx back to the first byte of the rune containing it. It
+// steps back at most utf8.UTFMax-1 bytes so invalid runs of continuation bytes stay bounded.
+func expandStartToRuneBoundary(text string, idx int) int {
+	for i := 0; i < utf8.UTFMax-1 && idx > 0 && idx < len(text) && isContinuationByte(text[idx]); i++ {
+		idx--
 	}
 	return idx
 }
 
-func adjustRightToRuneBoundary(text string, idx int) int {
-	for idx > 0 && idx <= len(text) && isContinuationByte(text[idx-1]) {
-		idx--
+// expandEndToRuneBoundary moves idx forward past the rest of the rune it cuts, with the same
+// utf8.UTFMax-1 bound as expandStartToRuneBoundary.
+func expandEndToRuneBoundary(text string, idx int) int {
+	for i := 0; i < utf8.UTFMax-1 && idx < len(text) && isContinuationByte(text[idx]); i++ {
+		idx++
 	}
 	return idx
 }
@@ -112,8 +387,11 @@ func isContinuationByte(b byte) bool {
 	return (b & 0xC0) == 0x80
 }
 
+// isCJKFast reports whether Fast costs r per rune: CJK Unified Ideographs, CJK symbols and
+// punctuation other than the ideographic space, and halfwidth and fullwidth forms. All are 3
+// bytes in UTF-8.
 func isCJKFast(r rune) bool {
-	return r >= 0x4E00 && r <= 0x9FFF
+	return (r >= 0x4E00 && r <= 0x9FFF) || (r >= 0x3001 && r <= 0x303F) || (r >= 0xFF00 && r <= 0xFFEF)
 }
 
 func isFastPunct(r rune) bool {
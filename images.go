@@ -0,0 +1,165 @@
+package tokenest
+
+import "math"
+
+// ImageDetail is the requested image fidelity, mirroring OpenAI's "detail" parameter.
+type ImageDetail string
+
+const (
+	ImageDetailAuto ImageDetail = "auto"
+	ImageDetailLow  ImageDetail = "low"
+	ImageDetailHigh ImageDetail = "high"
+)
+
+// ImageSpec describes one input image for dimension-aware costing.
+// Zero Width or Height means the size is unknown.
+type ImageSpec struct {
+	Width  int
+	Height int
+	Detail ImageDetail
+}
+
+// OpenAI high-detail tiling: the image is fit within 2048x2048, then scaled so its shortest
+// side is at most 768px, and billed 85 tokens plus 170 per 512px tile.
+const (
+	openAIImageMaxSide      = 2048
+	openAIImageShortSide    = 768
+	openAIImageTileSize     = 512
+	openAIImageBaseTokens   = 85
+	openAIImageTokensByTile = 170
+)
+
+// Claude downscales images whose long side exceeds 1568px and bills about one token per 750
+// pixels, up to roughly 1600 tokens.
+const (
+	claudeImageMaxSide       = 1568
+	claudeImagePixelsByToken = 750
+)
+
+// Gemini bills images within 384x384 as one 258-token unit and larger images per 768x768 tile.
+const (
+	geminiImageSmallSide     = 384
+	geminiImageTileSize      = 768
+	geminiImageTokensPerTile = 258
+)
+
+// Qwen-VL (Qwen2-VL and later) resizes each side to a multiple of 28px, its 14px patches
+// merged 2x2, keeping between 4 and 1280 merged patches (DashScope's default max_pixels of
+// 1280*28*28), and bills one token per merged patch plus 2 for the vision start and end
+// markers. The rounding follows smart_resize in the Qwen2-VL image processor.
+const (
+	qwenImagePatchSize    = 28
+	qwenImageMinPatches   = 4
+	qwenImageMaxPatches   = 1280
+	qwenImageMarkerTokens = 2
+)
+
+// ImageTokens returns the token cost of one image for profile. With known dimensions, OpenAI
+// uses 512px tiling for high and auto detail (auto is costed as high, the upper bound) and
+// ImageTokensLow for low detail; Claude bills by pixel area, Gemini by 768px tile, and Qwen by
+// 28px patch, all ignoring Detail, which is an OpenAI parameter. Images of unknown size cost
+// the profile's low, high, or default image overhead (see InputOverhead).
+func ImageTokens(spec ImageSpec, profile Profile) int {
+	return imageSpecTokens(spec, profile, profileOverhead(profile))
+}
+
+func imageSpecTokens(spec ImageSpec, profile Profile, overhead OverheadConfig) int {
+	known := spec.Width > 0 && spec.Height > 0
+	switch {
+	case known && profile == ProfileClaude:
+		return claudeImageTokens(spec.Width, spec.Height)
+	case known && profile == ProfileGemini:
+		return geminiImageTokens(spec.Width, spec.Height)
+	case known && profile == ProfileQwen:
+		return qwenImageTokens(spec.Width, spec.Height)
+	case spec.Detail == ImageDetailLow:
+		return overhead.ImageLow
+	case known:
+		return openAITiledImageTokens(spec.Width, spec.Height)
+	case spec.Detail == ImageDetailHigh:
+		return overhead.ImageHigh
+	default:
+		return overhead.ImageDefault
+	}
+}
+
+func claudeImageTokens(width, height int) int {
+	w, h := float64(width), float64(height)
+	if longest := math.Max(w, h); longest > claudeImageMaxSide {
+		scale := claudeImageMaxSide / longest
+		w, h = w*scale, h*scale
+	}
+	tokens := int(math.Ceil(w * h / claudeImagePixelsByToken))
+	return min(tokens, claudeImageTokensHigh)
+}
+
+func geminiImageTokens(width, height int) int {
+	if width <= geminiImageSmallSide && height <= geminiImageSmallSide {
+		return geminiImageTokensPerTile
+	}
+	tiles := ((width + geminiImageTileSize - 1) / geminiImageTileSize) * ((height + geminiImageTileSize - 1) / geminiImageTileSize)
+	return geminiImageTokensPerTile * tiles
+}
+
+func qwenImageTokens(width, height int) int {
+	const patch = qwenImagePatchSize
+	w, h := float64(width), float64(height)
+	// Python's round, used by smart_resize, rounds halves to even.
+	wBar := math.Max(patch, math.RoundToEven(w/patch)*patch)
+	hBar := math.Max(patch, math.RoundToEven(h/patch)*patch)
+	minPixels := float64(qwenImageMinPatches * patch * patch)
+	maxPixels := float64(qwenImageMaxPatches * patch * patch)
+	if wBar*hBar > maxPixels {
+		beta := math.Sqrt(w * h / maxPixels)
+		wBar = math.Max(patch, math.Floor(w/beta/patch)*patch)
+		hBar = math.Max(patch, math.Floor(h/beta/patch)*patch)
+	} else if wBar*hBar < minPixels {
+		beta := math.Sqrt(minPixels / (w * h))
+		wBar = math.Ceil(w*beta/patch) * patch
+		hBar = math.Ceil(h*beta/patch) * patch
+	}
+	return int(wBar/patch)*int(hBar/patch) + qwenImageMarkerTokens
+}
+
+func openAITiledImageTokens(width, height int) int {
+	w, h := float64(width), float64(height)
+	if longest := math.Max(w, h); longest > openAIImageMaxSide {
+		scale := openAIImageMaxSide / longest
+		w, h = w*scale, h*scale
+	}
+	if shortest := math.Min(w, h); shortest > openAIImageShortSide {
+		scale := openAIImageShortSide / shortest
+		w, h = w*scale, h*scale
+	}
+
+	tiles := int(math.Ceil(w/openAIImageTileSize)) * int(math.Ceil(h/openAIImageTileSize))
+	return openAIImageBaseTokens + openAIImageTokensByTile*tiles
+}
+
+// EstimateInputWithImages is EstimateInput with per-image specs instead of ImageCounts, so each
+// image is costed from its dimensions and the resolved profile (see ImageTokens).
+// Options.Overhead image costs apply to images ImageTokens would cost from the overhead.
+func EstimateInputWithImages(text string, images []ImageSpec, messageCount int, opts Options) Result {
+	multiplier := opts.GlobalMultiplier
+	opts.GlobalMultiplier = 1.0
+	observer := opts.Observer
+	opts.Observer = nil
+	result := EstimateText(text, opts)
+
+	overhead := InputOverhead(opts)
+	profile := resolveProfile(opts)
+	imgTokens := 0
+	for _, spec := range images {
+		imgTokens += imageSpecTokens(spec, profile, overhead)
+	}
+
+	textTokens := result.Tokens
+	overheadTokens := messageOverhead(messageCount, overhead)
+	result.Tokens = applyMultiplier(textTokens+imgTokens+overheadTokens, multiplier)
+	if opts.Explain {
+		result.Breakdown = appendInputBreakdown(result.Breakdown, textTokens, len(images), imgTokens, overheadTokens, result.Tokens)
+	}
+	result.setRange()
+
+	return notify(observer, result)
+}
@@ -0,0 +1,50 @@
+// Package cjk holds the CJK rune table shared by the root estimators, the ZR strategy, and the
+// fit tool, so their notion of "CJK" cannot drift.
+package cjk
+
+// Range is an inclusive range of runes.
+type Range struct {
+	Lo rune
+	Hi rune
+}
+
+// table lists the blocks treated as CJK, most frequent first.
+var table = [...]Range{
+	{0x4E00, 0x9FFF}, // CJK Unified Ideographs
+	{0x3400, 0x4DBF}, // CJK Unified Ideographs Extension A
+	{0x3000, 0x303F}, // CJK Symbols and Punctuation
+	{0xFF00, 0xFFEF}, // Halfwidth and Fullwidth Forms
+	{0x30A0, 0x30FF}, // Katakana
+	{0x2E80, 0x2EFF}, // CJK Radicals Supplement
+	{0x31C0, 0x31EF}, // CJK Strokes
+	{0x3200, 0x32FF}, // Enclosed CJK Letters and Months
+	{0x3300, 0x33FF}, // CJK Compatibility
+	{0xAC00, 0xD7AF}, // Hangul Syllables
+	{0x1100, 0x11FF}, // Hangul Jamo
+	{0x3130, 0x318F}, // Hangul Compatibility Jamo
+	{0xA960, 0xA97F}, // Hangul Jamo Extended-A
+	{0xD7B0, 0xD7FF}, // Hangul Jamo Extended-B
+}
+
+// minRune is the lowest rune in table; anything below it is never CJK.
+const minRune = 0x1100
+
+// IsRune reports whether r falls in one of the CJK blocks.
+func IsRune(r rune) bool {
+	if r < minRune {
+		return false
+	}
+	for _, rr := range table {
+		if r >= rr.Lo && r <= rr.Hi {
+			return true
+		}
+	}
+	return false
+}
+
+// Ranges returns a copy of the CJK table.
+func Ranges() []Range {
+	ranges := make([]Range, len(table))
+	copy(ranges, table[:])
+	return ranges
+}
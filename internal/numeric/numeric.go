@@ -0,0 +1,36 @@
+// Package numeric holds the numeric-segment costing shared by the root Weighted estimator and
+// the ZR strategy, so the two cannot drift.
+package numeric
+
+// Tokens costs a numeric segment (digits with '.' or ',' separators, e.g. "3.14" or
+// "1,234,567"). Tokenizers split each digit run into chunks of up to three digits and keep
+// separators as tokens of their own (o200k_base encodes "1,234,567" as 1 , 234 , 567), so
+// each digit run costs one token per digitsPerToken digits, rounding up, and each run of
+// separators costs one token. A digitsPerToken of zero or less collapses the segment to one
+// token.
+func Tokens(segment string, digitsPerToken int) int {
+	if digitsPerToken <= 0 {
+		return 1
+	}
+	tokens := 0
+	digits := 0
+	inSeparator := false
+	for i := 0; i < len(segment); i++ {
+		if segment[i] >= '0' && segment[i] <= '9' {
+			digits++
+			inSeparator = false
+			continue
+		}
+		tokens += (digits + digitsPerToken - 1) / digitsPerToken
+		digits = 0
+		if !inSeparator {
+			tokens++
+			inSeparator = true
+		}
+	}
+	tokens += (digits + digitsPerToken - 1) / digitsPerToken
+	if tokens == 0 {
+		return 1
+	}
+	return tokens
+}
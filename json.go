@@ -0,0 +1,79 @@
+package tokenest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// EstimateJSON estimates tokens for the content of a JSON document, ignoring its envelope.
+// String values are extracted in document order (object keys too when
+// Options.IncludeJSONKeys is set), joined by newlines, and estimated with EstimateText.
+// Numbers, booleans, nulls, and structural punctuation are not counted. Invalid JSON returns
+// an error wrapping ErrInvalidJSON, and with Options.Strict, options that cannot be honored
+// exactly return an error wrapping ErrStrict.
+func EstimateJSON(data []byte, opts Options) (Result, error) {
+	text, err := extractJSONStrings(data, opts.IncludeJSONKeys)
+	if err != nil {
+		return Result{}, err
+	}
+	return EstimateTextCtx(context.Background(), text, opts)
+}
+
+// extractJSONStrings walks data token by token and joins its string values (and keys when
+// includeKeys is set) with newlines.
+func extractJSONStrings(data []byte, includeKeys bool) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	// inObject tracks, per open container, whether it is an object; expectKey tracks whether
+	// the next token in that object is a key.
+	var inObject, expectKey []bool
+	var b strings.Builder
+	started := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			if !started || len(inObject) > 0 {
+				return "", fmt.Errorf("%w: %v", ErrInvalidJSON, io.ErrUnexpectedEOF)
+			}
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrInvalidJSON, err)
+		}
+		if started && len(inObject) == 0 {
+			return "", fmt.Errorf("%w: trailing data after top-level value", ErrInvalidJSON)
+		}
+		started = true
+
+		isKey := len(inObject) > 0 && inObject[len(inObject)-1] && expectKey[len(expectKey)-1]
+		if len(inObject) > 0 && inObject[len(inObject)-1] {
+			expectKey[len(expectKey)-1] = !isKey
+		}
+
+		switch v := tok.(type) {
+		case json.Delim:
+			switch v {
+			case '{', '[':
+				inObject = append(inObject, v == '{')
+				expectKey = append(expectKey, v == '{')
+			case '}', ']':
+				inObject = inObject[:len(inObject)-1]
+				expectKey = expectKey[:len(expectKey)-1]
+			}
+		case string:
+			if isKey && !includeKeys {
+				continue
+			}
+			if b.Len() > 0 {
+				b.WriteByte('\n')
+			}
+			b.WriteString(v)
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,84 @@
+package tokenest
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// MarshalJSON encodes the strategy as its String form (e.g. "weighted").
+func (s Strategy) MarshalJSON() ([]byte, error) {
+	if _, ok := parseStrategy(s.String()); !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownStrategy, int(s))
+	}
+	return json.Marshal(s.String())
+}
+
+// UnmarshalJSON decodes a strategy name as produced by MarshalJSON (case-insensitive). The
+// numeric form written by earlier versions is also accepted.
+func (s *Strategy) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		var n int
+		if json.Unmarshal(data, &n) != nil {
+			return fmt.Errorf("%w: %s", ErrUnknownStrategy, data)
+		}
+		name = Strategy(n).String()
+	}
+	strategy, ok := parseStrategy(name)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownStrategy, name)
+	}
+	*s = strategy
+	return nil
+}
+
+// MarshalJSON encodes the profile as its String form (e.g. "claude").
+func (p Profile) MarshalJSON() ([]byte, error) {
+	if p != ProfileAuto {
+		if _, ok := parseProfile(p.String()); !ok {
+			return nil, fmt.Errorf("%w: %d", ErrUnknownProfile, int(p))
+		}
+	}
+	return json.Marshal(p.String())
+}
+
+// UnmarshalJSON decodes a profile name as produced by MarshalJSON (case-insensitive). The
+// numeric form written by earlier versions is also accepted.
+func (p *Profile) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		var n int
+		if json.Unmarshal(data, &n) != nil {
+			return fmt.Errorf("%w: %s", ErrUnknownProfile, data)
+		}
+		name = Profile(n).String()
+	}
+	if strings.EqualFold(strings.TrimSpace(name), ProfileAuto.String()) {
+		*p = ProfileAuto
+		return nil
+	}
+	profile, ok := parseProfile(name)
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownProfile, name)
+	}
+	*p = profile
+	return nil
+}
+
+func parseStrategy(name string) (Strategy, bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "auto":
+		return StrategyAuto, true
+	case "ultrafast":
+		return StrategyUltraFast, true
+	case "fast":
+		return StrategyFast, true
+	case "weighted":
+		return StrategyWeighted, true
+	case "zr":
+		return StrategyZR, true
+	default:
+		return StrategyAuto, false
+	}
+}
@@ -0,0 +1,95 @@
+package nfc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/EZ-Api/tokenest"
+	"github.com/EZ-Api/tokenest/eval"
+	"golang.org/x/text/unicode/norm"
+)
+
+// datasetTokens holds the o200k_base counts of precomposed (NFC) datasets with accented text,
+// from report/testAccuracy-20260107-113910Z.md. Normalize is meant to cost decomposed input
+// like its precomposed form, so these are the reference for the NFD copies below.
+var datasetTokens = map[string]int{
+	"candide_fr.txt":                 14186,
+	"faust_de.txt":                   13804,
+	"mixed5_01_zh_en_de_fr_code.txt": 20867,
+	"mixed5_02_zh_en_de_fr_code.txt": 20994,
+	"mixed5_03_zh_en_de_fr_code.txt": 21062,
+	"mixed5_04_zh_en_de_fr_code.txt": 20594,
+	"mixed5_05_zh_en_de_fr_code.txt": 20695,
+}
+
+// nfdDatasets returns each dataset in datasetTokens and its NFD form, keyed by name.
+func nfdDatasets(t *testing.T) (composed, decomposed map[string]string) {
+	t.Helper()
+	composed = make(map[string]string, len(datasetTokens))
+	decomposed = make(map[string]string, len(datasetTokens))
+	for name := range datasetTokens {
+		data, err := os.ReadFile(filepath.Join("..", "datasets", "test", name))
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		text := string(data)
+		if !norm.NFC.IsNormalString(text) {
+			t.Fatalf("%s: expected a precomposed fixture", name)
+		}
+		composed[name], decomposed[name] = text, norm.NFD.String(text)
+		if decomposed[name] == text {
+			t.Fatalf("%s: expected accented text to decompose", name)
+		}
+	}
+	return composed, decomposed
+}
+
+func TestNormalizeAccuracy(t *testing.T) {
+	composed, decomposed := nfdDatasets(t)
+	for _, strategy := range []tokenest.Strategy{tokenest.StrategyFast, tokenest.StrategyWeighted, tokenest.StrategyZR} {
+		opts := tokenest.Options{Strategy: strategy, Profile: tokenest.ProfileOpenAI}
+		off, on := eval.NewAccumulator(), eval.NewAccumulator()
+		for name, text := range decomposed {
+			actual := float64(datasetTokens[name])
+			opts.Normalize = false
+			unnormalized := tokenest.EstimateText(text, opts).Tokens
+			off.Add(float64(unnormalized), actual)
+			opts.Normalize = true
+			normalized := tokenest.EstimateText(text, opts).Tokens
+			on.Add(float64(normalized), actual)
+
+			if want := tokenest.EstimateText(composed[name], opts).Tokens; normalized != want {
+				t.Fatalf("%v %s: expected the precomposed estimate %d, got %d", strategy, name, want, normalized)
+			}
+			if strategy != tokenest.StrategyWeighted && unnormalized == normalized {
+				t.Fatalf("%v %s: expected decomposed marks to change the estimate without Normalize", strategy, name)
+			}
+		}
+		offMetrics, onMetrics := off.Metrics(), on.Metrics()
+		t.Logf("%v: MAPE %.2f%% -> %.2f%% over %d NFD datasets", strategy, offMetrics.MAPE, onMetrics.MAPE, onMetrics.Count)
+		// Fast undercounts these datasets, and the marks it charges without Normalize happen to
+		// offset that, so only the precomposed match above is asserted for it.
+		if strategy != tokenest.StrategyFast && onMetrics.MAPE >= offMetrics.MAPE {
+			t.Fatalf("%v: expected Normalize to lower MAPE, got %.2f%% -> %.2f%%", strategy, offMetrics.MAPE, onMetrics.MAPE)
+		}
+	}
+}
+
+func TestNormalizeIsNoOpOnASCII(t *testing.T) {
+	texts := []string{
+		"The quick brown fox jumps over the lazy dog.",
+		`{"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}]}`,
+		"func main() {\n\tfmt.Println(\"hello, world\")\n}\n",
+	}
+	for _, text := range texts {
+		for _, strategy := range []tokenest.Strategy{tokenest.StrategyUltraFast, tokenest.StrategyFast, tokenest.StrategyWeighted, tokenest.StrategyZR} {
+			opts := tokenest.Options{Strategy: strategy, Profile: tokenest.ProfileOpenAI}
+			want := tokenest.EstimateText(text, opts).Tokens
+			opts.Normalize = true
+			if got := tokenest.EstimateText(text, opts).Tokens; got != want {
+				t.Fatalf("%v on %.20q: expected %d tokens, got %d", strategy, text, want, got)
+			}
+		}
+	}
+}
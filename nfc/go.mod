@@ -0,0 +1,10 @@
+module github.com/EZ-Api/tokenest/nfc
+
+go 1.25.0
+
+require (
+	github.com/EZ-Api/tokenest v0.0.0
+	golang.org/x/text v0.37.0
+)
+
+replace github.com/EZ-Api/tokenest => ../
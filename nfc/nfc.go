@@ -0,0 +1,18 @@
+// Package nfc installs golang.org/x/text/unicode/norm NFC as the tokenest normalizer, so
+// Options.Normalize recomposes decomposed input (e.g. "e" + U+0301 -> "é") before estimation.
+// It lives in its own module so the core tokenest package stays dependency-free. Import it for
+// its side effect:
+//
+//	import _ "github.com/EZ-Api/tokenest/nfc"
+//
+//	res := tokenest.EstimateText(text, tokenest.Options{Normalize: true})
+package nfc
+
+import (
+	"github.com/EZ-Api/tokenest"
+	"golang.org/x/text/unicode/norm"
+)
+
+func init() {
+	tokenest.RegisterNormalizer(norm.NFC.String)
+}
@@ -0,0 +1,26 @@
+package nfc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/EZ-Api/tokenest"
+	"golang.org/x/text/unicode/norm"
+)
+
+func TestNormalizeComposes(t *testing.T) {
+	composed := strings.Repeat("Ti\u1ebfng Vi\u1ec7t, caf\u00e9, \u03ac, \uac00 ", 20)
+	decomposed := norm.NFD.String(composed)
+	if decomposed == composed {
+		t.Fatal("expected NFD to decompose the sample")
+	}
+	for _, strategy := range []tokenest.Strategy{tokenest.StrategyFast, tokenest.StrategyWeighted, tokenest.StrategyZR} {
+		opts := tokenest.Options{Strategy: strategy, Profile: tokenest.ProfileOpenAI, Normalize: true}
+		if got, want := tokenest.EstimateText(decomposed, opts).Tokens, tokenest.EstimateText(composed, opts).Tokens; got != want {
+			t.Fatalf("%v: expected NFC input to match the precomposed estimate %d, got %d", strategy, want, got)
+		}
+		if err := opts.Validate(); err != nil {
+			t.Fatalf("%v: expected Normalize to be valid once nfc is imported, got %v", strategy, err)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package tokenest
+
+import (
+	"sync"
+	"unicode/utf8"
+)
+
+var normalizerRegistry struct {
+	mu sync.RWMutex
+	fn func(string) string
+	// generation counts RegisterNormalizer calls, so cache keys change with the normalizer.
+	generation uint64
+}
+
+// RegisterNormalizer installs the Unicode normalization applied when Options.Normalize is set.
+// The core package ships none, to stay dependency-free; importing
+// github.com/EZ-Api/tokenest/nfc installs golang.org/x/text/unicode/norm NFC. fn must return
+// its input unchanged when there is nothing to normalize. A nil fn removes the normalizer.
+func RegisterNormalizer(fn func(string) string) {
+	normalizerRegistry.mu.Lock()
+	defer normalizerRegistry.mu.Unlock()
+	normalizerRegistry.fn = fn
+	normalizerRegistry.generation++
+}
+
+// currentNormalizer returns the registered normalizer (nil if none) and its generation.
+func currentNormalizer() (func(string) string, uint64) {
+	normalizerRegistry.mu.RLock()
+	defer normalizerRegistry.mu.RUnlock()
+	return normalizerRegistry.fn, normalizerRegistry.generation
+}
+
+// normalizeText applies the registered normalizer, or returns text unchanged when none is
+// registered.
+func normalizeText(text string) string {
+	if fn, _ := currentNormalizer(); fn != nil {
+		return fn(text)
+	}
+	return text
+}
+
+// hasCombiningMark reports whether text contains a rune in U+0300-U+036F, whose UTF-8 lead
+// bytes are 0xCC and 0xCD.
+func hasCombiningMark(text string) bool {
+	for i := 0; i < len(text); i++ {
+		if c := text[i]; c == 0xCC || c == 0xCD {
+			if r, _ := utf8.DecodeRuneInString(text[i:]); isCombiningMark(r) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isCombiningMark(r rune) bool {
+	return r >= 0x0300 && r <= 0x036F
+}
@@ -0,0 +1,152 @@
+package tokenest
+
+import (
+	"context"
+	"time"
+)
+
+// ProductionConfig bundles the recommended production setup for NewProductionEstimator.
+type ProductionConfig struct {
+	// Profile is applied when a call's Options.Profile is ProfileAuto and Model/ProviderType
+	// are empty. Default: ProfileAuto (resolve per call).
+	Profile Profile
+
+	// CacheSize is the LRU cache capacity. Zero disables caching.
+	CacheSize int
+
+	// MinCacheTextBytes is the smallest text cached. Zero uses the WithCache default (512).
+	MinCacheTextBytes int
+
+	// MaxExactBytes routes StrategyAuto text larger than this to Fast instead of Weighted.
+	// Zero means no limit.
+	MaxExactBytes int
+
+	// Timeout bounds Weighted/ZR estimation; on expiry the estimation is canceled (within about
+	// 64KB of further segmentation, see EstimateTextCtx) and the Fast estimate is returned
+	// instead. A panic in the estimation is re-raised in the caller, so WithFallback still
+	// recovers it. Zero disables the timeout.
+	Timeout time.Duration
+}
+
+// NewProductionEstimator returns an Estimator wired with profile defaults, a size-based Weighted
+// to Fast fallback, an optional timeout, and an optional cache. StrategyAuto text uses Weighted;
+// raw bytes keep the UltraFast default. A SetDefaults strategy replaces both choices, and
+// explicit strategies are honored as-is.
+func NewProductionEstimator(cfg ProductionConfig) Estimator {
+	var est Estimator = productionEstimator{cfg: cfg}
+	if cfg.CacheSize <= 0 {
+		return est
+	}
+
+	return WithCacheOptions(est, CacheConfig{
+		MaxEntries:   cfg.CacheSize,
+		MinTextBytes: cfg.MinCacheTextBytes,
+	})
+}
+
+type productionEstimator struct {
+	cfg ProductionConfig
+}
+
+func (p productionEstimator) EstimateBytes(data []byte, opts Options) Result {
+	opts = p.applyProfile(opts)
+	// Resolve a SetDefaults strategy here so withTimeout sees it.
+	opts.Strategy = defaultStrategy(opts.Strategy)
+	return p.withTimeout(opts, func(ctx context.Context, opts Options) (Result, error) {
+		return estimateBytesCtx(ctx, data, opts)
+	})
+}
+
+func (p productionEstimator) EstimateText(text string, opts Options) Result {
+	opts = p.applyProfile(opts)
+	opts.Strategy = p.textStrategy(opts.Strategy, len(text))
+	return p.withTimeout(opts, func(ctx context.Context, opts Options) (Result, error) {
+		return estimateTextCtx(ctx, text, opts, false)
+	})
+}
+
+func (p productionEstimator) EstimateInput(text string, images ImageCounts, messageCount int, opts Options) Result {
+	opts = p.applyProfile(opts)
+	opts.Strategy = p.textStrategy(opts.Strategy, len(text))
+	return p.withTimeout(opts, func(ctx context.Context, opts Options) (Result, error) {
+		return estimateInputCtx(ctx, text, images, messageCount, opts)
+	})
+}
+
+func (p productionEstimator) EstimateOutput(text string, opts Options) Result {
+	opts = p.applyProfile(opts)
+	opts.Strategy = p.textStrategy(opts.Strategy, len(text))
+	return p.withTimeout(opts, func(ctx context.Context, opts Options) (Result, error) {
+		return estimateOutputCtx(ctx, text, opts)
+	})
+}
+
+func (p productionEstimator) applyProfile(opts Options) Options {
+	if opts.Profile == ProfileAuto && opts.Model == "" && opts.ProviderType == "" {
+		opts.Profile = p.cfg.Profile
+	}
+	return opts
+}
+
+// textStrategy resolves StrategyAuto for size bytes of text: to the SetDefaults strategy if
+// one is set, otherwise to Weighted, or Fast above MaxExactBytes.
+func (p productionEstimator) textStrategy(strategy Strategy, size int) Strategy {
+	strategy = defaultStrategy(strategy)
+	if strategy != StrategyAuto {
+		return strategy
+	}
+	if p.cfg.MaxExactBytes > 0 && size > p.cfg.MaxExactBytes {
+		return StrategyFast
+	}
+	return StrategyWeighted
+}
+
+// withTimeout runs estimate, re-running it with StrategyFast if it exceeds the timeout.
+// Only O(n) strategies are bounded; the cheap strategies run inline.
+func (p productionEstimator) withTimeout(opts Options, estimate func(context.Context, Options) (Result, error)) Result {
+	if p.cfg.Timeout <= 0 || (opts.Strategy != StrategyWeighted && opts.Strategy != StrategyZR) {
+		res, _ := estimate(context.Background(), opts)
+		return res
+	}
+	// Report only the Result returned, not a canceled estimate.
+	observer := opts.Observer
+	opts.Observer = nil
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.Timeout)
+	defer cancel()
+	done := make(chan timedEstimate, 1)
+	go func() {
+		var out timedEstimate
+		defer func() {
+			if r := recover(); r != nil {
+				out = timedEstimate{panicked: true, recovered: r}
+			}
+			done <- out
+		}()
+		out.res, out.err = estimate(ctx, opts)
+	}()
+
+	select {
+	case out := <-done:
+		if out.panicked {
+			panic(out.recovered)
+		}
+		if out.err == nil {
+			return notify(observer, out.res)
+		}
+	case <-ctx.Done():
+	}
+	// Copy opts; the canceled goroutine may still be reading it.
+	fast := opts
+	fast.Strategy = StrategyFast
+	res, _ := estimate(context.Background(), fast)
+	return notify(observer, res)
+}
+
+// timedEstimate is the outcome of an estimate run by withTimeout, including a recovered panic.
+type timedEstimate struct {
+	res       Result
+	err       error
+	panicked  bool
+	recovered any
+}
@@ -0,0 +1,126 @@
+package tokenest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProductionEstimatorStrategySelection(t *testing.T) {
+	est := NewProductionEstimator(ProductionConfig{Profile: ProfileClaude, MaxExactBytes: 64})
+
+	small := est.EstimateText("hello world", Options{})
+	if small.Strategy != StrategyWeighted || small.Profile != ProfileClaude {
+		t.Fatalf("expected Weighted/Claude for small text, got %v/%v", small.Strategy, small.Profile)
+	}
+
+	large := est.EstimateText(strings.Repeat("a", 65), Options{})
+	if large.Strategy != StrategyFast {
+		t.Fatalf("expected Fast above MaxExactBytes, got %v", large.Strategy)
+	}
+
+	explicit := est.EstimateText("hello", Options{Strategy: StrategyZR, Model: "gemini-pro"})
+	if explicit.Strategy != StrategyZR || explicit.Profile != ProfileGemini {
+		t.Fatalf("expected explicit strategy and model resolution to win, got %v/%v", explicit.Strategy, explicit.Profile)
+	}
+
+	raw := est.EstimateBytes([]byte("{}"), Options{})
+	if raw.Strategy != StrategyUltraFast {
+		t.Fatalf("expected UltraFast for raw bytes, got %v", raw.Strategy)
+	}
+}
+
+func TestProductionEstimatorUsesSetDefaults(t *testing.T) {
+	t.Cleanup(func() { SetDefaults(Options{}) })
+	SetDefaults(Options{Strategy: StrategyZR})
+	est := NewProductionEstimator(ProductionConfig{MaxExactBytes: 64})
+
+	for _, text := range []string{"hello world", strings.Repeat("a", 65)} {
+		if got := est.EstimateText(text, Options{}).Strategy; got != StrategyZR {
+			t.Fatalf("expected the SetDefaults strategy for %d bytes of text, got %v", len(text), got)
+		}
+	}
+	if got := est.EstimateBytes([]byte("{}"), Options{}).Strategy; got != StrategyZR {
+		t.Fatalf("expected the SetDefaults strategy for raw bytes, got %v", got)
+	}
+	if got := est.EstimateText("hello", Options{Strategy: StrategyFast}).Strategy; got != StrategyFast {
+		t.Fatalf("expected an explicit strategy to win, got %v", got)
+	}
+}
+
+func TestProductionEstimatorCacheMinTextBytes(t *testing.T) {
+	est := NewProductionEstimator(ProductionConfig{CacheSize: 4, MinCacheTextBytes: 8})
+	c, ok := est.(*cachedEstimator)
+	if !ok {
+		t.Fatalf("expected cached estimator, got %T", est)
+	}
+	if c.minTextSize != 8 {
+		t.Fatalf("expected min text size 8, got %d", c.minTextSize)
+	}
+}
+
+func TestProductionEstimatorTimeoutCancelsEstimate(t *testing.T) {
+	p := productionEstimator{cfg: ProductionConfig{Timeout: time.Millisecond}}
+	canceled := make(chan struct{})
+	res := p.withTimeout(Options{Strategy: StrategyWeighted}, func(ctx context.Context, opts Options) (Result, error) {
+		if opts.Strategy == StrategyFast {
+			return Result{Tokens: 1, Strategy: StrategyFast}, nil
+		}
+		<-ctx.Done()
+		close(canceled)
+		return Result{}, ctx.Err()
+	})
+	if res.Strategy != StrategyFast {
+		t.Fatalf("expected the Fast fallback on timeout, got %+v", res)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("expected the timed-out estimate to be canceled")
+	}
+
+	est := NewProductionEstimator(ProductionConfig{Timeout: time.Nanosecond})
+	text := strings.Repeat("hello, world 你好 ", 40000)
+	if got := est.EstimateText(text, Options{Strategy: StrategyWeighted}); got.Strategy != StrategyFast {
+		t.Fatalf("expected Fast after the timeout, got %v", got.Strategy)
+	}
+}
+
+func TestProductionEstimatorTimeoutPropagatesPanics(t *testing.T) {
+	p := productionEstimator{cfg: ProductionConfig{Timeout: time.Minute}}
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Fatalf("expected the estimate's panic in the caller, got %v", r)
+			}
+		}()
+		p.withTimeout(Options{Strategy: StrategyZR}, func(context.Context, Options) (Result, error) {
+			panic("boom")
+		})
+	}()
+
+	est := WithFallback(panicTimeoutEstimator{p}, func() Result { return Result{Tokens: 5} })
+	if res := est.EstimateText("x", Options{Strategy: StrategyWeighted}); res.Tokens != 5 || len(res.Warnings) != 1 {
+		t.Fatalf("expected WithFallback to recover a panic under a timeout, got %+v", res)
+	}
+}
+
+// panicTimeoutEstimator panics inside productionEstimator.withTimeout's goroutine.
+type panicTimeoutEstimator struct{ p productionEstimator }
+
+func (e panicTimeoutEstimator) EstimateBytes(data []byte, opts Options) Result {
+	return e.EstimateText(string(data), opts)
+}
+
+func (e panicTimeoutEstimator) EstimateText(_ string, opts Options) Result {
+	return e.p.withTimeout(opts, func(context.Context, Options) (Result, error) { panic("boom") })
+}
+
+func (e panicTimeoutEstimator) EstimateInput(text string, _ ImageCounts, _ int, opts Options) Result {
+	return e.EstimateText(text, opts)
+}
+
+func (e panicTimeoutEstimator) EstimateOutput(text string, opts Options) Result {
+	return e.EstimateText(text, opts)
+}
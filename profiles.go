@@ -6,6 +6,14 @@ func resolveProfile(opts Options) Profile {
 	if opts.Profile != ProfileAuto {
 		return opts.Profile
 	}
+	if defaults.profile != ProfileAuto && opts.Model == "" && opts.ProviderType == "" {
+		return defaults.profile
+	}
+
+	model := strings.ToLower(strings.TrimSpace(opts.Model))
+	if profile, ok := lookupRegisteredModel(model); ok && profile != ProfileAuto {
+		return profile
+	}
 
 	providerType := strings.ToLower(strings.TrimSpace(opts.ProviderType))
 	switch {
@@ -15,15 +23,75 @@ func resolveProfile(opts Options) Profile {
 		return ProfileGemini
 	case providerType == "openai" || strings.Contains(providerType, "openai"):
 		return ProfileOpenAI
+	case providerType == "alibaba" || providerType == "dashscope" || strings.Contains(providerType, "qwen"):
+		return ProfileQwen
 	}
 
-	model := strings.ToLower(strings.TrimSpace(opts.Model))
 	switch {
 	case strings.Contains(model, "claude"):
 		return ProfileClaude
 	case strings.Contains(model, "gemini"):
 		return ProfileGemini
+	case strings.Contains(model, "qwen") || strings.Contains(model, "qwq"):
+		return ProfileQwen
 	default:
 		return ProfileOpenAI
 	}
 }
+
+// Tokenizer encoding names returned by EncodingForModel.
+const (
+	EncodingO200K  = "o200k_base"
+	EncodingCL100K = "cl100k_base"
+	EncodingP50K   = "p50k_base"
+	EncodingR50K   = "r50k_base"
+)
+
+// EncodingForModel returns the OpenAI tokenizer encoding used by model (e.g., "gpt-4o" ->
+// "o200k_base", "gpt-3.5-turbo" -> "cl100k_base"). It returns "" for non-OpenAI or unknown models.
+func EncodingForModel(model string) string {
+	model = strings.ToLower(strings.TrimSpace(model))
+	switch {
+	case model == "":
+		return ""
+	case strings.HasPrefix(model, "gpt-4o"), strings.HasPrefix(model, "chatgpt-4o"),
+		strings.HasPrefix(model, "gpt-4.1"), strings.HasPrefix(model, "gpt-4.5"),
+		strings.HasPrefix(model, "gpt-5"), strings.HasPrefix(model, "gpt-oss"),
+		isModelFamily(model, "o1"), isModelFamily(model, "o3"), isModelFamily(model, "o4"):
+		return EncodingO200K
+	case strings.HasPrefix(model, "gpt-4"), strings.HasPrefix(model, "gpt-3.5"),
+		strings.HasPrefix(model, "gpt-35"), strings.HasPrefix(model, "text-embedding-"),
+		model == "davinci-002", model == "babbage-002":
+		return EncodingCL100K
+	case strings.HasPrefix(model, "text-davinci-002"), strings.HasPrefix(model, "text-davinci-003"),
+		strings.HasPrefix(model, "code-"):
+		return EncodingP50K
+	case isModelFamily(model, "davinci"), isModelFamily(model, "curie"),
+		isModelFamily(model, "babbage"), isModelFamily(model, "ada"),
+		strings.HasPrefix(model, "text-davinci-001"):
+		return EncodingR50K
+	default:
+		return ""
+	}
+}
+
+// isModelFamily reports whether model is family itself or a dated or sized variant of it
+// ("o1", "o1-mini"), so short names do not match unrelated models ("o1x", "adam-7b").
+func isModelFamily(model, family string) bool {
+	return model == family || strings.HasPrefix(model, family+"-")
+}
+
+func parseProfile(name string) (Profile, bool) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "openai":
+		return ProfileOpenAI, true
+	case "claude":
+		return ProfileClaude, true
+	case "gemini":
+		return ProfileGemini, true
+	case "qwen":
+		return ProfileQwen, true
+	default:
+		return ProfileAuto, false
+	}
+}
@@ -0,0 +1,255 @@
+package tokenest
+
+import (
+	"io"
+	"unicode"
+	"unicode/utf8"
+)
+
+const readerChunkSize = 32 * 1024
+
+// EstimateReader estimates tokens from r without requiring the caller to hold the whole input.
+// With StrategyAuto, this uses UltraFast estimation, matching EstimateBytes.
+//
+// UltraFast counts bytes as they stream through. Fast accumulates its CJK/punctuation ratios
+// over every rune instead of a head/mid/tail sample, so inputs up to the Fast sample size match
+// EstimateText exactly. Weighted and ZR buffer the input and delegate to EstimateText.
+// Normalize and SpecialTokens apply to Fast, Weighted, and ZR, and are ignored by UltraFast as
+// in EstimateBytes and EstimateText. Any read error is returned rather than estimating a
+// truncated input. With Options.Strict, options that cannot be honored exactly return an error
+// wrapping ErrStrict before reading.
+func EstimateReader(r io.Reader, opts Options) (Result, error) {
+	res, _, err := EstimateReaderN(r, opts)
+	return res, err
+}
+
+// EstimateReaderN is EstimateReader that also returns the number of bytes read from r, such as
+// for setting Content-Length on a proxied body or detecting truncation. bytesRead is reported
+// even when a read error is returned. To estimate a body while forwarding it, write it through
+// an Accumulator instead, e.g. io.TeeReader(body, acc), and read acc.Result and
+// acc.BytesWritten once the body is consumed.
+func EstimateReaderN(r io.Reader, opts Options) (res Result, bytesRead int64, err error) {
+	if err := opts.strictError(effectiveBytesStrategy(opts.Strategy)); err != nil {
+		return Result{}, 0, err
+	}
+	acc := NewAccumulator(opts)
+	bytesRead, err = io.CopyBuffer(acc, r, make([]byte, readerChunkSize))
+	if err != nil {
+		return Result{}, bytesRead, err
+	}
+	return notify(opts.Observer, acc.Result()), bytesRead, nil
+}
+
+// Accumulator keeps a running estimate over text written to it in chunks, such as streamed
+// response deltas. It implements io.Writer; writes may split multi-byte runes, which are carried
+// over to the next write.
+//
+// UltraFast and Fast update their counts incrementally, so Tokens costs O(1) regardless of how
+// much has been written. UltraFast decodes a write only while everything before it was
+// whitespace. Fast uses every rune rather than a sample, like EstimateReader, and keeps only
+// its running counts, so memory stays constant however long the stream is.
+// Weighted and ZR buffer the text and re-estimate it on each Tokens call. So does Fast when
+// Normalize or SpecialTokens is set, since both rewrite the text before it is counted; it still
+// counts every rune. UltraFast ignores both options, as in EstimateBytes.
+// StrategyAuto uses UltraFast. An Accumulator is not safe for concurrent use.
+type Accumulator struct {
+	opts     Options
+	strategy Strategy
+	profile  Profile
+	fast     fastAccumulator
+	blank    blankScanner
+	// head holds the first confidenceSampleBytes written, which Result classifies for
+	// Confidence when the text itself is not buffered.
+	head    []byte
+	buf     []byte
+	written int64
+}
+
+// NewAccumulator returns an empty Accumulator estimating with opts. Its running results are
+// not reported to opts.Observer; EstimateReader reports the final one.
+func NewAccumulator(opts Options) *Accumulator {
+	strategy := effectiveBytesStrategy(opts.Strategy)
+	opts.Strategy = strategy
+	opts.Observer = nil
+	return &Accumulator{opts: opts, strategy: strategy, profile: resolveProfile(opts)}
+}
+
+// Write adds p to the accumulated text. It never fails.
+func (a *Accumulator) Write(p []byte) (int, error) {
+	a.written += int64(len(p))
+	if n := confidenceSampleBytes - len(a.head); n > 0 && !a.buffers() {
+		a.head = append(a.head, p[:min(n, len(p))]...)
+	}
+	switch {
+	case a.buffers():
+		a.buf = append(a.buf, p...)
+	case a.strategy == StrategyUltraFast:
+		a.blank.write(p)
+	default:
+		a.fast.write(p)
+	}
+	return len(p), nil
+}
+
+// buffers reports whether the Accumulator keeps the written text instead of running counts.
+func (a *Accumulator) buffers() bool {
+	switch a.strategy {
+	case StrategyUltraFast:
+		return false
+	case StrategyFast:
+		return a.opts.Normalize || len(a.opts.SpecialTokens) > 0
+	default:
+		return true
+	}
+}
+
+// Tokens returns the estimate for everything written so far. A rune split by the last write
+// counts as invalid bytes until the rest of it arrives.
+func (a *Accumulator) Tokens() int {
+	return a.Result().Tokens
+}
+
+// BytesWritten returns the total number of bytes written so far.
+func (a *Accumulator) BytesWritten() int64 {
+	return a.written
+}
+
+// Result returns the full Result for everything written so far, as EstimateReader would
+// for the same input. Like Tokens, it can be called between writes.
+func (a *Accumulator) Result() Result {
+	if a.strategy != StrategyUltraFast && a.strategy != StrategyFast {
+		return EstimateText(string(a.buf), a.opts)
+	}
+
+	// Finish a copy so a pending partial rune stays pending for later writes.
+	fast := a.fast
+	head := a.head
+	var specialTokens, specialMatches int
+	if a.buffers() {
+		text := string(a.buf)
+		if a.opts.Normalize {
+			text = normalizeText(text)
+		}
+		text, specialTokens, specialMatches = extractSpecialTokens(text, a.opts.SpecialTokens)
+		fast = fastAccumulator{}
+		fast.write([]byte(text))
+		head = []byte(text[:min(confidenceSampleBytes, len(text))])
+	}
+
+	tokens := 0
+	var breakdown []CategoryBreakdown
+	if a.strategy == StrategyUltraFast {
+		tokens = EstimateByteLen(int(a.written))
+		if a.blank.blank() {
+			tokens = blankInputTokens(a.blank.runes)
+		}
+	} else {
+		tokens = fast.tokens() + specialTokens
+		if a.opts.Explain {
+			breakdown = appendSpecialTokensBreakdown(fast.counts.appendRatios(nil), specialTokens, specialMatches)
+		}
+	}
+	result := Result{
+		Tokens:    applyMultiplier(tokens, a.opts.GlobalMultiplier),
+		Strategy:  a.strategy,
+		Profile:   a.profile,
+		Breakdown: breakdown,
+	}
+	result.setConfidence(a.strategy, quickContent(head))
+	return result
+}
+
+// blankScanner tracks whether streamed bytes are only whitespace, as estimateUltraFast checks
+// them. It decodes runes only until the first other rune and skips every later write, so
+// UltraFast stays O(1) per write once the text is known not to be blank.
+type blankScanner struct {
+	// runes counts the whitespace runes seen while the text is still blank.
+	runes      int
+	nonBlank   bool
+	pending    [utf8.UTFMax]byte
+	pendingLen int
+}
+
+func (s *blankScanner) write(p []byte) {
+	if s.nonBlank {
+		return
+	}
+	if s.pendingLen > 0 {
+		p = append(s.pending[:s.pendingLen:s.pendingLen], p...)
+		s.pendingLen = 0
+	}
+	for len(p) > 0 {
+		if !utf8.FullRune(p) {
+			s.pendingLen = copy(s.pending[:], p)
+			return
+		}
+		r, size := utf8.DecodeRune(p)
+		if !unicode.IsSpace(r) {
+			s.nonBlank = true
+			return
+		}
+		s.runes++
+		p = p[size:]
+	}
+}
+
+// blank reports whether the text written so far is non-empty and only whitespace. A pending
+// partial rune is an invalid byte until the rest of it arrives, so it makes the text non-blank.
+func (s *blankScanner) blank() bool {
+	return !s.nonBlank && s.runes > 0 && s.pendingLen == 0
+}
+
+// fastAccumulator tracks Fast statistics over streamed bytes. Incomplete UTF-8 sequences at
+// chunk boundaries are carried over to the next write.
+type fastAccumulator struct {
+	bytes      int
+	counts     fastCounts
+	pending    [utf8.UTFMax]byte
+	pendingLen int
+}
+
+func (a *fastAccumulator) write(p []byte) {
+	a.bytes += len(p)
+
+	if a.pendingLen > 0 {
+		// Replay the carried-over bytes in front of p, so bytes after an invalid lead byte decode
+		// exactly as they would in a single write.
+		p = append(a.pending[:a.pendingLen:a.pendingLen], p...)
+		a.pendingLen = 0
+	}
+
+	for len(p) > 0 {
+		if !utf8.FullRune(p) {
+			a.pendingLen = copy(a.pending[:], p)
+			return
+		}
+		r, size := utf8.DecodeRune(p)
+		a.addRune(r, size)
+		p = p[size:]
+	}
+}
+
+func (a *fastAccumulator) addRune(r rune, size int) {
+	if r == utf8.RuneError && size == 1 {
+		a.counts.addInvalid()
+		return
+	}
+	a.counts.add(r)
+}
+
+// flush counts any trailing incomplete sequence as invalid bytes, one unit per byte,
+// matching how ranging over a string treats truncated UTF-8.
+func (a *fastAccumulator) flush() {
+	for i := 0; i < a.pendingLen; i++ {
+		a.counts.addInvalid()
+	}
+	a.pendingLen = 0
+}
+
+func (a *fastAccumulator) tokens() int {
+	a.flush()
+	if a.bytes == 0 {
+		return 0
+	}
+	return a.counts.tokens(a.bytes, a.bytes)
+}
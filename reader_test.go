@@ -0,0 +1,256 @@
+package tokenest
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"unicode/utf8"
+)
+
+func TestEstimateReaderMatchesInMemory(t *testing.T) {
+	text := "Hello, 你好世界! {\"k\": [1, 2, 3]}"
+	for _, strategy := range []Strategy{StrategyUltraFast, StrategyFast, StrategyWeighted, StrategyZR} {
+		opts := Options{Strategy: strategy, Profile: ProfileOpenAI}
+		// OneByteReader splits every multi-byte rune across reads.
+		res, err := EstimateReader(iotest.OneByteReader(strings.NewReader(text)), opts)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", strategy, err)
+		}
+		want := EstimateText(text, opts).Tokens
+		if res.Tokens != want {
+			t.Fatalf("%v: expected %d tokens, got %d", strategy, want, res.Tokens)
+		}
+	}
+}
+
+func TestEstimateReaderSetsConfidence(t *testing.T) {
+	useTestNormalizer(t)
+	texts := []string{
+		"Plain prose about streaming estimates, long enough to classify.",
+		`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`,
+	}
+	for _, text := range texts {
+		for _, opts := range []Options{
+			{Strategy: StrategyUltraFast},
+			{Strategy: StrategyFast},
+			{Strategy: StrategyFast, Normalize: true},
+			{Strategy: StrategyWeighted, Profile: ProfileOpenAI},
+		} {
+			res, err := EstimateReader(iotest.OneByteReader(strings.NewReader(text)), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := EstimateText(text, opts)
+			if res.Confidence == 0 || res.Confidence != want.Confidence ||
+				res.MinTokens != want.MinTokens || res.MaxTokens != want.MaxTokens {
+				t.Fatalf("%v on %.20q: expected confidence %v [%d, %d], got %v [%d, %d]", opts.Strategy, text,
+					want.Confidence, want.MinTokens, want.MaxTokens, res.Confidence, res.MinTokens, res.MaxTokens)
+			}
+		}
+	}
+}
+
+func TestEstimateReaderAutoUsesUltraFast(t *testing.T) {
+	res, err := EstimateReader(strings.NewReader("abcdefgh"), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Strategy != StrategyUltraFast || res.Tokens != 2 {
+		t.Fatalf("expected UltraFast with 2 tokens, got %v with %d", res.Strategy, res.Tokens)
+	}
+}
+
+func TestEstimateReaderSurfacesReadError(t *testing.T) {
+	boom := errors.New("boom")
+	r := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(boom))
+	if _, err := EstimateReader(r, Options{Strategy: StrategyFast}); !errors.Is(err, boom) {
+		t.Fatalf("expected read error, got %v", err)
+	}
+}
+
+func TestAccumulatorTracksRunningEstimate(t *testing.T) {
+	text := "Streaming 你好世界 response, chunk by chunk! 🚀"
+	for _, strategy := range []Strategy{StrategyUltraFast, StrategyFast, StrategyWeighted} {
+		opts := Options{Strategy: strategy, Profile: ProfileOpenAI}
+		acc := NewAccumulator(opts)
+		// Three-byte chunks split the CJK runes and the emoji across writes.
+		for i := 0; i < len(text); i += 3 {
+			end := min(i+3, len(text))
+			acc.Write([]byte(text[i:end]))
+			if prefix := text[:end]; utf8.ValidString(prefix) {
+				if got, want := acc.Tokens(), EstimateText(prefix, opts).Tokens; got != want {
+					t.Fatalf("%v: after %d bytes expected %d tokens, got %d", strategy, end, want, got)
+				}
+			}
+		}
+		if got, want := acc.Tokens(), EstimateText(text, opts).Tokens; got != want {
+			t.Fatalf("%v: expected %d tokens, got %d", strategy, want, got)
+		}
+	}
+}
+
+func TestAccumulatorSplitWriteAfterInvalidByte(t *testing.T) {
+	// A stray lead byte followed by a write that starts with complete runes: the bytes after the
+	// invalid one must decode in order, as they do in a single write.
+	first, rest := "\xe4", strings.Repeat("中文字符 ", 12)
+	text := first + rest
+	opts := Options{Strategy: StrategyFast, Profile: ProfileOpenAI}
+	want := EstimateText(text, opts).Tokens
+
+	whole := NewAccumulator(opts)
+	whole.Write([]byte(text))
+	split := NewAccumulator(opts)
+	split.Write([]byte(first))
+	split.Write([]byte(rest))
+	if got := whole.Tokens(); got != want {
+		t.Fatalf("single write: expected %d tokens, got %d", want, got)
+	}
+	if got := split.Tokens(); got != want {
+		t.Fatalf("split write: expected %d tokens, got %d", want, got)
+	}
+}
+
+func TestEstimateReaderAppliesTextOptions(t *testing.T) {
+	useTestNormalizer(t)
+	text := strings.Repeat("<|im_start|>user\nCafe\u0301 au lait?<|im_end|>\n", 4)
+	opts := Options{
+		Strategy:      StrategyFast,
+		Explain:       true,
+		Normalize:     true,
+		SpecialTokens: map[string]int{"<|im_start|>": 1, "<|im_end|>": 1},
+	}
+	// The text is shorter than the Fast sample, so counting every rune matches EstimateText.
+	want := EstimateText(text, opts)
+	res, err := EstimateReader(iotest.OneByteReader(strings.NewReader(text)), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Tokens != want.Tokens {
+		t.Fatalf("expected %d tokens, got %d", want.Tokens, res.Tokens)
+	}
+	if n := len(res.Breakdown); n == 0 || res.Breakdown[n-1].Category != want.Breakdown[len(want.Breakdown)-1].Category {
+		t.Fatalf("expected the special-token breakdown, got %+v", res.Breakdown)
+	}
+	if plain, _ := EstimateReader(strings.NewReader(text), Options{Strategy: StrategyFast}); plain.Tokens <= res.Tokens {
+		t.Fatalf("expected special tokens to lower the estimate below %d, got %d", plain.Tokens, res.Tokens)
+	}
+}
+
+func TestUltraFastIgnoresSpecialTokensEverywhere(t *testing.T) {
+	text := "<|im_start|>user\nHello there<|im_end|>"
+	opts := Options{Strategy: StrategyUltraFast, SpecialTokens: map[string]int{"<|im_start|>": 1, "<|im_end|>": 1}}
+	want := EstimateByteLen(len(text))
+	if got := EstimateText(text, opts).Tokens; got != want {
+		t.Fatalf("EstimateText: expected %d tokens, got %d", want, got)
+	}
+	if got := EstimateBytes([]byte(text), opts).Tokens; got != want {
+		t.Fatalf("EstimateBytes: expected %d tokens, got %d", want, got)
+	}
+	res, err := EstimateReader(strings.NewReader(text), opts)
+	if err != nil || res.Tokens != want {
+		t.Fatalf("EstimateReader: expected %d tokens, got %d (%v)", want, res.Tokens, err)
+	}
+	if ExceedsTokens(text, want, opts) || !ExceedsTokens(text, want-1, opts) {
+		t.Fatalf("ExceedsTokens disagrees with the %d-token estimate", want)
+	}
+	if err := opts.Validate(); !errors.Is(err, ErrIneffectiveOption) {
+		t.Fatalf("expected Validate to report SpecialTokens as ignored, got %v", err)
+	}
+}
+
+func TestAccumulatorFastDoesNotBuffer(t *testing.T) {
+	// Prose, then CJK, then JSON: head/mid/tail sampling would see each part once, while the
+	// accumulator counts all of them.
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 4000) +
+		strings.Repeat("你好世界，这是一个测试。", 4000) +
+		strings.Repeat(`{"id":1,"tags":["a","b"]},`, 4000)
+
+	acc := NewAccumulator(Options{Strategy: StrategyFast})
+	for i := 0; i < len(text); i += 4096 {
+		acc.Write([]byte(text[i:min(i+4096, len(text))]))
+	}
+	if acc.buf != nil {
+		t.Fatalf("Fast accumulator buffered %d bytes", len(acc.buf))
+	}
+
+	var counts fastCounts
+	for i, r := range text {
+		if isInvalidByteAt(text, i, r) {
+			counts.addInvalid()
+			continue
+		}
+		counts.add(r)
+	}
+	if got, want := acc.Tokens(), counts.tokens(len(text), len(text)); got != want {
+		t.Fatalf("expected %d tokens from counting every rune, got %d", want, got)
+	}
+}
+
+func TestAccumulatorUltraFastStopsScanningAtText(t *testing.T) {
+	opts := Options{Strategy: StrategyUltraFast}
+	blank := " \u3000\n" + strings.Repeat(" ", 40)
+	acc := NewAccumulator(opts)
+	// Split the ideographic space across writes.
+	acc.Write([]byte(blank[:2]))
+	acc.Write([]byte(blank[2:]))
+	if got, want := acc.Tokens(), EstimateText(blank, opts).Tokens; got != want {
+		t.Fatalf("expected %d tokens for blank input, got %d", want, got)
+	}
+
+	text := blank + strings.Repeat("The quick brown fox. ", 100)
+	acc.Write([]byte(text[len(blank):]))
+	if !acc.blank.nonBlank || acc.fast.counts.totalRunes != 0 {
+		t.Fatalf("expected UltraFast to record only non-blank text, got %+v", acc.blank)
+	}
+	runes := acc.blank.runes
+	acc.Write([]byte(strings.Repeat("more text ", 100)))
+	if acc.blank.runes != runes {
+		t.Fatal("expected writes after non-blank text to be skipped")
+	}
+	if got, want := acc.Tokens(), EstimateByteLen(len(text)+1000); got != want {
+		t.Fatalf("expected %d tokens, got %d", want, got)
+	}
+}
+
+func TestEstimateReaderNReportsBytesRead(t *testing.T) {
+	text := "Hello, 你好世界! {\"k\": [1, 2, 3]}"
+	for _, strategy := range []Strategy{StrategyUltraFast, StrategyFast, StrategyWeighted} {
+		opts := Options{Strategy: strategy}
+		res, n, err := EstimateReaderN(strings.NewReader(text), opts)
+		if err != nil || n != int64(len(text)) {
+			t.Fatalf("%v: expected %d bytes read, got %d, %v", strategy, len(text), n, err)
+		}
+		if want := EstimateText(text, opts).Tokens; res.Tokens != want {
+			t.Fatalf("%v: expected %d tokens, got %d", strategy, want, res.Tokens)
+		}
+	}
+
+	boom := errors.New("boom")
+	r := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(boom))
+	if _, n, err := EstimateReaderN(r, Options{Strategy: StrategyFast}); !errors.Is(err, boom) || n != int64(len("partial")) {
+		t.Fatalf("expected the read error after %d bytes, got %d, %v", len("partial"), n, err)
+	}
+}
+
+func TestAccumulatorAsTeeWriter(t *testing.T) {
+	text := strings.Repeat("proxied body 你好 ", 100)
+	opts := Options{Strategy: StrategyFast}
+	acc := NewAccumulator(opts)
+
+	var forwarded strings.Builder
+	if _, err := io.Copy(&forwarded, io.TeeReader(strings.NewReader(text), acc)); err != nil {
+		t.Fatal(err)
+	}
+	if forwarded.String() != text {
+		t.Fatal("expected the tee to forward the body unchanged")
+	}
+	if acc.BytesWritten() != int64(len(text)) {
+		t.Fatalf("expected %d bytes written, got %d", len(text), acc.BytesWritten())
+	}
+	want, _, _ := EstimateReaderN(strings.NewReader(text), opts)
+	if got := acc.Result(); got.Tokens != want.Tokens || got.Strategy != StrategyFast {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+}
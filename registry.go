@@ -0,0 +1,147 @@
+package tokenest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+type modelMapping struct {
+	pattern string
+	profile Profile
+}
+
+var modelRegistry struct {
+	mu       sync.RWMutex
+	mappings []modelMapping
+}
+
+// RegisterModel maps models whose name contains modelPattern (case-insensitive) to profile.
+// Registered mappings are consulted before the built-in provider/model matching; when several
+// patterns match, the longest one wins. Registering the same pattern again replaces its profile.
+func RegisterModel(modelPattern string, profile Profile) {
+	pattern := strings.ToLower(strings.TrimSpace(modelPattern))
+	if pattern == "" {
+		return
+	}
+
+	modelRegistry.mu.Lock()
+	defer modelRegistry.mu.Unlock()
+	for i := range modelRegistry.mappings {
+		if modelRegistry.mappings[i].pattern == pattern {
+			modelRegistry.mappings[i].profile = profile
+			return
+		}
+	}
+	modelRegistry.mappings = append(modelRegistry.mappings, modelMapping{pattern: pattern, profile: profile})
+}
+
+// LoadModelMappings registers model mappings from a JSON file of the form
+// {"my-finetuned-gpt": "openai", "new-model": "claude"}.
+// Profile names match Profile.String(). Nothing is registered if any entry is invalid.
+func LoadModelMappings(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var raw map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("%w: model mappings %s: %v", ErrConfigInvalid, path, err)
+	}
+
+	mappings := make(map[string]Profile, len(raw))
+	for pattern, name := range raw {
+		profile, ok := parseProfile(name)
+		if !ok {
+			return fmt.Errorf("%w: %q (model mapping %q)", ErrUnknownProfile, name, pattern)
+		}
+		mappings[pattern] = profile
+	}
+	for pattern, profile := range mappings {
+		RegisterModel(pattern, profile)
+	}
+	return nil
+}
+
+// RegisterLanguage adds a language to the Weighted strategy's per-word detection and to
+// Options.Language, such as "es" with its accented letters or "vi" with its tone marks. A word
+// whose runes are at least 30% from runes (ASCII runes are ignored) costs charsPerToken per
+// rune, like the built-in French and Polish/Czech sets (German matches from 9%, since most
+// German words carry a single umlaut or ß); languages are tried in registration order after
+// the built-in ones, and the first match wins. name is the ISO 639-1 code accepted by
+// Options.Language; it is lower-cased and any region suffix is dropped.
+// Registering an existing name, including a built-in one, replaces its settings in place.
+// Empty names, "en", non-positive charsPerToken, and registrations beyond 64 languages are
+// ignored.
+//
+// Registration is process-wide and affects every subsequent Weighted estimate. The language
+// table is part of every cache key (see WithCache and EstimateKey), so results cached before a
+// registration that changes it are no longer returned. ZR keeps its fitted language sets.
+func RegisterLanguage(name string, charsPerToken float64, runes []rune) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if i := strings.IndexAny(name, "-_"); i >= 0 {
+		name = name[:i]
+	}
+	if name == "" || name == "en" || !(charsPerToken > 0) || math.IsInf(charsPerToken, 0) {
+		return
+	}
+	set := make(map[rune]struct{}, len(runes))
+	for _, r := range runes {
+		if r >= utf8.RuneSelf {
+			set[r] = struct{}{}
+		}
+	}
+	cfg := languageConfig{code: name, avgCharsPerToken: charsPerToken, set: set}
+
+	languages.mu.Lock()
+	defer languages.mu.Unlock()
+	configs := slices.Clone(currentLanguages().configs)
+	if i := slices.IndexFunc(configs, func(c languageConfig) bool { return c.code == name }); i >= 0 {
+		configs[i] = cfg
+	} else if len(configs) < maxLanguages {
+		configs = append(configs, cfg)
+	} else {
+		return
+	}
+	languages.table.Store(newLanguageTable(configs))
+}
+
+// Languages returns the codes of the languages the Weighted strategy detects, built-in and
+// registered, in match order.
+func Languages() []string {
+	configs := currentLanguages().configs
+	names := make([]string, 0, len(configs))
+	for _, cfg := range configs {
+		names = append(names, cfg.code)
+	}
+	return names
+}
+
+func lookupRegisteredModel(model string) (Profile, bool) {
+	if model == "" {
+		return ProfileAuto, false
+	}
+
+	modelRegistry.mu.RLock()
+	defer modelRegistry.mu.RUnlock()
+
+	best := -1
+	for i, m := range modelRegistry.mappings {
+		if !strings.Contains(model, m.pattern) {
+			continue
+		}
+		if best < 0 || len(m.pattern) > len(modelRegistry.mappings[best].pattern) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return ProfileAuto, false
+	}
+	return modelRegistry.mappings[best].profile, true
+}
@@ -0,0 +1,162 @@
+package tokenest
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func resetModelRegistry(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() {
+		modelRegistry.mu.Lock()
+		modelRegistry.mappings = nil
+		modelRegistry.mu.Unlock()
+	})
+}
+
+func TestRegisterModelOverridesBuiltinMatching(t *testing.T) {
+	resetModelRegistry(t)
+	RegisterModel("my-claude-distill", ProfileOpenAI)
+	RegisterModel("brand-new", ProfileGemini)
+
+	if got := resolveProfile(Options{Model: "My-Claude-Distill-v2"}); got != ProfileOpenAI {
+		t.Fatalf("expected ProfileOpenAI, got %v", got)
+	}
+	if got := resolveProfile(Options{Model: "brand-new-7b", ProviderType: "openai"}); got != ProfileGemini {
+		t.Fatalf("expected ProfileGemini, got %v", got)
+	}
+	if got := resolveProfile(Options{Model: "brand-new-7b", Profile: ProfileClaude}); got != ProfileClaude {
+		t.Fatalf("expected explicit ProfileClaude, got %v", got)
+	}
+}
+
+func TestRegisterModelLongestPatternWins(t *testing.T) {
+	resetModelRegistry(t)
+	RegisterModel("acme", ProfileClaude)
+	RegisterModel("acme-vision", ProfileGemini)
+
+	if got := resolveProfile(Options{Model: "acme-vision-1"}); got != ProfileGemini {
+		t.Fatalf("expected ProfileGemini, got %v", got)
+	}
+	if got := resolveProfile(Options{Model: "acme-chat"}); got != ProfileClaude {
+		t.Fatalf("expected ProfileClaude, got %v", got)
+	}
+}
+
+func TestLoadModelMappings(t *testing.T) {
+	resetModelRegistry(t)
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "models.json")
+	if err := os.WriteFile(path, []byte(`{"my-finetuned-gpt": "claude"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadModelMappings(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := resolveProfile(Options{Model: "my-finetuned-gpt"}); got != ProfileClaude {
+		t.Fatalf("expected ProfileClaude, got %v", got)
+	}
+
+	bad := filepath.Join(dir, "bad.json")
+	if err := os.WriteFile(bad, []byte(`{"other": "llama"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadModelMappings(bad); !errors.Is(err, ErrUnknownProfile) {
+		t.Fatalf("expected ErrUnknownProfile, got %v", err)
+	}
+
+	malformed := filepath.Join(dir, "malformed.json")
+	if err := os.WriteFile(malformed, []byte(`{`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadModelMappings(malformed); !errors.Is(err, ErrConfigInvalid) {
+		t.Fatalf("expected ErrConfigInvalid, got %v", err)
+	}
+	if got := resolveProfile(Options{Model: "other"}); got != ProfileOpenAI {
+		t.Fatalf("expected invalid file to register nothing, got %v", got)
+	}
+}
+
+func resetLanguages(t *testing.T) {
+	t.Helper()
+	saved := currentLanguages()
+	t.Cleanup(func() {
+		languages.table.Store(saved)
+	})
+}
+
+func TestRegisterLanguageExtendsDetection(t *testing.T) {
+	resetLanguages(t)
+	// Letters of the built-in sets beyond Latin-1 count as word characters.
+	if !isAlphanumericSegment("łódź") {
+		t.Fatal("expected Polish letters to form an alphanumeric segment")
+	}
+
+	const word = "ağaçlığı" // Turkish: ğ, ı are not in any built-in set
+	if isAlphanumericSegment(word) {
+		t.Fatal("expected unregistered Turkish letters to be costed per rune")
+	}
+	if got := getLanguageSpecificCharsPerToken(word); got != 0 {
+		t.Fatalf("expected no built-in match, got %v", got)
+	}
+	before := EstimateText(word, Options{Strategy: StrategyWeighted}).Tokens
+
+	RegisterLanguage("tr-TR", 2.5, []rune("çğıöşüÇĞİÖŞÜ"))
+	if got := getLanguageSpecificCharsPerToken(word); got != 2.5 {
+		t.Fatalf("expected registered chars-per-token 2.5, got %v", got)
+	}
+	if got := languageCharsPerToken("tr", defaultCharsPerToken); got != 2.5 {
+		t.Fatalf("expected Options.Language to accept \"tr\", got %v", got)
+	}
+	if after := EstimateText(word, Options{Strategy: StrategyWeighted}).Tokens; after == before {
+		t.Fatalf("expected registration to change the estimate from %d", before)
+	}
+
+	names := Languages()
+	if len(names) != len(defaultLanguageConfigs)+1 || names[len(names)-1] != "tr" {
+		t.Fatalf("unexpected languages %v", names)
+	}
+
+	// Re-registering replaces in place; invalid registrations are ignored.
+	RegisterLanguage("TR", 3, []rune("çğı"))
+	RegisterLanguage("", 2, []rune("ñ"))
+	RegisterLanguage("es", 0, []rune("ñ"))
+	RegisterLanguage("en", 2, []rune("ñ"))
+	if got := Languages(); len(got) != len(names) {
+		t.Fatalf("expected %d languages after replacing and invalid calls, got %v", len(names), got)
+	}
+	if got := getLanguageSpecificCharsPerToken(word); got != 3 {
+		t.Fatalf("expected replaced chars-per-token 3, got %v", got)
+	}
+}
+
+func TestRegisterLanguageChangesCacheKeys(t *testing.T) {
+	resetLanguages(t)
+	const word = "ağaçlığı"
+	opts := Options{Strategy: StrategyWeighted}
+	cached := WithCache(DefaultEstimator(), 16)
+	before := cached.EstimateText(word, opts).Tokens
+	key := EstimateKey(word, opts)
+
+	RegisterLanguage("tr", 2.5, []rune("çğıöşü"))
+	if got := EstimateKey(word, opts); got == key {
+		t.Fatal("expected registration to change the cache key")
+	}
+	want := EstimateText(word, opts).Tokens
+	if want == before {
+		t.Fatalf("expected registration to change the estimate from %d", before)
+	}
+	if got := cached.EstimateText(word, opts).Tokens; got != want {
+		t.Fatalf("expected the cache to return the new estimate %d, got %d", want, got)
+	}
+
+	// Re-registering the same settings leaves keys unchanged.
+	key = EstimateKey(word, opts)
+	RegisterLanguage("tr", 2.5, []rune("üşöığç"))
+	if got := EstimateKey(word, opts); got != key {
+		t.Fatal("expected an identical registration to keep the cache key")
+	}
+}
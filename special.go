@@ -0,0 +1,86 @@
+package tokenest
+
+import (
+	"sort"
+	"strings"
+)
+
+// specialTokensCategory is the breakdown category for Options.SpecialTokens matches.
+const specialTokensCategory = "special_tokens"
+
+// extractSpecialTokens removes every occurrence of a key of special from text and returns the
+// remaining text, the summed token cost of the matches, and the number of matches. At each
+// position the longest key wins, and matching resumes after it, as tokenizers do for added
+// tokens. Each match is replaced by a single space so the text on either side does not merge
+// into one segment. Text made only of matches leaves no rest at all, so those separators are
+// not costed as whitespace-only input. Empty keys are ignored and negative costs count as zero.
+func extractSpecialTokens(text string, special map[string]int) (rest string, tokens, matches int) {
+	if len(special) == 0 || text == "" {
+		return text, 0, 0
+	}
+
+	keys := make([]string, 0, len(special))
+	var first [256]bool
+	for key := range special {
+		if key == "" {
+			continue
+		}
+		keys = append(keys, key)
+		first[key[0]] = true
+	}
+	sort.Slice(keys, func(i, j int) bool { return len(keys[i]) > len(keys[j]) })
+
+	var b strings.Builder
+	last, between := 0, 0
+	for i := 0; i < len(text); i++ {
+		if !first[text[i]] {
+			continue
+		}
+		for _, key := range keys {
+			if !strings.HasPrefix(text[i:], key) {
+				continue
+			}
+			if matches == 0 {
+				b.Grow(len(text))
+			}
+			between += i - last
+			b.WriteString(text[last:i])
+			b.WriteByte(' ')
+			tokens += max(special[key], 0)
+			matches++
+			i += len(key) - 1
+			last = i + 1
+			break
+		}
+	}
+	if matches == 0 {
+		return text, 0, 0
+	}
+	if between+len(text)-last == 0 {
+		return "", tokens, matches
+	}
+	b.WriteString(text[last:])
+	return b.String(), tokens, matches
+}
+
+// specialTokensFor returns the special tokens strategy applies: opts.SpecialTokens, except for
+// UltraFast, which only counts bytes and does not scan the input for them.
+func specialTokensFor(opts Options, strategy Strategy) map[string]int {
+	if strategy == StrategyUltraFast {
+		return nil
+	}
+	return opts.SpecialTokens
+}
+
+// appendSpecialTokensBreakdown records the special-token matches in breakdown.
+func appendSpecialTokensBreakdown(breakdown []CategoryBreakdown, tokens, matches int) []CategoryBreakdown {
+	if matches == 0 {
+		return breakdown
+	}
+	return append(breakdown, CategoryBreakdown{
+		Category:  specialTokensCategory,
+		BaseUnits: float64(matches),
+		Weight:    float64(tokens) / float64(matches),
+		Tokens:    float64(tokens),
+	})
+}
@@ -0,0 +1,82 @@
+package tokenest
+
+import "unicode"
+
+// InputStats counts the content of a text by category, independent of strategy, profile, and
+// weights, for applying custom per-category pricing. Every valid rune falls into exactly one
+// of Letters, Digits, CJK, Hangul, Punctuation, Whitespace, Emoji, and Other, so those sum to
+// Runes; bytes that are not valid UTF-8 are counted in InvalidBytes instead.
+type InputStats struct {
+	// Bytes is the input length in bytes.
+	Bytes int
+	// Runes is the number of valid UTF-8 runes.
+	Runes int
+
+	// Letters counts letters and combining marks outside the CJK ranges (Latin, Cyrillic,
+	// Greek, Arabic, ...).
+	Letters int
+	// Digits counts decimal digits in any script.
+	Digits int
+	// CJK counts Han ideographs, kana, and the other runes of DefaultCJKRanges except Hangul
+	// syllables and CJK punctuation.
+	CJK int
+	// Hangul counts precomposed Hangul syllables (U+AC00-U+D7A3).
+	Hangul int
+	// Punctuation counts DefaultPunctuation and CJK punctuation such as "，" and "。".
+	Punctuation int
+	// Whitespace counts whitespace runes.
+	Whitespace int
+	// Emoji counts emoji runes, including joiners, variation selectors, and skin-tone modifiers.
+	Emoji int
+	// Other counts every remaining rune, such as quotes, math symbols, and control characters.
+	Other int
+
+	// InvalidBytes counts bytes that are not valid UTF-8.
+	InvalidBytes int
+
+	// Words counts maximal runs of Letters and Digits, so "don't" is two words and CJK text,
+	// which has no word separators, contributes none.
+	Words int
+}
+
+// Stats returns the category counts of text in one O(n) pass. Unlike Explain breakdowns, which
+// report the categories a strategy costed, Stats reports every category, including zero ones.
+func Stats(text string) InputStats {
+	stats := InputStats{Bytes: len(text)}
+	inWord := false
+	for i, r := range text {
+		if isInvalidByteAt(text, i, r) {
+			stats.InvalidBytes++
+			inWord = false
+			continue
+		}
+		stats.Runes++
+
+		word := false
+		switch {
+		case unicode.IsSpace(r):
+			stats.Whitespace++
+		case isTokenXPunct(r):
+			stats.Punctuation++
+		case r >= 0xAC00 && r <= 0xD7A3:
+			stats.Hangul++
+		case isCJKRune(r):
+			stats.CJK++
+		case isEmoji(r) || isEmojiComponent(r):
+			stats.Emoji++
+		case unicode.IsDigit(r):
+			stats.Digits++
+			word = true
+		case unicode.IsLetter(r) || unicode.IsMark(r):
+			stats.Letters++
+			word = true
+		default:
+			stats.Other++
+		}
+		if word && !inWord {
+			stats.Words++
+		}
+		inWord = word
+	}
+	return stats
+}
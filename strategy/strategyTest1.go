@@ -1,15 +1,22 @@
 package strategy
 
 import (
+	"context"
+	"errors"
 	"math"
 	"unicode"
 	"unicode/utf8"
+
+	"github.com/EZ-Api/tokenest/internal/cjk"
+	"github.com/EZ-Api/tokenest/internal/numeric"
 )
 
 type zrStats struct {
 	TotalRunes int
 	CJKRunes   int
 	PunctRunes int
+	// DigitRunes counts every digit, including those inside alphanumeric segments. The ZR
+	// coefficients were fitted on this definition, so it must not be narrowed without a re-fit.
 	DigitRunes int
 	SpaceRunes int
 	UpperRunes int
@@ -17,27 +24,102 @@ type zrStats struct {
 }
 
 func EstimateZR(text string) int {
+	return EstimateZRDetail(text).Tokens
+}
+
+// ZRDetail reports how EstimateZR arrived at its count.
+type ZRDetail struct {
+	// Tokens is the final estimate, identical to EstimateZR.
+	Tokens int
+	// Category is the input classification: "general", "capital", "dense", "hex", or "alnum".
+	Category string
+	// BaseTokens is the tokenx-style count before the polynomial adjustment.
+	BaseTokens int
+	// Coefficients is the coefficient row applied for Category. Callers must not modify it.
+	Coefficients []float64
+}
+
+// EstimateZRDetail is EstimateZR with the classification and coefficients it used.
+func EstimateZRDetail(text string) ZRDetail {
+	return EstimateZRDetailWithConfig(text, nil)
+}
+
+// EstimateZRDetailWithConfig is EstimateZRDetail using cfg's thresholds and coefficients.
+// A nil cfg uses the built-in defaults.
+func EstimateZRDetailWithConfig(text string, cfg *ZRConfig) ZRDetail {
+	detail, _ := EstimateZRDetailWithConfigCtx(context.Background(), text, cfg)
+	return detail
+}
+
+// EstimateZRDetailWithConfigCtx is EstimateZRDetailWithConfig that checks ctx every
+// ctxCheckBytes of input and returns ctx.Err() once it is done.
+func EstimateZRDetailWithConfigCtx(ctx context.Context, text string, cfg *ZRConfig) (ZRDetail, error) {
 	if text == "" {
-		return 0
+		return ZRDetail{}, nil
+	}
+
+	params, coefficients := cfg.params()
+	baseTokens, stats, err := estimateZRTokenXWithStats(ctx, text, params, nil)
+	if err != nil {
+		return ZRDetail{}, err
+	}
+	return zrDetail(baseTokens, stats, params, coefficients), nil
+}
+
+// errZRStopped ends estimateZRTokenXWithStats early when its stop function returns true.
+var errZRStopped = errors.New("strategy: ZR scan stopped")
+
+// ExceedsZRCtx reports whether exceeds returns true for the ZR estimate of text under cfg,
+// without scanning the rest of text once the answer is known. exceeds must be monotone: if
+// it is true for n tokens, it must be true for every larger n. Because the estimate is at
+// least zrClampMin times the tokenx base count, and that count only grows, the scan stops as
+// soon as exceeds holds for that floor. Like EstimateZRDetailWithConfigCtx, it returns
+// ctx.Err() once ctx is done.
+func ExceedsZRCtx(ctx context.Context, text string, cfg *ZRConfig, exceeds func(tokens int) bool) (bool, error) {
+	if text == "" {
+		return exceeds(0), nil
 	}
 
-	baseTokens, stats := estimateZRTokenXWithStats(text, zrConfigDefault)
+	params, coefficients := cfg.params()
+	baseTokens, stats, err := estimateZRTokenXWithStats(ctx, text, params, func(baseTokens int) bool {
+		return exceeds(int(math.Ceil(float64(baseTokens) * zrClampMin)))
+	})
+	if errors.Is(err, errZRStopped) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return exceeds(zrDetail(baseTokens, stats, params, coefficients).Tokens), nil
+}
+
+// zrDetail classifies text from its tokenx base count and stats and applies the category's
+// coefficients.
+func zrDetail(baseTokens int, stats zrStats, params zrConfig, coefficients map[zrCategory][]float64) ZRDetail {
 	if baseTokens == 0 {
-		return 0
+		// Only whitespace costs nothing; a short whitespace run is one token.
+		if stats.SpaceRunes > 0 {
+			tokens := (stats.SpaceRunes + zrBlankRunesPerToken - 1) / zrBlankRunesPerToken
+			return ZRDetail{Tokens: tokens, Category: zrCategoryGeneral.String()}
+		}
+		return ZRDetail{}
 	}
 
 	features := buildZRFeatures(baseTokens, stats)
-	category := classifyZR(stats, zrConfigDefault)
-	coeffs := zrCoefficientsByCategory[category]
+	category := classifyZR(stats, params)
+	coeffs := coefficients[category]
 	if len(coeffs) == 0 {
-		coeffs = zrCoefficientsByCategory[zrCategoryGeneral]
+		category = zrCategoryGeneral
+		coeffs = coefficients[zrCategoryGeneral]
 	}
 
-	pred := zrPredict(coeffs, features)
-	if pred < 0 {
-		return 0
+	detail := ZRDetail{
+		Category:     category.String(),
+		BaseTokens:   baseTokens,
+		Coefficients: coeffs,
 	}
-	return int(math.Ceil(pred))
+	detail.Tokens = int(math.Ceil(clampZRPrediction(zrPredict(coeffs, features), baseTokens)))
+	return detail
 }
 
 func buildZRFeatures(baseTokens int, stats zrStats) []float64 {
@@ -67,6 +149,19 @@ func buildZRFeatures(baseTokens int, stats zrStats) []float64 {
 	}
 }
 
+// clampZRPrediction bounds pred to [zrClampMin, zrClampMax] times the tokenx base count, so the
+// quadratic and interaction terms cannot extrapolate wildly outside the fitted regime.
+func clampZRPrediction(pred float64, baseTokens int) float64 {
+	base := float64(baseTokens)
+	if math.IsNaN(pred) || pred < base*zrClampMin {
+		return base * zrClampMin
+	}
+	if pred > base*zrClampMax {
+		return base * zrClampMax
+	}
+	return pred
+}
+
 func zrPredict(coeffs []float64, features []float64) float64 {
 	limit := len(features)
 	if len(coeffs) < limit {
@@ -108,22 +203,38 @@ func classifyZR(stats zrStats, cfg zrConfig) zrCategory {
 	return zrCategoryGeneral
 }
 
-func estimateZRTokenXWithStats(text string, cfg zrConfig) (int, zrStats) {
+// ctxCheckBytes is how much input estimateZRTokenXWithStats segments between checks of ctx.
+const ctxCheckBytes = 64 << 10
+
+// estimateZRTokenXWithStats returns the tokenx base count and stats of text. When stop is
+// non-nil, it is called with the running base count after each segment, and a true result
+// ends the scan with errZRStopped.
+func estimateZRTokenXWithStats(ctx context.Context, text string, cfg zrConfig, stop func(baseTokens int) bool) (int, zrStats, error) {
 	stats := zrStats{}
 	if text == "" {
-		return 0, stats
+		return 0, stats, nil
 	}
 
 	baseTokens := 0
 	segmentStart := 0
 	segmentType := zrSegmentTypeNone
 	first := true
+	nextCheck := ctxCheckBytes
 
 	for idx, r := range text {
+		if idx >= nextCheck {
+			if err := ctx.Err(); err != nil {
+				return 0, zrStats{}, err
+			}
+			nextCheck = idx + ctxCheckBytes
+		}
 		if unicode.IsSpace(r) {
 			stats.SpaceRunes++
 		}
 		currentType := zrSegmentTypeForRune(r)
+		if isInvalidByteAt(text, idx, r) {
+			currentType = zrSegmentTypeInvalid
+		}
 		if first {
 			first = false
 			segmentType = currentType
@@ -132,6 +243,9 @@ func estimateZRTokenXWithStats(text string, cfg zrConfig) (int, zrStats) {
 
 		if currentType != segmentType {
 			baseTokens += estimateZRTokenXSegment(text[segmentStart:idx], &stats, cfg)
+			if stop != nil && stop(baseTokens) {
+				return 0, zrStats{}, errZRStopped
+			}
 			segmentStart = idx
 			segmentType = currentType
 		}
@@ -141,7 +255,7 @@ func estimateZRTokenXWithStats(text string, cfg zrConfig) (int, zrStats) {
 		baseTokens += estimateZRTokenXSegment(text[segmentStart:], &stats, cfg)
 	}
 
-	return baseTokens, stats
+	return baseTokens, stats, nil
 }
 
 func estimateZRTokenXSegment(segment string, stats *zrStats, cfg zrConfig) int {
@@ -153,6 +267,12 @@ func estimateZRTokenXSegment(segment string, stats *zrStats, cfg zrConfig) int {
 		return 0
 	}
 
+	// Invalid UTF-8 costs one symbol unit per byte, as in the root package's Weighted.
+	if r, size := utf8.DecodeRuneInString(segment); r == utf8.RuneError && size == 1 {
+		stats.TotalRunes += len(segment)
+		return len(segment)
+	}
+
 	runeCount := utf8.RuneCountInString(segment)
 	stats.TotalRunes += runeCount
 
@@ -179,13 +299,18 @@ func estimateZRTokenXSegment(segment string, stats *zrStats, cfg zrConfig) int {
 	}
 
 	if isNumericSegment(segment) {
-		return 1
+		return numeric.Tokens(segment, cfg.digitsPerToken)
 	}
 
 	if runeCount <= cfg.shortThreshold {
 		return 1
 	}
 
+	// A run of one repeated character ("aaaa...", "=====") merges into long BPE tokens.
+	if isRepeatedRun(segment, runeCount) {
+		return int(math.Ceil(float64(runeCount) / zrRepeatedRunCharsPerToken))
+	}
+
 	if containsTokenXPunct(segment) {
 		if runeCount > 1 {
 			return int(math.Ceil(float64(runeCount) / 2.0))
@@ -224,6 +349,8 @@ const (
 	zrSegmentTypeWhitespace
 	zrSegmentTypePunctuation
 	zrSegmentTypeOther
+	// zrSegmentTypeInvalid groups bytes that are not valid UTF-8.
+	zrSegmentTypeInvalid
 )
 
 func zrSegmentTypeForRune(r rune) zrSegmentType {
@@ -236,6 +363,31 @@ func zrSegmentTypeForRune(r rune) zrSegmentType {
 	return zrSegmentTypeOther
 }
 
+// isInvalidByteAt reports whether r, decoded by ranging over text at byte offset idx, stands for
+// a single invalid byte rather than a literal U+FFFD.
+func isInvalidByteAt(text string, idx int, r rune) bool {
+	if r != utf8.RuneError {
+		return false
+	}
+	_, size := utf8.DecodeRuneInString(text[idx:])
+	return size == 1
+}
+
+// isRepeatedRun reports whether segment, of runeCount runes, is at least zrRepeatedRunMinRunes
+// repetitions of a single character.
+func isRepeatedRun(segment string, runeCount int) bool {
+	if runeCount < zrRepeatedRunMinRunes {
+		return false
+	}
+	first, size := utf8.DecodeRuneInString(segment)
+	for _, r := range segment[size:] {
+		if r != first {
+			return false
+		}
+	}
+	return true
+}
+
 func isTokenXWhitespace(segment string) bool {
 	for _, r := range segment {
 		if !unicode.IsSpace(r) {
@@ -276,38 +428,7 @@ func isCJKSegment(segment string) bool {
 }
 
 func isCJKRune(r rune) bool {
-	switch {
-	case r >= 0x4E00 && r <= 0x9FFF:
-		return true
-	case r >= 0x3400 && r <= 0x4DBF:
-		return true
-	case r >= 0x3000 && r <= 0x303F:
-		return true
-	case r >= 0xFF00 && r <= 0xFFEF:
-		return true
-	case r >= 0x30A0 && r <= 0x30FF:
-		return true
-	case r >= 0x2E80 && r <= 0x2EFF:
-		return true
-	case r >= 0x31C0 && r <= 0x31EF:
-		return true
-	case r >= 0x3200 && r <= 0x32FF:
-		return true
-	case r >= 0x3300 && r <= 0x33FF:
-		return true
-	case r >= 0xAC00 && r <= 0xD7AF:
-		return true
-	case r >= 0x1100 && r <= 0x11FF:
-		return true
-	case r >= 0x3130 && r <= 0x318F:
-		return true
-	case r >= 0xA960 && r <= 0xA97F:
-		return true
-	case r >= 0xD7B0 && r <= 0xD7FF:
-		return true
-	default:
-		return false
-	}
+	return cjk.IsRune(r)
 }
 
 func isNumericSegment(segment string) bool {
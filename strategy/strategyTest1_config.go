@@ -0,0 +1,135 @@
+package strategy
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+)
+
+// ZRConfig holds ZR thresholds and per-category coefficients loaded at runtime, typically from
+// the JSON written by the fit tool's -out-zr-config flag. A nil *ZRConfig means the built-in
+// defaults. ZRConfig values are immutable once loaded.
+type ZRConfig struct {
+	cfg          zrConfig
+	coefficients map[zrCategory][]float64
+	fingerprint  uint64
+}
+
+type zrThresholdsJSON struct {
+	CharsPerToken       float64 `json:"chars_per_token"`
+	ShortThreshold      int     `json:"short_threshold"`
+	CapitalThreshold    float64 `json:"capital_threshold"`
+	DenseThreshold      float64 `json:"dense_threshold"`
+	HexThreshold        float64 `json:"hex_threshold"`
+	AlnumPunctThreshold float64 `json:"alnum_punct_threshold"`
+}
+
+type zrCoefficientsJSON struct {
+	General []float64 `json:"general"`
+	Capital []float64 `json:"capital"`
+	Dense   []float64 `json:"dense"`
+	Hex     []float64 `json:"hex"`
+	Alnum   []float64 `json:"alnum"`
+}
+
+// zrConfigFileJSON mirrors the fit tool's output; its metadata section is ignored.
+type zrConfigFileJSON struct {
+	Thresholds   zrThresholdsJSON   `json:"thresholds"`
+	Coefficients zrCoefficientsJSON `json:"coefficients"`
+}
+
+// LoadZRConfig parses a ZR config in the fit tool's JSON format. Zero thresholds and missing
+// coefficient rows keep their built-in defaults.
+func LoadZRConfig(r io.Reader) (*ZRConfig, error) {
+	var doc zrConfigFileJSON
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decode ZR config: %w", err)
+	}
+
+	cfg := zrConfigDefault
+	t := doc.Thresholds
+	setPositive(&cfg.charsPerToken, t.CharsPerToken)
+	if t.ShortThreshold > 0 {
+		cfg.shortThreshold = t.ShortThreshold
+	}
+	setPositive(&cfg.capitalThreshold, t.CapitalThreshold)
+	setPositive(&cfg.denseThreshold, t.DenseThreshold)
+	setPositive(&cfg.hexThreshold, t.HexThreshold)
+	setPositive(&cfg.alnumPunctThreshold, t.AlnumPunctThreshold)
+
+	coefficients := make(map[zrCategory][]float64, len(zrCoefficientsByCategory))
+	for category, row := range zrCoefficientsByCategory {
+		coefficients[category] = row
+	}
+	rows := map[zrCategory][]float64{
+		zrCategoryGeneral: doc.Coefficients.General,
+		zrCategoryCapital: doc.Coefficients.Capital,
+		zrCategoryDense:   doc.Coefficients.Dense,
+		zrCategoryHex:     doc.Coefficients.Hex,
+		zrCategoryAlnum:   doc.Coefficients.Alnum,
+	}
+	for category, row := range rows {
+		if len(row) == 0 {
+			continue
+		}
+		for _, c := range row {
+			if math.IsNaN(c) || math.IsInf(c, 0) {
+				return nil, fmt.Errorf("ZR config: non-finite %s coefficient", category)
+			}
+		}
+		coefficients[category] = append([]float64(nil), row...)
+	}
+
+	out := &ZRConfig{cfg: cfg, coefficients: coefficients}
+	out.fingerprint = out.computeFingerprint()
+	return out, nil
+}
+
+func setPositive(dst *float64, v float64) {
+	if v > 0 {
+		*dst = v
+	}
+}
+
+// Fingerprint identifies the config's contents; equal configs have equal fingerprints.
+// It is intended for cache keys. A nil config returns 0.
+func (c *ZRConfig) Fingerprint() uint64 {
+	if c == nil {
+		return 0
+	}
+	return c.fingerprint
+}
+
+func (c *ZRConfig) computeFingerprint() uint64 {
+	h := fnv.New64a()
+	write := func(v float64) {
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+		_, _ = h.Write(buf[:])
+	}
+	write(c.cfg.charsPerToken)
+	write(float64(c.cfg.shortThreshold))
+	write(c.cfg.capitalThreshold)
+	write(c.cfg.denseThreshold)
+	write(c.cfg.hexThreshold)
+	write(c.cfg.alnumPunctThreshold)
+	write(float64(c.cfg.digitsPerToken))
+	for category := zrCategoryGeneral; category <= zrCategoryAlnum; category++ {
+		row := c.coefficients[category]
+		write(float64(len(row)))
+		for _, v := range row {
+			write(v)
+		}
+	}
+	return h.Sum64() | 1
+}
+
+func (c *ZRConfig) params() (zrConfig, map[zrCategory][]float64) {
+	if c == nil {
+		return zrConfigDefault, zrCoefficientsByCategory
+	}
+	return c.cfg, c.coefficients
+}
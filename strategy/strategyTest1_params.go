@@ -10,6 +10,40 @@ const (
 	zrCategoryAlnum
 )
 
+func (c zrCategory) String() string {
+	switch c {
+	case zrCategoryGeneral:
+		return "general"
+	case zrCategoryCapital:
+		return "capital"
+	case zrCategoryDense:
+		return "dense"
+	case zrCategoryHex:
+		return "hex"
+	case zrCategoryAlnum:
+		return "alnum"
+	default:
+		return "unknown"
+	}
+}
+
+// zrBlankRunesPerToken is the longest whitespace-only input costed as one token, as in the root
+// package: longer blank input costs one token per zrBlankRunesPerToken runes, rounded up.
+const zrBlankRunesPerToken = 16
+
+// zrRepeatedRunCharsPerToken is the density of a segment repeating one character, and
+// zrRepeatedRunMinRunes the shortest such segment, as in the root package's Weighted.
+const (
+	zrRepeatedRunCharsPerToken = 8.0
+	zrRepeatedRunMinRunes      = 16
+)
+
+// zrClampMin and zrClampMax bound the ZR prediction relative to the tokenx base count.
+const (
+	zrClampMin = 0.5
+	zrClampMax = 3.0
+)
+
 type zrConfig struct {
 	charsPerToken       float64
 	shortThreshold      int
@@ -17,6 +51,9 @@ type zrConfig struct {
 	denseThreshold      float64
 	hexThreshold        float64
 	alnumPunctThreshold float64
+	// digitsPerToken groups the digits of numeric segments, as in the root package's Weighted
+	// (see numeric.Tokens). Zero collapses a numeric segment to one token.
+	digitsPerToken int
 }
 
 var zrConfigDefault = zrConfig{
@@ -26,12 +63,16 @@ var zrConfigDefault = zrConfig{
 	denseThreshold:      0.01,
 	hexThreshold:        0.90,
 	alnumPunctThreshold: 0.03,
+	digitsPerToken:      3,
 }
 
+// zrCoefficientsByCategory was fitted by tools/fit with -no-grid -loss huber_rel -ridge-lambda
+// 0.0005 against the o200k_base counts in report/testAccuracy-20260107-113910Z.md; the fit and its
+// metrics are in report/zr-config-20261016.json.
 var zrCoefficientsByCategory = map[zrCategory][]float64{
-	zrCategoryGeneral: {0.9315, 0.6002, -1.1969, -0.6224, -0.4560, 1.7567, 3.1898, -4.6306},
-	zrCategoryCapital: {2.0163, 0, 0, 0, 0, 0, 0, 0},
-	zrCategoryDense:   {0.9315, 0.6002, -1.1969, -0.6224, -0.4560, 1.7567, 3.1898, -4.6306},
-	zrCategoryHex:     {0.9315, 0.6002, -1.1969, -0.6224, -0.4560, 1.7567, 3.1898, -4.6306},
-	zrCategoryAlnum:   {2.0163, 0, 0, 0, 0, 0, 0, 0},
+	zrCategoryGeneral: {0.9502, 0.3534, -1.4666, 0.2027, -0.2338, 1.9618, 1.1195, -0.1317},
+	zrCategoryCapital: {1.8870, 0, -2.0380, 0.8161, 0, -0.0598, 0.2201, 0},
+	zrCategoryDense:   {0.9502, 0.3534, -1.4666, 0.2027, -0.2338, 1.9618, 1.1195, -0.1317},
+	zrCategoryHex:     {0.9502, 0.3534, -1.4666, 0.2027, -0.2338, 1.9618, 1.1195, -0.1317},
+	zrCategoryAlnum:   {1.8870, 0, -2.0380, 0.8161, 0, -0.0598, 0.2201, 0},
 }
@@ -1,6 +1,8 @@
 package strategy
 
 import (
+	"context"
+	"errors"
 	"math"
 	"strings"
 	"testing"
@@ -12,6 +14,14 @@ func TestEstimateZRReturnsZeroOnEmpty(t *testing.T) {
 	}
 }
 
+func TestEstimateZRWhitespaceOnlyIsOneToken(t *testing.T) {
+	for _, text := range []string{"   ", "\n\n\n", "\t\t"} {
+		if got := EstimateZR(text); got != 1 {
+			t.Fatalf("expected 1 for %q, got %d", text, got)
+		}
+	}
+}
+
 func TestEstimateZRSimpleLatin(t *testing.T) {
 	if got := EstimateZR("hi"); got != 1 {
 		t.Fatalf("expected 1 token for 'hi', got %d", got)
@@ -19,10 +29,122 @@ func TestEstimateZRSimpleLatin(t *testing.T) {
 }
 
 func TestEstimateZRCapitalCategory(t *testing.T) {
-	text := strings.Repeat("A", 60)
+	text := strings.Repeat("QWERTYUIOP", 6)
 	baseTokens := int(math.Ceil(float64(len(text)) / zrConfigDefault.charsPerToken))
 	expected := int(math.Ceil(float64(baseTokens) * zrCoefficientsByCategory[zrCategoryCapital][0]))
 	if got := EstimateZR(text); got != expected {
 		t.Fatalf("expected %d tokens for capital input, got %d", expected, got)
 	}
 }
+
+func TestEstimateZRDetailMatchesEstimate(t *testing.T) {
+	text := strings.Repeat("QWERTYUIOP", 6)
+	detail := EstimateZRDetail(text)
+	if detail.Tokens != EstimateZR(text) {
+		t.Fatalf("expected detail tokens %d to match EstimateZR %d", detail.Tokens, EstimateZR(text))
+	}
+	if detail.Category != "capital" {
+		t.Fatalf("expected capital category, got %q", detail.Category)
+	}
+	if detail.BaseTokens != 20 || len(detail.Coefficients) == 0 {
+		t.Fatalf("unexpected detail %+v", detail)
+	}
+}
+
+func TestEstimateZRNumericSegmentGroupsDigits(t *testing.T) {
+	cfg := zrConfigDefault
+	cases := map[string]int{
+		"7":         1,
+		"2024":      2,
+		"3.14":      3,
+		"1,234,567": 5,
+	}
+	for input, want := range cases {
+		if got := estimateZRTokenXSegment(input, &zrStats{}, cfg); got != want {
+			t.Fatalf("%q: expected %d, got %d", input, want, got)
+		}
+	}
+
+	cfg.digitsPerToken = 0
+	if got := estimateZRTokenXSegment("1,234,567", &zrStats{}, cfg); got != 1 {
+		t.Fatalf("expected disabled grouping to collapse to 1, got %d", got)
+	}
+}
+
+func TestEstimateZRLongNumber(t *testing.T) {
+	number := strings.Repeat("1234567890", 4)
+	base, stats, _ := estimateZRTokenXWithStats(context.Background(), number, zrConfigDefault, nil)
+	if base != 14 {
+		t.Fatalf("expected 40-digit base of 14, got %d", base)
+	}
+	if stats.DigitRunes != 40 {
+		t.Fatalf("expected 40 digit runes, got %d", stats.DigitRunes)
+	}
+	if got := EstimateZR(number); got <= 1 {
+		t.Fatalf("expected long number to cost multiple tokens, got %d", got)
+	}
+}
+
+func TestEstimateZRStaysWithinBaseBounds(t *testing.T) {
+	inputs := []string{
+		strings.Repeat("你好世界", 50),
+		strings.Repeat("{}[]();,.!?", 20),
+	}
+	for _, text := range inputs {
+		detail := EstimateZRDetail(text)
+		lo := int(math.Ceil(float64(detail.BaseTokens) * zrClampMin))
+		hi := int(math.Ceil(float64(detail.BaseTokens) * zrClampMax))
+		if detail.BaseTokens == 0 || detail.Tokens < lo || detail.Tokens > hi {
+			t.Fatalf("%q: expected tokens in [%d, %d], got %+v", text[:12], lo, hi, detail)
+		}
+	}
+
+	if got := clampZRPrediction(-500, 100); got != 50 {
+		t.Fatalf("expected negative prediction to clamp to 50, got %v", got)
+	}
+	if got := clampZRPrediction(1e9, 100); got != 300 {
+		t.Fatalf("expected huge prediction to clamp to 300, got %v", got)
+	}
+	if got := clampZRPrediction(math.NaN(), 100); got != 50 {
+		t.Fatalf("expected NaN prediction to clamp to 50, got %v", got)
+	}
+}
+
+func TestEstimateZRDetailCtxStopsWhenCanceled(t *testing.T) {
+	text := strings.Repeat("hello world ", 20000)
+	ctx, cancel := context.WithCancel(context.Background())
+	detail, err := EstimateZRDetailWithConfigCtx(ctx, text, nil)
+	if want := EstimateZRDetail(text); err != nil || detail.Tokens != want.Tokens || detail.Category != want.Category {
+		t.Fatalf("expected a live context to match EstimateZRDetail, got %+v, %v", detail, err)
+	}
+
+	cancel()
+	if _, err := EstimateZRDetailWithConfigCtx(ctx, text, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestExceedsZRCtx(t *testing.T) {
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 100_000)
+	calls := 0
+	exceeded, err := ExceedsZRCtx(context.Background(), text, nil, func(tokens int) bool {
+		calls++
+		return tokens > 1000
+	})
+	if err != nil || !exceeded {
+		t.Fatalf("expected to exceed 1000 tokens, got %v, %v", exceeded, err)
+	}
+	// Scanning the whole text would call exceeds once per segment, ~2M times.
+	if calls > 10_000 {
+		t.Fatalf("called exceeds %d times; expected an early stop", calls)
+	}
+
+	short := "hello world"
+	want := EstimateZR(short)
+	for _, limit := range []int{want - 1, want} {
+		got, err := ExceedsZRCtx(context.Background(), short, nil, func(tokens int) bool { return tokens > limit })
+		if err != nil || got != (want > limit) {
+			t.Fatalf("limit %d: got %v, %v with estimate %d", limit, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package tokenest
+
+import "time"
+
+// BenchmarkStrategy times EstimateText with strategy on sampleText and returns the mean time
+// per call and the resulting throughput in bytes per second, for capacity planning and live
+// throughput metrics. Like the accuracy tool, it runs one untimed warm-up call and then a
+// fixed number of iterations chosen from the sample size (20000 for tiny samples down to 5
+// above 200KB). StrategyAuto resolves from the sample size. An empty sample returns 0, 0.
+//
+// Results measure the calling goroutine on the current machine and load; run it off the hot
+// path.
+func BenchmarkStrategy(strategy Strategy, sampleText string) (nsPerOp int64, bytesPerSec float64) {
+	if sampleText == "" {
+		return 0, 0
+	}
+
+	opts := Options{Strategy: strategy}
+	iterations := benchmarkIterations(len(sampleText))
+	_ = EstimateText(sampleText, opts)
+	start := time.Now()
+	for i := 0; i < iterations; i++ {
+		_ = EstimateText(sampleText, opts)
+	}
+	elapsed := time.Since(start)
+
+	nsPerOp = max(elapsed.Nanoseconds()/int64(iterations), 1)
+	return nsPerOp, float64(len(sampleText)) * float64(time.Second) / float64(nsPerOp)
+}
+
+// benchmarkIterations mirrors the accuracy tool's iteration counts by input size.
+func benchmarkIterations(size int) int {
+	switch {
+	case size < 200:
+		return 20000
+	case size < 2000:
+		return 2000
+	case size < 20000:
+		return 200
+	case size < 200000:
+		return 20
+	default:
+		return 5
+	}
+}
@@ -1,7 +1,9 @@
 package tokenest
 
 import (
+	"context"
 	"math"
+	"slices"
 
 	zrstrategy "github.com/EZ-Api/tokenest/strategy"
 )
@@ -10,8 +12,10 @@ import (
 type Strategy int
 
 const (
-	// StrategyAuto automatically selects the best strategy based on input type.
-	// Raw bytes -> UltraFast; extracted text -> Fast.
+	// StrategyAuto automatically selects the best strategy based on input type and size.
+	// Raw bytes -> UltraFast; extracted text under AutoWeightedMaxBytes -> Weighted (nearly
+	// free at that size and more accurate); larger text -> Fast. SetDefaults can replace this
+	// choice process-wide.
 	StrategyAuto Strategy = iota
 
 	// StrategyUltraFast uses len(bytes)/4 for O(1) estimation.
@@ -31,6 +35,9 @@ const (
 	StrategyZR
 )
 
+// AutoWeightedMaxBytes is the text size below which StrategyAuto uses Weighted instead of Fast.
+const AutoWeightedMaxBytes = 4096
+
 func (s Strategy) String() string {
 	switch s {
 	case StrategyAuto:
@@ -52,7 +59,8 @@ func (s Strategy) String() string {
 type Profile int
 
 const (
-	// ProfileAuto automatically resolves profile from model name or provider type.
+	// ProfileAuto automatically resolves profile from model name or provider type, falling back
+	// to the SetDefaults profile, if any, when neither is given.
 	ProfileAuto Profile = iota
 
 	// ProfileOpenAI uses OpenAI-tuned weights (default fallback).
@@ -63,6 +71,9 @@ const (
 
 	// ProfileGemini uses Gemini-tuned weights.
 	ProfileGemini
+
+	// ProfileQwen uses Qwen-tuned weights.
+	ProfileQwen
 )
 
 func (p Profile) String() string {
@@ -75,6 +86,8 @@ func (p Profile) String() string {
 		return "claude"
 	case ProfileGemini:
 		return "gemini"
+	case ProfileQwen:
+		return "qwen"
 	default:
 		return "unknown"
 	}
@@ -94,11 +107,109 @@ type Options struct {
 	// ProviderType is used for automatic profile resolution (e.g., "anthropic", "google").
 	ProviderType string
 
-	// GlobalMultiplier applies a final multiplier to the result. Default: 1.0.
+	// FastSampleFraction makes the Fast strategy sample this fraction of the input (e.g., 0.01),
+	// spread evenly across the document and capped at 64KB. Default: 0 (fixed ~768-byte sample).
+	FastSampleFraction float64
+
+	// FastSampleWindows makes the Fast strategy sample this many evenly spaced 256-byte windows
+	// (capped at 64KB in total) so ratios reflect heterogeneous documents. Values of 3 or less
+	// keep the default head/mid/tail sample. Ignored when FastSampleFraction is set.
+	FastSampleWindows int
+
+	// Overhead overrides message and image token costs in EstimateInput/EstimateMessages.
+	// Default: nil (package constants).
+	Overhead *OverheadConfig
+
+	// GlobalMultiplier applies a final multiplier to the result, rounding up (e.g., 1.1 for a
+	// 10% safety margin). Default: 1.0. Zero is the unset value and means 1.0, not "zero tokens";
+	// negative, NaN, and infinite values are also treated as 1.0 (Validate reports them as
+	// ErrInvalidMultiplier).
 	GlobalMultiplier float64
 
-	// Explain includes per-category breakdown in the result.
+	// CJKRanges, when non-nil, replaces the built-in CJK rune ranges for the Weighted strategy.
+	// It affects both segment classification (which runes form CJK segments and count toward
+	// the CJK ratio) and per-character costing (CJK segments cost one token per rune).
+	// Start from DefaultCJKRanges to drop individual blocks such as fullwidth forms or Hangul.
+	CJKRanges []RuneRange
+
+	// Language is an optional ISO 639-1 hint for the document language ("en", "de", "fr", "pl",
+	// or a code added with RegisterLanguage; region suffixes such as "de-AT" are ignored). When
+	// set, the Weighted strategy applies that language's chars-per-token to every alphanumeric
+	// segment instead of detecting it per word from accented characters. Empty or unrecognized
+	// codes keep the per-word detection.
+	Language string
+
+	// Normalize applies the Unicode normalization installed with RegisterNormalizer before
+	// estimation, so decomposed input such as "e" + U+0301 costs like its precomposed "é". The
+	// core package installs none, to stay dependency-free: import
+	// github.com/EZ-Api/tokenest/nfc to normalize with golang.org/x/text/unicode/norm NFC.
+	// Without a registered normalizer, Normalize does nothing. Weighted already folds combining
+	// marks into their base character's count without it, but only composed letters reach its
+	// language detection; Fast and ZR need it. UltraFast, which does not scan the input,
+	// ignores it. Default: false.
+	Normalize bool
+
+	// SpecialTokens maps exact strings, such as chat-template markers ("<|im_start|>") or a
+	// tokenizer's added tokens, to their token cost. Every occurrence in the text costs that many
+	// tokens and is removed before the strategy runs, so it is not segmented as punctuation and
+	// letters; the longest key wins where keys overlap. UltraFast, which does not scan the input,
+	// ignores it in every entry point, so EstimateText, EstimateBytes, and EstimateReader agree.
+	// Default: nil.
+	SpecialTokens map[string]int
+
+	// ShortTokenThreshold, when > 0, overrides the Weighted strategy's rune count at or below which
+	// a segment costs one token (default 3, or the ShortTokenThreshold of the profile's or
+	// CustomWeights' Weights). Longer alphanumeric segments cost ceil(runes/chars-per-token), so a
+	// threshold above the chars-per-token (6 by default) makes segments between the two cost one
+	// token instead of two; lower it for tokenizers that split short words more often. StrategyZR
+	// keeps its own threshold (see ZRConfig). Default: 0.
+	ShortTokenThreshold int
+
+	// CustomWeights, when non-nil, replaces the profile's weights in the Weighted strategy.
+	// It takes precedence over Profile, Model, and ProviderType.
+	CustomWeights *Weights
+
+	// ZRConfig, when non-nil, replaces StrategyZR's built-in thresholds and coefficients
+	// (see LoadZRConfig).
+	ZRConfig *ZRConfig
+
+	// Explain includes per-category breakdown in the result. For StrategyFast the breakdown holds
+	// the sampled CJK and punctuation shares ("sampled_cjk_ratio", "sampled_punct_ratio") as
+	// BaseUnits with zero Tokens, so Fast can double as a cheap content classifier.
 	Explain bool
+
+	// ExcludeThinking drops Anthropic "thinking" blocks in EstimateAnthropicContent.
+	ExcludeThinking bool
+
+	// IncludeJSONKeys makes EstimateJSON count object keys as well as string values.
+	IncludeJSONKeys bool
+
+	// Observer, when non-nil, is called with the final Result of each estimate, such as to
+	// count strategy use or record estimated sizes in Prometheus or statsd. Composite calls
+	// (EstimateInput, EstimateMessages, EstimateReader, ...) report once with their total, not
+	// once per internal estimate; batch calls (EstimateTextBatch, EstimateAll) report every
+	// Result. Cached results are reported too. It runs synchronously on the estimating
+	// goroutine, which may differ between calls, so it must be safe for concurrent use and
+	// should be fast. Nil costs nothing. Default: nil.
+	Observer func(Result)
+
+	// Strict refuses estimates whose options cannot be honored exactly instead of falling back:
+	// options that fail Validate (an unknown Strategy or Profile, an ignored setting, ...), a
+	// StrategyAuto left for the input size to resolve (unless set with SetDefaults), and a
+	// Weighted estimate whose profile falls back to OpenAI because no Profile, Model,
+	// ProviderType, or CustomWeights is set. EstimateTextCtx, EstimateReader, and EstimateJSON
+	// return an error wrapping ErrStrict; EstimateText and EstimateBytes, which cannot, estimate
+	// as usual and add the error to Result.Warnings. Meant for tests and integration checks.
+	// Default: false.
+	Strict bool
+}
+
+// notify passes res to observer, if any, and returns res.
+func notify(observer func(Result), res Result) Result {
+	if observer != nil {
+		observer(res)
+	}
+	return res
 }
 
 // ImageCounts tracks images by detail level for accurate estimation.
@@ -115,25 +226,40 @@ func (c ImageCounts) Total() int {
 
 // CategoryBreakdown provides per-category token details when Explain is enabled.
 type CategoryBreakdown struct {
-	Category  string
-	BaseUnits float64
-	Weight    float64
-	Tokens    float64
+	Category  string  `json:"category"`
+	BaseUnits float64 `json:"base_units"`
+	Weight    float64 `json:"weight"`
+	Tokens    float64 `json:"tokens"`
 }
 
 // Result contains the estimation result and metadata.
 type Result struct {
 	// Tokens is the estimated token count.
-	Tokens int
+	Tokens int `json:"tokens"`
 
 	// Strategy is the strategy that was used.
-	Strategy Strategy
+	Strategy Strategy `json:"strategy"`
 
 	// Profile is the profile that was used (for weighted estimation).
-	Profile Profile
+	Profile Profile `json:"profile"`
 
 	// Breakdown provides per-category details when Explain is enabled.
-	Breakdown []CategoryBreakdown
+	Breakdown []CategoryBreakdown `json:"breakdown,omitempty"`
+
+	// Confidence (0-1) reflects the expected accuracy of the strategy on the detected content
+	// type; MinTokens/MaxTokens bound the expected true count. Zero when not computed.
+	Confidence float64 `json:"confidence"`
+	MinTokens  int     `json:"min_tokens"`
+	MaxTokens  int     `json:"max_tokens"`
+
+	// Warnings flags estimates that may be unreliable (e.g., a non-representative Fast sample).
+	// Only populated when Explain is enabled.
+	Warnings []string `json:"warnings,omitempty"`
+
+	// ZRCategory is the input classification chosen by StrategyZR (e.g., "general", "hex").
+	// Empty for other strategies. With Explain, Breakdown holds one entry for that category
+	// whose BaseUnits is the token count before the ZR polynomial adjustment.
+	ZRCategory string `json:"zr_category,omitempty"`
 }
 
 // Overhead constants for message formatting.
@@ -154,107 +280,638 @@ const (
 	ImageTokensDefault = 500
 )
 
+// Claude has no detail levels and bills about (width*height)/750 tokens, capped near 1600
+// once the image is downscaled. Gemini bills 258 tokens per 768px tile, and 258 for images
+// within 384x384. Qwen bills one token per 28x28 patch plus 2, up to 1282. Unknown-size
+// defaults assume a 1024x768 image; low and high assume a small (384x384) and a maximal image.
+const (
+	claudeImageTokensLow     = 200
+	claudeImageTokensHigh    = 1600
+	claudeImageTokensDefault = 1050
+
+	geminiImageTokensLow     = 258
+	geminiImageTokensHigh    = 1032
+	geminiImageTokensDefault = 516
+
+	qwenImageTokensLow     = 198
+	qwenImageTokensHigh    = qwenImageMaxPatches + qwenImageMarkerTokens
+	qwenImageTokensDefault = 1001
+)
+
+// OverheadConfig overrides the message-formatting and image token costs for a call.
+// All fields are used as-is, so start from DefaultOverhead to change only some of them.
+type OverheadConfig struct {
+	Base         int
+	PerMessage   int
+	ImageLow     int
+	ImageHigh    int
+	ImageDefault int
+
+	// Output is added once by EstimateOutput. DefaultOverhead leaves it at 0; without
+	// Options.Overhead the profile's value applies instead (see OutputOverhead).
+	Output int
+}
+
+// DefaultOverhead returns the package default overhead costs.
+func DefaultOverhead() OverheadConfig {
+	return OverheadConfig{
+		Base:         BaseOverhead,
+		PerMessage:   PerMessageOverhead,
+		ImageLow:     ImageTokensLow,
+		ImageHigh:    ImageTokensHigh,
+		ImageDefault: ImageTokensDefault,
+	}
+}
+
+// InputOverhead returns the message and image costs EstimateInput, EstimateMessages, and
+// EstimateInputWithImages add to the text: *opts.Overhead when Overhead is set, otherwise the
+// package defaults with the resolved profile's image costs. The defaults are OpenAI's; Claude,
+// Gemini, and Qwen price images differently (see ImageTokens).
+func InputOverhead(opts Options) OverheadConfig {
+	if opts.Overhead != nil {
+		return *opts.Overhead
+	}
+	return profileOverhead(resolveProfile(opts))
+}
+
+func profileOverhead(profile Profile) OverheadConfig {
+	overhead := DefaultOverhead()
+	switch profile {
+	case ProfileClaude:
+		overhead.ImageLow = claudeImageTokensLow
+		overhead.ImageHigh = claudeImageTokensHigh
+		overhead.ImageDefault = claudeImageTokensDefault
+	case ProfileGemini:
+		overhead.ImageLow = geminiImageTokensLow
+		overhead.ImageHigh = geminiImageTokensHigh
+		overhead.ImageDefault = geminiImageTokensDefault
+	case ProfileQwen:
+		overhead.ImageLow = qwenImageTokensLow
+		overhead.ImageHigh = qwenImageTokensHigh
+		overhead.ImageDefault = qwenImageTokensDefault
+	}
+	return overhead
+}
+
+// blankRunesPerToken is the longest whitespace-only input costed as a single token. Tokenizers
+// merge short whitespace runs ("   ", "\n\n") into one token, but their vocabularies hold no
+// token for arbitrarily long runs.
+const blankRunesPerToken = 16
+
+// blankInputTokens is the estimate, in every strategy, for input made only of whitespace that is
+// runes runes long: one token per blankRunesPerToken runes, rounded up.
+func blankInputTokens(runes int) int {
+	if runes <= 0 {
+		return 0
+	}
+	return (runes + blankRunesPerToken - 1) / blankRunesPerToken
+}
+
+// EstimateByteLen returns the UltraFast estimate for a body of n bytes, e.g. from a
+// Content-Length header when the body itself is not at hand. It equals
+// EstimateBytes(data, Options{Strategy: StrategyUltraFast}).Tokens for any len(data) == n
+// unless data is only whitespace (one token per 16 runes), and is 0 for n <= 0. No multiplier
+// is applied.
+func EstimateByteLen(n int) int {
+	if n <= 0 {
+		return 0
+	}
+	return (n + 3) / 4
+}
+
 // EstimateBytes estimates tokens from raw bytes (e.g., JSON request body).
 // With StrategyAuto, this uses UltraFast estimation.
 func EstimateBytes(data []byte, opts Options) Result {
-	strategy := opts.Strategy
-	if strategy == StrategyAuto {
-		strategy = StrategyUltraFast
+	result, _ := estimateBytesCtx(context.Background(), data, opts)
+	return result
+}
+
+// estimateBytesCtx implements EstimateBytes, stopping Weighted and ZR with ctx.Err() once ctx
+// is done (see EstimateTextCtx). Strict violations are only added to Result.Warnings.
+func estimateBytesCtx(ctx context.Context, data []byte, opts Options) (Result, error) {
+	strategy := effectiveBytesStrategy(opts.Strategy)
+	strictErr := opts.strictError(strategy)
+	if opts.Normalize && strategy != StrategyUltraFast {
+		data = []byte(normalizeText(string(data)))
+	}
+	var specialTokens, specialMatches int
+	if special := specialTokensFor(opts, strategy); len(special) > 0 {
+		var rest string
+		rest, specialTokens, specialMatches = extractSpecialTokens(string(data), special)
+		data = []byte(rest)
 	}
 
 	var tokens int
 	var breakdown []CategoryBreakdown
+	var warnings []string
+	var zrCategory string
+	var err error
 	switch strategy {
 	case StrategyUltraFast:
 		tokens = estimateUltraFast(data)
 	case StrategyFast:
-		tokens = estimateFast(string(data))
+		tokens = estimateFast(string(data), opts.FastSampleFraction, opts.FastSampleWindows, opts.Explain, &breakdown)
+		if opts.Explain {
+			warnings = fastSampleWarnings(fastSamplePlan(string(data), opts.FastSampleFraction, opts.FastSampleWindows))
+		}
 	case StrategyWeighted:
-		profile := resolveProfile(opts)
 		if opts.Explain {
 			breakdown = make([]CategoryBreakdown, 0)
 		}
-		tokens = estimateWeighted(string(data), profile, opts.Explain, &breakdown)
+		tokens, err = estimateWeighted(ctx, string(data), weightsForOptions(opts), segmentationForOptions(opts), opts.Explain, &breakdown)
 	case StrategyZR:
-		tokens = zrstrategy.EstimateZR(string(data))
+		tokens, zrCategory, err = estimateZR(ctx, string(data), opts.ZRConfig, opts.Explain, &breakdown)
 	default:
 		tokens = estimateUltraFast(data)
 	}
+	if err != nil {
+		return Result{}, err
+	}
+	tokens += specialTokens
+	if opts.Explain {
+		breakdown = appendSpecialTokensBreakdown(breakdown, specialTokens, specialMatches)
+	}
 
 	tokens = applyMultiplier(tokens, opts.GlobalMultiplier)
+	if strictErr != nil {
+		warnings = append(warnings, strictErr.Error())
+	}
 
-	return Result{
-		Tokens:    tokens,
-		Strategy:  strategy,
-		Profile:   resolveProfile(opts),
-		Breakdown: breakdown,
+	result := Result{
+		Tokens:     tokens,
+		Strategy:   strategy,
+		Profile:    resolveProfile(opts),
+		Breakdown:  breakdown,
+		Warnings:   warnings,
+		ZRCategory: zrCategory,
 	}
+	result.setConfidence(strategy, quickContent(data))
+	return notify(opts.Observer, result), nil
 }
 
 // EstimateText estimates tokens from extracted text content.
-// With StrategyAuto, this uses Fast estimation.
+// With StrategyAuto, this uses Weighted for text under AutoWeightedMaxBytes and Fast otherwise.
+//
+// Text need not be valid UTF-8: Fast, Weighted, and ZR cost each invalid byte as one symbol unit
+// (one token), the byte-level fallback of BPE tokenizers. UltraFast only counts bytes.
 func EstimateText(text string, opts Options) Result {
-	strategy := opts.Strategy
-	if strategy == StrategyAuto {
-		strategy = StrategyFast
+	result, _ := estimateTextCtx(context.Background(), text, opts, false)
+	return result
+}
+
+// EstimateTextCtx is EstimateText with cancellation for request-scoped deadlines on large
+// inputs. It returns ctx.Err() if ctx is already done, and Weighted and ZR check ctx every
+// 64KB while segmenting, so a canceled call stops within about 64KB of further segmentation.
+// On error the Result is zero. UltraFast and Fast are bounded by their sample and run to
+// completion, as do the Normalize and SpecialTokens passes. With Options.Strict, options that
+// cannot be honored exactly return an error wrapping ErrStrict.
+func EstimateTextCtx(ctx context.Context, text string, opts Options) (Result, error) {
+	return estimateTextCtx(ctx, text, opts, true)
+}
+
+// estimateTextCtx implements EstimateTextCtx. A Strict violation is returned as an error when
+// failStrict is set and added to Result.Warnings otherwise.
+func estimateTextCtx(ctx context.Context, text string, opts Options, failStrict bool) (Result, error) {
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+	strategy := effectiveTextStrategy(opts.Strategy, len(text))
+	strictErr := opts.strictError(strategy)
+	if strictErr != nil && failStrict {
+		return Result{}, strictErr
 	}
+	if opts.Normalize && strategy != StrategyUltraFast {
+		text = normalizeText(text)
+	}
+	text, specialTokens, specialMatches := extractSpecialTokens(text, specialTokensFor(opts, strategy))
 
 	var tokens int
 	var breakdown []CategoryBreakdown
+	var warnings []string
+	var zrCategory string
+	var err error
 
 	switch strategy {
 	case StrategyUltraFast:
-		tokens = estimateUltraFast([]byte(text))
+		tokens = estimateUltraFastText(text)
 	case StrategyFast:
-		tokens = estimateFast(text)
+		tokens = estimateFast(text, opts.FastSampleFraction, opts.FastSampleWindows, opts.Explain, &breakdown)
+		if opts.Explain {
+			warnings = fastSampleWarnings(fastSamplePlan(text, opts.FastSampleFraction, opts.FastSampleWindows))
+		}
 	case StrategyWeighted:
-		profile := resolveProfile(opts)
 		if opts.Explain {
 			breakdown = make([]CategoryBreakdown, 0)
 		}
-		tokens = estimateWeighted(text, profile, opts.Explain, &breakdown)
+		tokens, err = estimateWeighted(ctx, text, weightsForOptions(opts), segmentationForOptions(opts), opts.Explain, &breakdown)
 	case StrategyZR:
-		tokens = zrstrategy.EstimateZR(text)
+		tokens, zrCategory, err = estimateZR(ctx, text, opts.ZRConfig, opts.Explain, &breakdown)
 	default:
-		tokens = estimateFast(text)
+		tokens = estimateFast(text, opts.FastSampleFraction, opts.FastSampleWindows, false, nil)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	tokens += specialTokens
+	if opts.Explain {
+		breakdown = appendSpecialTokensBreakdown(breakdown, specialTokens, specialMatches)
 	}
 
 	tokens = applyMultiplier(tokens, opts.GlobalMultiplier)
+	if strictErr != nil {
+		warnings = append(warnings, strictErr.Error())
+	}
 
-	return Result{
-		Tokens:    tokens,
-		Strategy:  strategy,
-		Profile:   resolveProfile(opts),
-		Breakdown: breakdown,
+	result := Result{
+		Tokens:     tokens,
+		Strategy:   strategy,
+		Profile:    resolveProfile(opts),
+		Breakdown:  breakdown,
+		Warnings:   warnings,
+		ZRCategory: zrCategory,
 	}
+	result.setConfidence(strategy, quickContent(text))
+	return notify(opts.Observer, result), nil
 }
 
-// EstimateInput estimates input tokens including text, images, and message overhead.
+// EstimateForModel estimates text tokens for model using the best available strategy:
+//   - o200k_base models (gpt-4o, gpt-4.1, gpt-5, o1/o3/o4, ...) use ZR, which is fitted on
+//     o200k_base.
+//   - All other models (legacy OpenAI, Claude, Gemini, Qwen, unknown) use Weighted with the
+//     profile resolved from the model name.
+func EstimateForModel(text string, model string) Result {
+	opts := Options{Model: model, Strategy: StrategyWeighted}
+	opts.Profile = resolveProfile(opts)
+	if opts.Profile == ProfileOpenAI && EncodingForModel(model) == EncodingO200K {
+		opts.Strategy = StrategyZR
+	}
+	return EstimateText(text, opts)
+}
+
+// EstimateInput estimates input tokens including text, images, and message overhead (see
+// InputOverhead). Image costs follow the resolved profile.
 func EstimateInput(text string, images ImageCounts, messageCount int, opts Options) Result {
+	result, _ := estimateInputCtx(context.Background(), text, images, messageCount, opts)
+	return result
+}
+
+// estimateInputCtx implements EstimateInput with the cancellation of EstimateTextCtx.
+func estimateInputCtx(ctx context.Context, text string, images ImageCounts, messageCount int, opts Options) (Result, error) {
 	multiplier := opts.GlobalMultiplier
 	opts.GlobalMultiplier = 1.0
-	result := EstimateText(text, opts)
+	observer := opts.Observer
+	opts.Observer = nil
+	result, err := estimateTextCtx(ctx, text, opts, false)
+	if err != nil {
+		return Result{}, err
+	}
 
-	imageTokens := images.LowDetail*ImageTokensLow +
-		images.HighDetail*ImageTokensHigh +
-		images.Unknown*ImageTokensDefault
+	overhead := InputOverhead(opts)
+	textTokens := result.Tokens
+	imgTokens := imageTokens(images, overhead)
+	overheadTokens := messageOverhead(messageCount, overhead)
+	result.Tokens = applyMultiplier(textTokens+imgTokens+overheadTokens, multiplier)
+	if opts.Explain {
+		result.Breakdown = appendInputBreakdown(result.Breakdown, textTokens, images.Total(), imgTokens, overheadTokens, result.Tokens)
+	}
+	result.setRange()
 
-	overhead := BaseOverhead + messageCount*PerMessageOverhead
+	return notify(observer, result), nil
+}
 
-	result.Tokens += imageTokens + overhead
-	result.Tokens = applyMultiplier(result.Tokens, multiplier)
+// Message is a role-tagged chat message for EstimateMessages.
+type Message struct {
+	// Role is informational; role tokens are covered by PerMessageOverhead.
+	Role    string
+	Content string
+	Images  ImageCounts
+}
 
-	return result
+// EstimateMessages estimates input tokens for a chat-format request. Each message's content is
+// estimated with the chosen strategy; the base overhead is added once, the per-message overhead
+// per message, and image tokens per message (see Options.Overhead). With Explain, breakdown
+// categories are summed across messages. Each message's warnings are kept, once per distinct
+// warning, so a Strict violation shared by every message is reported once.
+func EstimateMessages(messages []Message, opts Options) Result {
+	multiplier := opts.GlobalMultiplier
+	opts.GlobalMultiplier = 1.0
+	observer := opts.Observer
+	opts.Observer = nil
+
+	// Resolve Auto from the total content size so every message uses the same strategy.
+	size := 0
+	for _, msg := range messages {
+		size += len(msg.Content)
+	}
+	opts.Strategy = effectiveTextStrategy(opts.Strategy, size)
+
+	result := Result{
+		Strategy: opts.Strategy,
+		Profile:  resolveProfile(opts),
+	}
+	var images ImageCounts
+	textTokens := 0
+	for i, msg := range messages {
+		res := EstimateText(msg.Content, opts)
+		if i == 0 || res.Confidence < result.Confidence {
+			result.Confidence = res.Confidence
+		}
+		textTokens += res.Tokens
+		result.Breakdown = mergeBreakdown(result.Breakdown, res.Breakdown)
+		for _, warning := range res.Warnings {
+			if !slices.Contains(result.Warnings, warning) {
+				result.Warnings = append(result.Warnings, warning)
+			}
+		}
+
+		images.LowDetail += msg.Images.LowDetail
+		images.HighDetail += msg.Images.HighDetail
+		images.Unknown += msg.Images.Unknown
+	}
+
+	overhead := InputOverhead(opts)
+	imgTokens := imageTokens(images, overhead)
+	overheadTokens := messageOverhead(len(messages), overhead)
+	result.Tokens = applyMultiplier(textTokens+imgTokens+overheadTokens, multiplier)
+	if opts.Explain {
+		result.Breakdown = appendInputBreakdown(result.Breakdown, textTokens, images.Total(), imgTokens, overheadTokens, result.Tokens)
+	}
+	result.setRange()
+
+	return notify(observer, result)
+}
+
+func imageTokens(images ImageCounts, overhead OverheadConfig) int {
+	return images.LowDetail*overhead.ImageLow +
+		images.HighDetail*overhead.ImageHigh +
+		images.Unknown*overhead.ImageDefault
+}
+
+func messageOverhead(messageCount int, overhead OverheadConfig) int {
+	return overhead.Base + messageCount*overhead.PerMessage
+}
+
+// Breakdown categories added by EstimateInput and EstimateMessages.
+const (
+	inputCategoryText       = "text"
+	inputCategoryImage      = "image"
+	inputCategoryOverhead   = "overhead"
+	inputCategoryAdjustment = "adjustment"
+)
+
+// appendInputBreakdown extends a text breakdown with image and message-overhead entries so the
+// categories sum to total. Strategies without a text breakdown get a single "text" entry;
+// rounding and GlobalMultiplier land in a final "adjustment" entry.
+func appendInputBreakdown(breakdown []CategoryBreakdown, textTokens, imageCount, imgTokens, overheadTokens, total int) []CategoryBreakdown {
+	if len(breakdown) == 0 && textTokens > 0 {
+		breakdown = append(breakdown, CategoryBreakdown{
+			Category:  inputCategoryText,
+			BaseUnits: float64(textTokens),
+			Weight:    1,
+			Tokens:    float64(textTokens),
+		})
+	}
+	if imageCount > 0 {
+		tokens := float64(imgTokens)
+		breakdown = append(breakdown, CategoryBreakdown{
+			Category:  inputCategoryImage,
+			BaseUnits: float64(imageCount),
+			Weight:    tokens / float64(imageCount),
+			Tokens:    tokens,
+		})
+	}
+	if overheadTokens != 0 {
+		tokens := float64(overheadTokens)
+		breakdown = append(breakdown, CategoryBreakdown{
+			Category:  inputCategoryOverhead,
+			BaseUnits: tokens,
+			Weight:    1,
+			Tokens:    tokens,
+		})
+	}
+
+	sum := 0.0
+	for _, item := range breakdown {
+		sum += item.Tokens
+	}
+	if delta := float64(total) - sum; math.Abs(delta) > 1e-9 {
+		breakdown = append(breakdown, CategoryBreakdown{
+			Category:  inputCategoryAdjustment,
+			BaseUnits: delta,
+			Weight:    1,
+			Tokens:    delta,
+		})
+	}
+	return breakdown
+}
+
+// mergeBreakdown adds src into dst by category, preserving first-seen order.
+func mergeBreakdown(dst, src []CategoryBreakdown) []CategoryBreakdown {
+	for _, item := range src {
+		merged := false
+		for i := range dst {
+			if dst[i].Category == item.Category {
+				dst[i].BaseUnits += item.BaseUnits
+				dst[i].Tokens += item.Tokens
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			dst = append(dst, item)
+		}
+	}
+	return dst
 }
 
-// EstimateOutput estimates output tokens from response text.
+// EstimateOutput estimates the billed output tokens of response text: the text estimate plus
+// OutputOverhead(opts), the end-of-turn token a provider counts as generated output. Unlike
+// EstimateInput it adds no base or per-message overhead, since role and framing tokens of the
+// assistant turn are part of the prompt. With Explain, the overhead is a separate breakdown entry.
 func EstimateOutput(text string, opts Options) Result {
-	return EstimateText(text, opts)
+	result, _ := estimateOutputCtx(context.Background(), text, opts)
+	return result
+}
+
+// estimateOutputCtx implements EstimateOutput with the cancellation of EstimateTextCtx.
+func estimateOutputCtx(ctx context.Context, text string, opts Options) (Result, error) {
+	multiplier := opts.GlobalMultiplier
+	opts.GlobalMultiplier = 1.0
+	observer := opts.Observer
+	opts.Observer = nil
+	result, err := estimateTextCtx(ctx, text, opts, false)
+	if err != nil {
+		return Result{}, err
+	}
+
+	textTokens := result.Tokens
+	overheadTokens := OutputOverhead(opts)
+	result.Tokens = applyMultiplier(textTokens+overheadTokens, multiplier)
+	if opts.Explain {
+		result.Breakdown = appendInputBreakdown(result.Breakdown, textTokens, 0, 0, overheadTokens, result.Tokens)
+	}
+	result.setRange()
+	return notify(observer, result), nil
+}
+
+// OutputOverhead returns the tokens EstimateOutput adds to the response text: opts.Overhead.Output
+// when Overhead is set, otherwise the resolved profile's value. Qwen-family models are usually
+// served by engines that count the generated <|im_end|> as a completion token (1). OpenAI,
+// Claude, and Gemini usage excludes the stop token, so their value is 0.
+func OutputOverhead(opts Options) int {
+	if opts.Overhead != nil {
+		return opts.Overhead.Output
+	}
+	switch resolveProfile(opts) {
+	case ProfileQwen:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// estimateZR runs the ZR strategy and returns its tokens and category. With explain, a single
+// breakdown entry records the pre-adjustment base count and the effective ratio applied to it.
+func estimateZR(ctx context.Context, text string, cfg *ZRConfig, explain bool, breakdown *[]CategoryBreakdown) (int, string, error) {
+	detail, err := zrstrategy.EstimateZRDetailWithConfigCtx(ctx, text, cfg)
+	if err != nil {
+		return 0, "", err
+	}
+	if explain && breakdown != nil && detail.BaseTokens > 0 {
+		*breakdown = append(*breakdown, CategoryBreakdown{
+			Category:  detail.Category,
+			BaseUnits: float64(detail.BaseTokens),
+			Weight:    float64(detail.Tokens) / float64(detail.BaseTokens),
+			Tokens:    float64(detail.Tokens),
+		})
+	}
+	return detail.Tokens, detail.Category, nil
 }
 
+// applyMultiplier scales tokens by multiplier, rounding up. Multipliers that are unset (0),
+// negative, NaN, or infinite leave tokens unchanged; results beyond the int range saturate.
 func applyMultiplier(tokens int, multiplier float64) int {
-	if multiplier <= 0 || multiplier == 1.0 {
+	if !(multiplier > 0) || multiplier == 1.0 || math.IsInf(multiplier, 0) {
 		return tokens
 	}
-	return int(math.Ceil(float64(tokens) * multiplier))
+	return saturatingInt(math.Ceil(float64(tokens) * multiplier))
+}
+
+// saturatingInt converts an integral float token count to int, clamping to [0, math.MaxInt] and
+// mapping NaN to 0. Plain int conversion of out-of-range floats is implementation-defined.
+func saturatingInt(x float64) int {
+	switch {
+	case !(x > 0):
+		return 0
+	case x >= math.MaxInt:
+		return math.MaxInt
+	default:
+		return int(x)
+	}
+}
+
+// EstimateSuffixDelta estimates the additional tokens contributed by appending suffix to existing.
+// Only the trailing segment of existing is re-estimated together with suffix, so the cost is
+// proportional to len(suffix) rather than len(existing). The result is never negative.
+func EstimateSuffixDelta(existing string, suffix string, opts Options) int {
+	opts.Observer = nil
+	if suffix == "" {
+		return 0
+	}
+
+	// Resolve Auto from the full text so both sides of the delta use the same strategy.
+	opts.Strategy = effectiveTextStrategy(opts.Strategy, len(existing)+len(suffix))
+	tail := existing[lastTokenXSegmentStart(existing, segmentationForOptions(opts).classTable()):]
+	delta := EstimateText(tail+suffix, opts).Tokens - EstimateText(tail, opts).Tokens
+	if delta < 0 {
+		return 0
+	}
+	return delta
+}
+
+// EstimateBatchWithSharedPrefix estimates a batch of requests that all start with the same prefix
+// (e.g., a shared system prompt). The prefix is estimated once and each body adds its suffix delta.
+// Each Result.Tokens is the per-request total including the shared prefix cost. StrategyAuto
+// resolves once from the prefix size.
+func EstimateBatchWithSharedPrefix(prefix string, bodies []string, opts Options) []Result {
+	opts.Strategy = effectiveTextStrategy(opts.Strategy, len(prefix))
+	observer := opts.Observer
+	opts.Observer = nil
+	base := EstimateText(prefix, opts)
+	base.Breakdown = nil
+
+	results := make([]Result, len(bodies))
+	for i, body := range bodies {
+		res := base
+		res.Tokens += EstimateSuffixDelta(prefix, body, opts)
+		res.setRange()
+		results[i] = notify(observer, res)
+	}
+	return results
+}
+
+// EstimateAll runs every concrete strategy (UltraFast, Fast, Weighted, ZR) on text and returns
+// each Result keyed by strategy. The profile is resolved once from opts and shared by all runs;
+// opts.Strategy is ignored. Use it to compare strategies on live inputs, e.g. to pick the
+// cheapest one that stays within a target error.
+func EstimateAll(text string, opts Options) map[Strategy]Result {
+	opts.Profile = resolveProfile(opts)
+	strategies := [...]Strategy{StrategyUltraFast, StrategyFast, StrategyWeighted, StrategyZR}
+	results := make(map[Strategy]Result, len(strategies))
+	for _, strategy := range strategies {
+		opts.Strategy = strategy
+		results[strategy] = EstimateText(text, opts)
+	}
+	return results
+}
+
+// EstimateMulti returns Weighted estimates of text for several profiles at once.
+// Segmentation is shared between profiles with the same segmentation parameters, so the O(n)
+// scan runs once for most profile sets. Each entry is EstimateText(text, opts) with Strategy
+// Weighted and Profile set to the entry: ProfileAuto entries resolve via opts, and Observer is
+// notified once per entry. opts.CustomWeights is ignored since each entry names its profile
+// explicitly.
+func EstimateMulti(text string, profiles []Profile, opts Options) map[Profile]int {
+	type segmented struct {
+		baseTokens int
+		stats      tokenXStats
+	}
+
+	opts.Strategy = StrategyWeighted
+	opts.CustomWeights = nil
+	if opts.Normalize {
+		text = normalizeText(text)
+	}
+	text, specialTokens, _ := extractSpecialTokens(text, opts.SpecialTokens)
+	content := quickContent(text)
+
+	results := make(map[Profile]int, len(profiles))
+	// Every pass shares opts.CJKRanges, so the costs alone tell passes apart.
+	passes := make(map[tokenXCosts]segmented, 1)
+	for _, profile := range profiles {
+		single := opts
+		single.Profile = profile
+		tuning := weightsForOptions(single)
+		params := segmentationForOptions(single)
+		pass, ok := passes[params.tokenXCosts]
+		if !ok {
+			pass.baseTokens, pass.stats = estimateTokenXWithStats(text, params)
+			passes[params.tokenXCosts] = pass
+		}
+
+		stats := pass.stats
+		tuning.addContextStats(text, &stats)
+		tokens := applyWeightedTuning(pass.baseTokens, stats, tuning, false, nil) + specialTokens
+		result := Result{
+			Tokens:   applyMultiplier(tokens, opts.GlobalMultiplier),
+			Strategy: StrategyWeighted,
+			Profile:  resolveProfile(single),
+		}
+		if err := single.strictError(StrategyWeighted); err != nil {
+			result.Warnings = append(result.Warnings, err.Error())
+		}
+		result.setConfidence(StrategyWeighted, content)
+		results[profile] = notify(opts.Observer, result).Tokens
+	}
+	return results
 }
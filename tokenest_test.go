@@ -1,8 +1,22 @@
 package tokenest
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"unicode/utf8"
+
+	zrstrategy "github.com/EZ-Api/tokenest/strategy"
 )
 
 func TestEstimateUltraFast(t *testing.T) {
@@ -13,6 +27,15 @@ func TestEstimateUltraFast(t *testing.T) {
 	}
 }
 
+func TestEstimateByteLenMatchesUltraFast(t *testing.T) {
+	for _, n := range []int{-1, 0, 1, 3, 4, 5, 1000, 4097} {
+		want := EstimateBytes(make([]byte, max(n, 0)), Options{Strategy: StrategyUltraFast}).Tokens
+		if got := EstimateByteLen(n); got != want {
+			t.Fatalf("n=%d: expected %d tokens, got %d", n, want, got)
+		}
+	}
+}
+
 func TestEstimateFastEnglish(t *testing.T) {
 	text := "hello world"
 	res := EstimateText(text, Options{Strategy: StrategyFast})
@@ -47,12 +70,29 @@ func TestResolveProfileProviderType(t *testing.T) {
 }
 
 func TestResolveProfileFallbackOpenAI(t *testing.T) {
-	res := EstimateText("hi", Options{Strategy: StrategyWeighted, Model: "qwen-2.5"})
+	res := EstimateText("hi", Options{Strategy: StrategyWeighted, Model: "llama-3.1-70b"})
 	if res.Profile != ProfileOpenAI {
 		t.Fatalf("expected ProfileOpenAI fallback, got %v", res.Profile)
 	}
 }
 
+func TestResolveProfileQwen(t *testing.T) {
+	cases := []Options{
+		{Model: "qwen-2.5"},
+		{Model: "QwQ-32B"},
+		{ProviderType: "dashscope"},
+		{ProviderType: "alibaba", Model: "some-model"},
+	}
+	for _, opts := range cases {
+		if got := resolveProfile(opts); got != ProfileQwen {
+			t.Fatalf("%+v: expected ProfileQwen, got %v", opts, got)
+		}
+	}
+	if ProfileQwen.String() != "qwen" {
+		t.Fatalf("expected qwen string, got %q", ProfileQwen.String())
+	}
+}
+
 func TestWeightedExplainBreakdown(t *testing.T) {
 	res := EstimateText("123", Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI, Explain: true})
 	if res.Tokens == 0 {
@@ -65,7 +105,7 @@ func TestWeightedExplainBreakdown(t *testing.T) {
 			if item.BaseUnits != 1 {
 				t.Fatalf("expected base units 1, got %v", item.BaseUnits)
 			}
-			if item.Weight != tuningForProfile(ProfileOpenAI).baseFactor {
+			if item.Weight != weightsForProfile(ProfileOpenAI).BaseFactor {
 				t.Fatalf("unexpected base weight %v", item.Weight)
 			}
 		}
@@ -140,6 +180,74 @@ func TestWithCacheProfileKeying(t *testing.T) {
 	}
 }
 
+func TestWithCacheOptionsEvictsByBytes(t *testing.T) {
+	inner := &countEstimator{}
+	size := defaultCacheMinTextBytes + 64
+	cached := WithCacheOptions(inner, CacheConfig{MaxBytes: 2 * size})
+	opts := Options{Strategy: StrategyFast}
+	a := strings.Repeat("a", size)
+	b := strings.Repeat("b", size)
+	c := strings.Repeat("c", size)
+
+	cached.EstimateText(a, opts)
+	cached.EstimateText(b, opts)
+	cached.EstimateText(a, opts) // hit; a becomes most recent
+	cached.EstimateText(c, opts) // evicts b, the least recently used
+	if inner.calls != 3 {
+		t.Fatalf("expected 3 inner calls, got %d", inner.calls)
+	}
+
+	cached.EstimateText(a, opts)
+	cached.EstimateText(c, opts)
+	if inner.calls != 3 {
+		t.Fatalf("expected a and c to stay cached, got %d inner calls", inner.calls)
+	}
+	cached.EstimateText(b, opts)
+	if inner.calls != 4 {
+		t.Fatalf("expected b to be evicted, got %d inner calls", inner.calls)
+	}
+
+	huge := strings.Repeat("d", 3*size)
+	cached.EstimateText(huge, opts)
+	cached.EstimateText(huge, opts)
+	if inner.calls != 6 {
+		t.Fatalf("expected input over MaxBytes to bypass the cache, got %d inner calls", inner.calls)
+	}
+}
+
+func TestLRUOversizedAddEvictsStaleEntry(t *testing.T) {
+	cache := newBoundedLRU(0, 100)
+	cache.Add(1, Result{Tokens: 1}, 10)
+	cache.Add(1, Result{Tokens: 2}, 200)
+	if res, ok := cache.Get(1); ok {
+		t.Fatalf("expected the older entry to be evicted, got %+v", res)
+	}
+	if stats := cache.Stats(); stats.Entries != 0 || stats.Bytes != 0 || stats.Evictions != 1 {
+		t.Fatalf("expected an empty cache after one eviction, got %+v", stats)
+	}
+}
+
+func TestWithCacheStats(t *testing.T) {
+	cached := WithCache(DefaultEstimator(), 1)
+	provider, ok := cached.(CacheStatsProvider)
+	if !ok {
+		t.Fatal("expected cached estimator to implement CacheStatsProvider")
+	}
+
+	opts := Options{Strategy: StrategyFast}
+	a := strings.Repeat("a", defaultCacheMinTextBytes)
+	b := strings.Repeat("b", defaultCacheMinTextBytes)
+	cached.EstimateText(a, opts)
+	cached.EstimateText(a, opts)
+	cached.EstimateText(b, opts)
+	cached.EstimateText("short", opts)
+
+	want := CacheStats{Hits: 1, Misses: 2, Evictions: 1, Entries: 1, Bytes: len(b)}
+	if got := provider.Stats(); got != want {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+}
+
 func TestAutoStrategyDefaults(t *testing.T) {
 	bytesRes := EstimateBytes([]byte("hello"), Options{Strategy: StrategyAuto})
 	if bytesRes.Strategy != StrategyUltraFast {
@@ -147,8 +255,18 @@ func TestAutoStrategyDefaults(t *testing.T) {
 	}
 
 	textRes := EstimateText("hello", Options{Strategy: StrategyAuto})
-	if textRes.Strategy != StrategyFast {
-		t.Fatalf("expected StrategyFast, got %v", textRes.Strategy)
+	if textRes.Strategy != StrategyWeighted {
+		t.Fatalf("expected StrategyWeighted for short text, got %v", textRes.Strategy)
+	}
+
+	long := strings.Repeat("hello ", AutoWeightedMaxBytes/6+1)
+	if got := EstimateText(long, Options{Strategy: StrategyAuto}).Strategy; got != StrategyFast {
+		t.Fatalf("expected StrategyFast for %d bytes, got %v", len(long), got)
+	}
+
+	messages := []Message{{Content: "hi"}, {Content: long}}
+	if got := EstimateMessages(messages, Options{}).Strategy; got != StrategyFast {
+		t.Fatalf("expected messages to resolve Auto from total size, got %v", got)
 	}
 }
 
@@ -164,3 +282,2531 @@ func TestStrategyZRSelection(t *testing.T) {
 		t.Fatalf("expected non-zero tokens, got %d", res.Tokens)
 	}
 }
+
+func TestStrategyZRReportsCategory(t *testing.T) {
+	text := strings.Repeat("0123456789abcdef", 8)
+	res := EstimateText(text, Options{Strategy: StrategyZR, Explain: true})
+	if res.ZRCategory != "hex" {
+		t.Fatalf("expected hex category, got %q", res.ZRCategory)
+	}
+	if len(res.Breakdown) != 1 || res.Breakdown[0].Category != "hex" {
+		t.Fatalf("expected single hex breakdown entry, got %+v", res.Breakdown)
+	}
+	if int(res.Breakdown[0].Tokens) != res.Tokens || res.Breakdown[0].BaseUnits <= 0 {
+		t.Fatalf("unexpected breakdown %+v for %d tokens", res.Breakdown[0], res.Tokens)
+	}
+
+	if got := EstimateText(text, Options{Strategy: StrategyWeighted}).ZRCategory; got != "" {
+		t.Fatalf("expected empty ZRCategory for Weighted, got %q", got)
+	}
+}
+
+func TestEstimateForModel(t *testing.T) {
+	text := "Hello, world! 你好"
+	cases := []struct {
+		model    string
+		strategy Strategy
+		profile  Profile
+	}{
+		{"gpt-4o", StrategyZR, ProfileOpenAI},
+		{"gpt-3.5-turbo", StrategyWeighted, ProfileOpenAI},
+		{"claude-3-5-sonnet", StrategyWeighted, ProfileClaude},
+		{"gemini-1.5-pro", StrategyWeighted, ProfileGemini},
+		{"llama-3.1-70b", StrategyWeighted, ProfileOpenAI},
+	}
+	for _, tc := range cases {
+		res := EstimateForModel(text, tc.model)
+		if res.Strategy != tc.strategy || res.Profile != tc.profile {
+			t.Fatalf("%s: expected %v/%v, got %v/%v", tc.model, tc.strategy, tc.profile, res.Strategy, res.Profile)
+		}
+		want := EstimateText(text, Options{Strategy: tc.strategy, Model: tc.model})
+		if res.Tokens != want.Tokens {
+			t.Fatalf("%s: expected %d tokens, got %d", tc.model, want.Tokens, res.Tokens)
+		}
+	}
+}
+
+func TestEstimateAll(t *testing.T) {
+	text := "func main() { fmt.Println(\"hello 世界\") }"
+	opts := Options{Model: "claude-3-5-sonnet", GlobalMultiplier: 1.25}
+	results := EstimateAll(text, opts)
+	if len(results) != 4 {
+		t.Fatalf("expected 4 strategies, got %d", len(results))
+	}
+	for _, strategy := range []Strategy{StrategyUltraFast, StrategyFast, StrategyWeighted, StrategyZR} {
+		res, ok := results[strategy]
+		if !ok {
+			t.Fatalf("missing %v result", strategy)
+		}
+		single := opts
+		single.Strategy = strategy
+		want := EstimateText(text, single)
+		if res.Strategy != strategy || res.Profile != ProfileClaude || res.Tokens != want.Tokens {
+			t.Fatalf("%v: expected %d tokens with claude profile, got %+v", strategy, want.Tokens, res)
+		}
+	}
+}
+
+func TestEstimateSuffixDeltaBoundary(t *testing.T) {
+	opts := Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI}
+	if got := EstimateSuffixDelta("hello world", "", opts); got != 0 {
+		t.Fatalf("expected 0 for empty suffix, got %d", got)
+	}
+
+	existing := strings.Repeat("lorem ipsum ", 200) + "abc"
+	got := EstimateSuffixDelta(existing, "def ghi", opts)
+	want := EstimateText("abcdef ghi", opts).Tokens - EstimateText("abc", opts).Tokens
+	if got != want {
+		t.Fatalf("expected delta %d, got %d", want, got)
+	}
+}
+
+func TestWeightedRepeatedCJKDiscount(t *testing.T) {
+	var stats tokenXStats
+	if got := estimateTokenXSegment("你好世界", defaultTokenXParams, &stats); got != 4 {
+		t.Fatalf("expected varied CJK to cost 4, got %d", got)
+	}
+	if got := estimateTokenXSegment("哈哈", defaultTokenXParams, &stats); got != 2 {
+		t.Fatalf("expected short repeat to cost 2, got %d", got)
+	}
+	if got := estimateTokenXSegment("哈哈哈哈哈哈", defaultTokenXParams, &stats); got != 3 {
+		t.Fatalf("expected repeated laughter to cost 3, got %d", got)
+	}
+	// Full stops are punctuation, so "。。。。" is its own segment at ceil(4/2).
+	if got, _ := estimateTokenXWithStats("好。。。。", defaultTokenXParams); got != 3 {
+		t.Fatalf("expected repeated full stops to be discounted, got %d", got)
+	}
+}
+
+func TestEstimateBatchWithSharedPrefix(t *testing.T) {
+	opts := Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI}
+	prefix := strings.Repeat("You are a helpful assistant. ", 40)
+	bodies := []string{"", "What is the capital of France?", "你好"}
+
+	results := EstimateBatchWithSharedPrefix(prefix, bodies, opts)
+	if len(results) != len(bodies) {
+		t.Fatalf("expected %d results, got %d", len(bodies), len(results))
+	}
+
+	prefixTokens := EstimateText(prefix, opts).Tokens
+	for i, body := range bodies {
+		want := prefixTokens + EstimateSuffixDelta(prefix, body, opts)
+		if results[i].Tokens != want {
+			t.Fatalf("body %d: expected %d tokens, got %d", i, want, results[i].Tokens)
+		}
+		if results[i].Strategy != StrategyWeighted {
+			t.Fatalf("body %d: expected StrategyWeighted, got %v", i, results[i].Strategy)
+		}
+	}
+}
+
+func TestExplainRangesAlignWithSegmenter(t *testing.T) {
+	text := "Hello, 世界 12345 https://example.com"
+	ranges := ExplainRanges(text, Options{})
+	if len(ranges) == 0 {
+		t.Fatalf("expected ranges")
+	}
+
+	pos := 0
+	total := 0
+	for _, r := range ranges {
+		if r.Start != pos || r.End <= r.Start {
+			t.Fatalf("expected contiguous range starting at %d, got %+v", pos, r)
+		}
+		pos = r.End
+		total += r.Tokens
+	}
+	if pos != len(text) {
+		t.Fatalf("expected ranges to cover %d bytes, got %d", len(text), pos)
+	}
+
+	base, _ := estimateTokenXWithStats(text, defaultTokenXParams)
+	if total != base {
+		t.Fatalf("expected range tokens to sum to %d, got %d", base, total)
+	}
+	if ranges[0].Category != SegmentCategoryAlnum || text[ranges[0].Start:ranges[0].End] != "Hello" {
+		t.Fatalf("unexpected first range %+v", ranges[0])
+	}
+}
+
+func TestLanguageConfigIgnoresSingleDiacritic(t *testing.T) {
+	if got := getLanguageSpecificCharsPerToken("cafeteriaé"); got != 0 {
+		t.Fatalf("expected loanword diacritic to be ignored, got %v", got)
+	}
+	if got := getLanguageSpecificCharsPerToken("été"); got != 3 {
+		t.Fatalf("expected French config for dense diacritics, got %v", got)
+	}
+}
+
+func TestLanguageConfigMatchesOrdinaryGermanWords(t *testing.T) {
+	for _, word := range []string{"schön", "Mädchen", "Fußball", "übersetzen", "Größe"} {
+		if got := getLanguageSpecificCharsPerToken(word); got != 3 {
+			t.Fatalf("expected German config for %q, got %v", word, got)
+		}
+	}
+	// French keeps the default ratio, so one accent in a long word still does not match.
+	if got := getLanguageSpecificCharsPerToken("cafeteriaé"); got != 0 {
+		t.Fatalf("expected the default ratio for French, got %v", got)
+	}
+}
+
+func TestWeightedSegmentationParamsPerProfile(t *testing.T) {
+	for _, profile := range []Profile{ProfileOpenAI, ProfileClaude, ProfileGemini} {
+		got := weightsForProfile(profile).segmentation()
+		if got.shortTokenThreshold != defaultTokenXParams.shortTokenThreshold || got.charsPerToken != defaultTokenXParams.charsPerToken {
+			t.Fatalf("%v: expected default segmentation params, got %+v", profile, got)
+		}
+	}
+
+	var stats tokenXStats
+	coarse := tokenXParams{tokenXCosts: tokenXCosts{shortTokenThreshold: 5, charsPerToken: 12}}
+	if got := estimateTokenXSegment("hello", coarse, &stats); got != 1 {
+		t.Fatalf("expected raised short threshold to yield 1, got %d", got)
+	}
+	if got := estimateTokenXSegment("abcdefghijklmnopqrstuvwx", coarse, &stats); got != 2 {
+		t.Fatalf("expected 24 runes at 12 chars/token to yield 2, got %d", got)
+	}
+}
+
+func TestEstimateAnthropicContentRoutesBlocks(t *testing.T) {
+	var blocks []ContentBlock
+	raw := `[
+		{"type": "thinking", "thinking": "Let me work out the weather lookup first."},
+		{"type": "text", "text": "Checking the weather now."},
+		{"type": "tool_use", "name": "get_weather", "input": {"city": "Paris"}}
+	]`
+	if err := json.Unmarshal([]byte(raw), &blocks); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := Options{Strategy: StrategyWeighted, Profile: ProfileClaude, Explain: true}
+	thinking := EstimateText(blocks[0].Thinking, opts).Tokens
+	text := EstimateText(blocks[1].Text, opts).Tokens
+	tool := EstimateText("get_weather", opts).Tokens + EstimateText(`{"city": "Paris"}`, opts).Tokens
+
+	res := EstimateAnthropicContent(blocks, opts)
+	if res.Tokens != thinking+text+tool {
+		t.Fatalf("expected %d tokens, got %d", thinking+text+tool, res.Tokens)
+	}
+	if len(res.Breakdown) != 3 || res.Breakdown[0].Category != ContentBlockThinking {
+		t.Fatalf("unexpected breakdown %+v", res.Breakdown)
+	}
+	// Confidence is the lowest of the parts', as in EstimateMessages, and sets the range.
+	lowest := EstimateText(`{"city": "Paris"}`, opts).Confidence
+	for _, part := range []string{blocks[0].Thinking, blocks[1].Text, "get_weather"} {
+		lowest = math.Min(lowest, EstimateText(part, opts).Confidence)
+	}
+	if res.Confidence != lowest || res.Confidence <= 0 {
+		t.Fatalf("expected confidence %v, got %v", lowest, res.Confidence)
+	}
+	if res.MinTokens >= res.Tokens || res.MaxTokens <= res.Tokens {
+		t.Fatalf("expected a range around %d, got [%d, %d]", res.Tokens, res.MinTokens, res.MaxTokens)
+	}
+
+	opts.ExcludeThinking = true
+	if got := EstimateAnthropicContent(blocks, opts).Tokens; got != text+tool {
+		t.Fatalf("expected thinking to be excluded (%d), got %d", text+tool, got)
+	}
+}
+
+func TestFastSampleFraction(t *testing.T) {
+	text := strings.Repeat("a", 100*1024) + strings.Repeat("你", 30*1024)
+	fixed := EstimateText(text, Options{Strategy: StrategyFast})
+	if again := EstimateText(text, Options{Strategy: StrategyFast, FastSampleFraction: 0}); again.Tokens != fixed.Tokens {
+		t.Fatalf("expected zero fraction to keep fixed sampling, got %d vs %d", again.Tokens, fixed.Tokens)
+	}
+
+	sample := strings.Join(fastFractionWindows(text, 0.1), "")
+	if len(sample) < 16*1024 || len(sample) > fastSampleMaxBytes {
+		t.Fatalf("unexpected sample size %d", len(sample))
+	}
+	if !strings.Contains(sample, "a") || !strings.Contains(sample, "你") {
+		t.Fatalf("expected sample to span the whole document")
+	}
+
+	short := "hello world"
+	if got := EstimateText(short, Options{Strategy: StrategyFast, FastSampleFraction: 0.01}).Tokens; got != 3 {
+		t.Fatalf("expected short text to be sampled fully, got %d", got)
+	}
+}
+
+func TestFastAndWeightedAgreeOnIndentedText(t *testing.T) {
+	inputs := []string{
+		strings.Repeat("                if err != nil { return err }\n", 200),
+		strings.Repeat("\t\t\t\t\t\tfoo()\n", 300),
+	}
+	for _, text := range inputs {
+		fast := float64(EstimateText(text, Options{Strategy: StrategyFast}).Tokens)
+		weighted := float64(EstimateText(text, Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI}).Tokens)
+		if ratio := fast / weighted; ratio < 0.75 || ratio > 1.25 {
+			t.Fatalf("%q: expected Fast within 25%% of Weighted, got %.0f vs %.0f", text[:20], fast, weighted)
+		}
+	}
+}
+
+func TestFastSampleMidWindowInsideCJKRun(t *testing.T) {
+	// The midpoint and both edges of the middle window fall inside 3-byte runes.
+	text := strings.Repeat("a", 1000) + strings.Repeat("中", 2000) + strings.Repeat("b", 1001)
+	head, mid, tail := fastSampleWindows(text)
+	if mid == "" || !utf8.ValidString(mid) || !strings.Contains(mid, "中") {
+		t.Fatalf("expected a valid, non-empty CJK middle window, got %q", mid)
+	}
+	if len(mid) < fastMidSize || len(mid) > fastMidSize+4 {
+		t.Fatalf("expected middle window close to %d bytes, got %d", fastMidSize, len(mid))
+	}
+	total := len(head) + len(mid) + len(tail)
+	want := fastHeadSize + fastMidSize + fastTailSize
+	if total < want || total > want+4 {
+		t.Fatalf("expected sample close to %d bytes, got %d", want, total)
+	}
+}
+
+func TestFastSampleWindows(t *testing.T) {
+	// CJK fills 20%-40% of the document, which head/mid/tail windows all miss.
+	text := strings.Repeat("a", 20*1024) + strings.Repeat("你", 20*1024/3) + strings.Repeat("a", 60*1024)
+	fixed := EstimateText(text, Options{Strategy: StrategyFast})
+	if same := EstimateText(text, Options{Strategy: StrategyFast, FastSampleWindows: 3}); same.Tokens != fixed.Tokens {
+		t.Fatalf("expected 3 windows to keep the default sample, got %d vs %d", same.Tokens, fixed.Tokens)
+	}
+
+	sample := strings.Join(fastEvenWindows(text, 20), "")
+	// Windows widen to whole runes, adding at most a few bytes each.
+	if len(sample) > 20*(fastSampleWindow+4) || len(sample) < 20*fastSampleWindow {
+		t.Fatalf("expected about %d sample bytes, got %d", 20*fastSampleWindow, len(sample))
+	}
+	if strings.Contains(sampleFastText(text), "你") || !strings.Contains(sample, "你") {
+		t.Fatalf("expected only the many-window sample to see the CJK region")
+	}
+
+	more := EstimateText(text, Options{Strategy: StrategyFast, FastSampleWindows: 20})
+	if more.Tokens == fixed.Tokens {
+		t.Fatalf("expected more windows to change the estimate, got %d for both", more.Tokens)
+	}
+}
+
+func TestFastSampleWarningsUseTheSamplePlan(t *testing.T) {
+	// CJK fills 20%-40% of the document: head/mid/tail all miss it, 20 windows do not.
+	text := strings.Repeat("a", 20*1024) + strings.Repeat("你", 20*1024/3) + strings.Repeat("a", 60*1024)
+	hasCJKWarning := func(res Result) bool {
+		for _, w := range res.Warnings {
+			if strings.Contains(w, "CJK ratio") {
+				return true
+			}
+		}
+		return false
+	}
+	if res := EstimateText(text, Options{Strategy: StrategyFast, Explain: true}); hasCJKWarning(res) {
+		t.Fatalf("expected no divergence across head/mid/tail, got %v", res.Warnings)
+	}
+	for _, opts := range []Options{
+		{Strategy: StrategyFast, Explain: true, FastSampleWindows: 20},
+		{Strategy: StrategyFast, Explain: true, FastSampleFraction: 0.2},
+	} {
+		if res := EstimateText(text, opts); !hasCJKWarning(res) {
+			t.Fatalf("windows=%d fraction=%v: expected the sampled windows to diverge, got %v", opts.FastSampleWindows, opts.FastSampleFraction, res.Warnings)
+		}
+	}
+	if got := fastSampleWarnings(fastSamplePlan("short", 0, 20)); got != nil {
+		t.Fatalf("expected a whole-text sample to be representative, got %v", got)
+	}
+}
+
+func TestFastPunctRatioCountsExcessSpaceInRunes(t *testing.T) {
+	// Per repeat: 5 runes ("x, y.", 2 punctuation) plus four 3-byte U+3000 runes, 3 of them
+	// excess. Over 10 repeats: 170 bytes, 90 runes, 30 excess runes (90 bytes), so the
+	// punctuation ratio is 20/60 and the 80 remaining bytes cost 80/(4-1/3) = 21.8 tokens.
+	text := strings.Repeat("x, y."+strings.Repeat("\u3000", 4), 10)
+	if got := estimateFast(text, 0, 0, false, nil); got != 22 {
+		t.Fatalf("expected 22 tokens, got %d", got)
+	}
+}
+
+func TestEstimateMultiMatchesPerProfile(t *testing.T) {
+	text := "Hello 你好 123, world!"
+	profiles := []Profile{ProfileOpenAI, ProfileClaude, ProfileGemini, ProfileAuto}
+	opts := Options{Model: "claude-3-opus", GlobalMultiplier: 1.1}
+
+	got := EstimateMulti(text, profiles, opts)
+	if len(got) != len(profiles) {
+		t.Fatalf("expected %d results, got %d", len(profiles), len(got))
+	}
+	for _, profile := range profiles {
+		single := opts
+		single.Strategy = StrategyWeighted
+		single.Profile = profile
+		want := EstimateText(text, single).Tokens
+		if got[profile] != want {
+			t.Fatalf("%v: expected %d, got %d", profile, want, got[profile])
+		}
+	}
+}
+
+func TestEstimateMultiAppliesTextOptions(t *testing.T) {
+	useTestNormalizer(t)
+	text := "<|im_start|>Cafe\u0301 déjà vu, naïve 你好<|im_end|>"
+	profiles := []Profile{ProfileOpenAI, ProfileClaude, ProfileAuto}
+	var observed []Result
+	opts := Options{
+		SpecialTokens: map[string]int{"<|im_start|>": 1, "<|im_end|>": 1},
+		Normalize:     true,
+		Strict:        true,
+		Observer:      func(res Result) { observed = append(observed, res) },
+	}
+
+	got := EstimateMulti(text, profiles, opts)
+	if len(observed) != len(profiles) {
+		t.Fatalf("expected one observation per profile, got %d", len(observed))
+	}
+	for i, profile := range profiles {
+		single := opts
+		single.Strategy = StrategyWeighted
+		single.Profile = profile
+		single.Observer = nil
+		want := EstimateText(text, single)
+		if got[profile] != want.Tokens {
+			t.Fatalf("%v: expected %d, got %d", profile, want.Tokens, got[profile])
+		}
+		res := observed[i]
+		if res.Tokens != want.Tokens || res.Profile != want.Profile || res.Confidence != want.Confidence ||
+			len(res.Warnings) != len(want.Warnings) {
+			t.Fatalf("%v: expected observer to see %+v, got %+v", profile, want, res)
+		}
+	}
+	// Strict flags the auto entry, which has no Model to resolve it.
+	if len(observed[2].Warnings) == 0 {
+		t.Fatalf("expected a Strict warning for ProfileAuto, got %+v", observed[2])
+	}
+}
+
+func TestSegmentsSumToWeightedBase(t *testing.T) {
+	text := "func main() { fmt.Println(\"你好\", 42) }\n"
+	segments := Segments(text)
+
+	total := 0
+	end := 0
+	for _, seg := range segments {
+		if seg.Start != end {
+			t.Fatalf("expected contiguous segments, got %+v at %d", seg, end)
+		}
+		if seg.Runes != len([]rune(text[seg.Start:seg.End])) {
+			t.Fatalf("unexpected rune count in %+v", seg)
+		}
+		end = seg.End
+		total += seg.Tokens
+	}
+	if end != len(text) {
+		t.Fatalf("expected segments to cover input")
+	}
+
+	base, _ := estimateTokenXWithStats(text, weightsForProfile(ProfileOpenAI).segmentation())
+	if total != base {
+		t.Fatalf("expected segment tokens to sum to %d, got %d", base, total)
+	}
+}
+
+func TestCustomWeightsOverrideProfile(t *testing.T) {
+	text := "Hello 你好 123"
+	custom := WeightsForProfile(ProfileOpenAI)
+	custom.BaseFactor = 1.5
+	custom.ClampMax = 2
+
+	opts := Options{Strategy: StrategyWeighted, Profile: ProfileClaude, CustomWeights: &custom, Explain: true}
+	res := EstimateText(text, opts)
+	def := EstimateText(text, Options{Strategy: StrategyWeighted, Profile: ProfileClaude})
+	if res.Tokens <= def.Tokens {
+		t.Fatalf("expected custom weights to raise estimate above %d, got %d", def.Tokens, res.Tokens)
+	}
+
+	found := false
+	for _, item := range res.Breakdown {
+		if item.Category == weightedCategoryBase {
+			found = item.Weight == custom.BaseFactor
+		}
+	}
+	if !found {
+		t.Fatalf("expected breakdown to use custom base weight, got %+v", res.Breakdown)
+	}
+}
+
+func TestWithCacheCustomWeightsKeying(t *testing.T) {
+	inner := &countEstimator{}
+	cached := WithCache(inner, 4)
+	text := strings.Repeat("a", defaultCacheMinTextBytes+64)
+
+	a := WeightsForProfile(ProfileOpenAI)
+	b := a
+	b.BaseFactor = 1.1
+	cached.EstimateText(text, Options{Strategy: StrategyWeighted, CustomWeights: &a})
+	cached.EstimateText(text, Options{Strategy: StrategyWeighted, CustomWeights: &b})
+
+	if inner.calls != 2 {
+		t.Fatalf("expected 2 inner calls for different custom weights, got %d", inner.calls)
+	}
+}
+
+func TestEstimateMessagesMatchesOverheadMath(t *testing.T) {
+	messages := []Message{
+		{Role: "system", Content: "You are helpful."},
+		{Role: "user", Content: "Describe this image.", Images: ImageCounts{LowDetail: 1}},
+		{Role: "assistant", Content: "It shows a cat."},
+	}
+	opts := Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI}
+
+	want := BaseOverhead + len(messages)*PerMessageOverhead + ImageTokensLow
+	for _, msg := range messages {
+		want += EstimateText(msg.Content, opts).Tokens
+	}
+
+	res := EstimateMessages(messages, opts)
+	if res.Tokens != want {
+		t.Fatalf("expected %d tokens, got %d", want, res.Tokens)
+	}
+	if res.Strategy != StrategyWeighted || res.Profile != ProfileOpenAI {
+		t.Fatalf("unexpected strategy/profile %v/%v", res.Strategy, res.Profile)
+	}
+
+	opts.GlobalMultiplier = 2
+	if got := EstimateMessages(messages, opts).Tokens; got != want*2 {
+		t.Fatalf("expected multiplier applied once (%d), got %d", want*2, got)
+	}
+}
+
+func TestEstimateMessagesKeepsWarnings(t *testing.T) {
+	heterogeneous := strings.Repeat("hello world ", 200) + strings.Repeat("你好世界", 200)
+	messages := []Message{
+		{Role: "system", Content: "You are helpful."},
+		{Role: "user", Content: heterogeneous},
+	}
+	opts := Options{Strategy: StrategyFast, Explain: true, Strict: true, Language: "xx"}
+
+	var want []string
+	for _, msg := range messages {
+		for _, warning := range EstimateText(msg.Content, opts).Warnings {
+			if !slices.Contains(want, warning) {
+				want = append(want, warning)
+			}
+		}
+	}
+	if len(want) < 2 {
+		t.Fatalf("expected a Strict and a sample warning, got %v", want)
+	}
+	if got := EstimateMessages(messages, opts).Warnings; !slices.Equal(got, want) {
+		t.Fatalf("expected warnings %v, got %v", want, got)
+	}
+}
+
+func TestFastWarnsOnHeterogeneousSample(t *testing.T) {
+	text := strings.Repeat("hello world ", 200) + strings.Repeat("你好世界", 200)
+	res := EstimateText(text, Options{Strategy: StrategyFast, Explain: true})
+	if len(res.Warnings) == 0 {
+		t.Fatalf("expected warning for diverging sample windows")
+	}
+	if quiet := EstimateText(text, Options{Strategy: StrategyFast}); quiet.Warnings != nil {
+		t.Fatalf("expected no warnings without Explain, got %v", quiet.Warnings)
+	}
+
+	uniform := strings.Repeat("hello world ", 400)
+	if res := EstimateText(uniform, Options{Strategy: StrategyFast, Explain: true}); len(res.Warnings) != 0 {
+		t.Fatalf("expected no warnings for uniform text, got %v", res.Warnings)
+	}
+}
+
+func TestEstimateInputOverheadOverride(t *testing.T) {
+	overhead := DefaultOverhead()
+	overhead.Base = 10
+	overhead.PerMessage = 3
+	overhead.ImageLow = 100
+	opts := Options{Strategy: StrategyUltraFast, Overhead: &overhead}
+
+	res := EstimateInput("hello", ImageCounts{LowDetail: 1}, 2, opts)
+	if want := 2 + 100 + 10 + 2*3; res.Tokens != want {
+		t.Fatalf("expected %d tokens, got %d", want, res.Tokens)
+	}
+
+	inner := &countEstimator{}
+	cached := WithCache(inner, 4)
+	text := strings.Repeat("a", defaultCacheMinTextBytes+64)
+	cached.EstimateInput(text, ImageCounts{}, 1, Options{Strategy: StrategyUltraFast})
+	cached.EstimateInput(text, ImageCounts{}, 1, opts)
+	if inner.calls != 2 {
+		t.Fatalf("expected overhead override to change cache key, got %d inner calls", inner.calls)
+	}
+}
+
+func TestCJKRangesOverride(t *testing.T) {
+	opts := Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI}
+
+	// Treat only Han ideographs as CJK, so kana is no longer classified as CJK.
+	opts.CJKRanges = []RuneRange{{Lo: 0x4E00, Hi: 0x9FFF}}
+	ranges := ExplainRanges("カタカナ", opts)
+	if len(ranges) != 1 || ranges[0].Category == SegmentCategoryCJK {
+		t.Fatalf("expected kana not to be classified as CJK, got %+v", ranges)
+	}
+
+	// Costing follows the override too: CJK segments cost one token per rune.
+	def := EstimateText("hello", Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI})
+	opts.CJKRanges = []RuneRange{{Lo: 'a', Hi: 'z'}}
+	res := EstimateText("hello", opts)
+	if res.Tokens <= def.Tokens {
+		t.Fatalf("expected per-rune costing above %d, got %d", def.Tokens, res.Tokens)
+	}
+}
+
+func TestEncodingForModel(t *testing.T) {
+	cases := map[string]string{
+		"gpt-4o-mini":      EncodingO200K,
+		"o3-mini":          EncodingO200K,
+		"gpt-4-turbo":      EncodingCL100K,
+		"gpt-3.5-turbo":    EncodingCL100K,
+		"text-davinci-003": EncodingP50K,
+		"davinci":          EncodingR50K,
+		"davinci-002":      EncodingCL100K,
+		"ada-002":          EncodingR50K,
+		"o1":               EncodingO200K,
+		"o1-preview":       EncodingO200K,
+		"o1x":              "",
+		"adam-7b":          "",
+		"claude-3-opus":    "",
+	}
+	for model, want := range cases {
+		if got := EncodingForModel(model); got != want {
+			t.Fatalf("%s: expected %q, got %q", model, want, got)
+		}
+	}
+}
+
+func TestResolveProfileLegacyOpenAI(t *testing.T) {
+	// No weights are fitted against cl100k_base yet, so cl100k-era models keep ProfileOpenAI.
+	for _, opts := range []Options{{Model: "gpt-4"}, {ProviderType: "openai", Model: "gpt-3.5-turbo"}, {ProviderType: "openai", Model: "gpt-4o"}} {
+		if got := resolveProfile(opts); got != ProfileOpenAI {
+			t.Fatalf("%+v: expected ProfileOpenAI, got %v", opts, got)
+		}
+	}
+}
+
+func TestEstimateTextBatchOrdering(t *testing.T) {
+	texts := make([]string, batchParallelThreshold+17)
+	for i := range texts {
+		texts[i] = strings.Repeat("word ", i%50) + "你好"
+	}
+	opts := Options{Strategy: StrategyWeighted, Model: "claude-3-haiku"}
+
+	results := EstimateTextBatch(texts, opts)
+	if len(results) != len(texts) {
+		t.Fatalf("expected %d results, got %d", len(texts), len(results))
+	}
+	for i, text := range texts {
+		want := EstimateText(text, opts)
+		if results[i].Tokens != want.Tokens || results[i].Profile != ProfileClaude {
+			t.Fatalf("index %d: expected %d/%v, got %d/%v", i, want.Tokens, want.Profile, results[i].Tokens, results[i].Profile)
+		}
+	}
+}
+
+func TestWeightedEmojiClusters(t *testing.T) {
+	cases := map[string]int{
+		"\U0001F468\u200D\U0001F469\u200D\U0001F467\u200D\U0001F466": 1, // family (ZWJ sequence)
+		"\U0001F44D\U0001F3FD":                     1, // thumbs up + skin tone
+		"\u2764\uFE0F":                             1, // heart + VS16
+		"\U0001F600\U0001F600\U0001F600\U0001F600": 4,
+		"\U0001F1FA\U0001F1F8\U0001F1EF\U0001F1F5": 2, // 🇺🇸🇯🇵: two flags, not four indicators
+		"\U0001F1FA\U0001F1F8\U0001F1EF":           2, // an unpaired indicator stands alone
+	}
+	for input, want := range cases {
+		var stats tokenXStats
+		if got := estimateTokenXSegment(input, defaultTokenXParams, &stats); got != want {
+			t.Fatalf("%q: expected %d tokens, got %d", input, want, got)
+		}
+		if stats.EmojiCount != want || stats.TotalRunes != want {
+			t.Fatalf("%q: expected %d emoji units, got %+v", input, want, stats)
+		}
+	}
+
+	var stats tokenXStats
+	estimateTokenXSegment("hi\U0001F44D\U0001F3FD", defaultTokenXParams, &stats)
+	if stats.EmojiCount != 1 {
+		t.Fatalf("expected one emoji in mixed segment, got %d", stats.EmojiCount)
+	}
+}
+
+func TestResultConfidenceByStrategyAndContent(t *testing.T) {
+	english := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 40)
+	weighted := EstimateText(english, Options{Strategy: StrategyWeighted})
+	if weighted.Confidence < 0.9 {
+		t.Fatalf("expected high confidence for Weighted on prose, got %v", weighted.Confidence)
+	}
+	if weighted.MinTokens > weighted.Tokens || weighted.MaxTokens < weighted.Tokens {
+		t.Fatalf("expected range around %d, got [%d, %d]", weighted.Tokens, weighted.MinTokens, weighted.MaxTokens)
+	}
+
+	blob := strings.Repeat("R7ZlOLL8cP6EfE6+TOdFEwpgOetIonhq4BG2Pc/77QbY3ARXGlSR", 30)
+	ultra := EstimateBytes([]byte(blob), Options{Strategy: StrategyUltraFast})
+	if ultra.Confidence >= 0.7 {
+		t.Fatalf("expected low confidence for UltraFast on base64, got %v", ultra.Confidence)
+	}
+}
+
+func TestEstimateJSONExtractsStrings(t *testing.T) {
+	body := []byte(`{"model":"gpt-4o","messages":[{"role":"user","content":"Hello there"}],"temperature":0.7,"stream":false}`)
+	opts := Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI}
+
+	res, err := EstimateJSON(body, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := EstimateText("gpt-4o\nuser\nHello there", opts).Tokens; res.Tokens != want {
+		t.Fatalf("expected %d tokens from string values, got %d", want, res.Tokens)
+	}
+
+	opts.IncludeJSONKeys = true
+	withKeys, err := EstimateJSON(body, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := EstimateText("model\ngpt-4o\nmessages\nrole\nuser\ncontent\nHello there\ntemperature\nstream", opts).Tokens
+	if withKeys.Tokens != want {
+		t.Fatalf("expected %d tokens with keys, got %d", want, withKeys.Tokens)
+	}
+}
+
+func TestEstimateJSONInvalid(t *testing.T) {
+	for _, input := range []string{``, `{"a":`, `{"a":1} {"b":2}`, `[1,]`} {
+		if _, err := EstimateJSON([]byte(input), Options{}); !errors.Is(err, ErrInvalidJSON) {
+			t.Fatalf("%q: expected ErrInvalidJSON, got %v", input, err)
+		}
+	}
+}
+
+func TestLanguageHintAppliesToUnaccentedWords(t *testing.T) {
+	text := "Wir haben gestern zusammen gearbeitet"
+	opts := Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI}
+	auto := EstimateText(text, opts).Tokens
+
+	opts.Language = "de"
+	german := EstimateText(text, opts).Tokens
+	if german <= auto {
+		t.Fatalf("expected German hint to raise the estimate above %d, got %d", auto, german)
+	}
+	opts.Language = "DE-at"
+	if got := EstimateText(text, opts).Tokens; got != german {
+		t.Fatalf("expected region suffix and case to be ignored, got %d vs %d", got, german)
+	}
+
+	accented := "bébé désiré aéré"
+	opts.Language = ""
+	withAccents := EstimateText(accented, opts).Tokens
+	opts.Language = "en"
+	if got := EstimateText(accented, opts).Tokens; got >= withAccents {
+		t.Fatalf("expected English hint to disable accent detection (%d), got %d", withAccents, got)
+	}
+
+	opts.Language = "xx"
+	if got := EstimateText(text, opts).Tokens; got != auto {
+		t.Fatalf("expected unknown language to keep auto detection (%d), got %d", auto, got)
+	}
+}
+
+func TestApplyMultiplierEdgeCases(t *testing.T) {
+	cases := []struct {
+		multiplier float64
+		want       int
+	}{
+		{0, 100},
+		{1, 100},
+		{1.25, 125},
+		{0.5, 50},
+		{-2, 100},
+		{math.NaN(), 100},
+		{math.Inf(1), 100},
+		{math.Inf(-1), 100},
+		{1e300, math.MaxInt},
+	}
+	for _, tc := range cases {
+		if got := applyMultiplier(100, tc.multiplier); got != tc.want {
+			t.Fatalf("multiplier %v: expected %d, got %d", tc.multiplier, tc.want, got)
+		}
+	}
+}
+
+func TestLoadZRConfigOverridesCoefficients(t *testing.T) {
+	text := strings.Repeat("QWERTYUIOP", 6)
+	base := EstimateText(text, Options{Strategy: StrategyZR})
+
+	cfg, err := LoadZRConfig(strings.NewReader(`{
+		"thresholds": {"chars_per_token": 3, "capital_threshold": 0.3},
+		"coefficients": {"capital": [2.5, 0, 0, 0, 0, 0, 0, 0]},
+		"metadata": {"created_at": "2026-01-01T00:00:00Z"}
+	}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := EstimateText(text, Options{Strategy: StrategyZR, ZRConfig: cfg})
+	if got.Tokens != 50 {
+		t.Fatalf("expected 20 base tokens * 2.5 = 50, got %d (default %d)", got.Tokens, base.Tokens)
+	}
+
+	inner := &countEstimator{}
+	cached := WithCache(inner, 4)
+	long := strings.Repeat("A", defaultCacheMinTextBytes)
+	cached.EstimateText(long, Options{Strategy: StrategyZR})
+	cached.EstimateText(long, Options{Strategy: StrategyZR, ZRConfig: cfg})
+	if inner.calls != 2 {
+		t.Fatalf("expected ZRConfig to be part of the cache key, got %d inner calls", inner.calls)
+	}
+
+	if _, err := LoadZRConfig(strings.NewReader(`{"thresholds":`)); !errors.Is(err, ErrConfigInvalid) {
+		t.Fatalf("expected ErrConfigInvalid, got %v", err)
+	}
+}
+
+func TestEstimateInputExplainSumsToTotal(t *testing.T) {
+	images := ImageCounts{LowDetail: 1, HighDetail: 2}
+	for _, strategy := range []Strategy{StrategyFast, StrategyWeighted} {
+		opts := Options{Strategy: strategy, Profile: ProfileOpenAI, Explain: true, GlobalMultiplier: 1.2}
+		res := EstimateInput("Hello 世界, this is a test!", images, 3, opts)
+
+		sum := 0.0
+		seen := map[string]bool{}
+		for _, item := range res.Breakdown {
+			sum += item.Tokens
+			seen[item.Category] = true
+		}
+		if math.Abs(sum-float64(res.Tokens)) > 1e-6 {
+			t.Fatalf("%v: breakdown sums to %v, expected %d: %+v", strategy, sum, res.Tokens, res.Breakdown)
+		}
+		if !seen["image"] || !seen["overhead"] {
+			t.Fatalf("%v: expected image and overhead entries, got %+v", strategy, res.Breakdown)
+		}
+	}
+
+	messages := []Message{{Role: "user", Content: "hello there", Images: images}, {Role: "assistant", Content: "hi"}}
+	res := EstimateMessages(messages, Options{Strategy: StrategyWeighted, Explain: true})
+	sum := 0.0
+	for _, item := range res.Breakdown {
+		sum += item.Tokens
+	}
+	if math.Abs(sum-float64(res.Tokens)) > 1e-6 {
+		t.Fatalf("messages breakdown sums to %v, expected %d: %+v", sum, res.Tokens, res.Breakdown)
+	}
+}
+
+func TestImageTokensTiling(t *testing.T) {
+	cases := []struct {
+		spec ImageSpec
+		want int
+	}{
+		{ImageSpec{Width: 1024, Height: 1024, Detail: ImageDetailHigh}, 765},
+		{ImageSpec{Width: 2048, Height: 4096, Detail: ImageDetailHigh}, 1105},
+		{ImageSpec{Width: 512, Height: 512, Detail: ImageDetailHigh}, 255},
+		{ImageSpec{Width: 4096, Height: 4096, Detail: ImageDetailLow}, ImageTokensLow},
+		{ImageSpec{Detail: ImageDetailHigh}, ImageTokensHigh},
+		{ImageSpec{}, ImageTokensDefault},
+	}
+	for _, tc := range cases {
+		if got := ImageTokens(tc.spec, ProfileOpenAI); got != tc.want {
+			t.Fatalf("%+v: expected %d, got %d", tc.spec, tc.want, got)
+		}
+	}
+
+	images := []ImageSpec{{Width: 2048, Height: 4096, Detail: ImageDetailHigh}, {Detail: ImageDetailLow}}
+	opts := Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI}
+	got := EstimateInputWithImages("hello", images, 1, opts).Tokens
+	want := EstimateText("hello", opts).Tokens + 1105 + ImageTokensLow + BaseOverhead + PerMessageOverhead
+	if got != want {
+		t.Fatalf("expected %d, got %d", want, got)
+	}
+}
+
+func TestImageTokensPerProfile(t *testing.T) {
+	cases := []struct {
+		spec    ImageSpec
+		profile Profile
+		want    int
+	}{
+		{ImageSpec{Width: 1000, Height: 750}, ProfileClaude, 1000},
+		{ImageSpec{Width: 4000, Height: 3000, Detail: ImageDetailLow}, ProfileClaude, 1600},
+		{ImageSpec{Width: 300, Height: 200}, ProfileGemini, 258},
+		{ImageSpec{Width: 1024, Height: 768}, ProfileGemini, 516},
+		{ImageSpec{Width: 1024, Height: 768}, ProfileQwen, 1001},
+		{ImageSpec{Width: 384, Height: 384, Detail: ImageDetailLow}, ProfileQwen, 198},
+		{ImageSpec{Width: 4000, Height: 3000}, ProfileQwen, 1232},
+		{ImageSpec{Width: 10, Height: 10}, ProfileQwen, 6},
+		{ImageSpec{}, ProfileQwen, qwenImageTokensDefault},
+		{ImageSpec{}, ProfileClaude, claudeImageTokensDefault},
+		{ImageSpec{Detail: ImageDetailHigh}, ProfileGemini, geminiImageTokensHigh},
+	}
+	for _, tc := range cases {
+		if got := ImageTokens(tc.spec, tc.profile); got != tc.want {
+			t.Fatalf("%v %+v: expected %d, got %d", tc.profile, tc.spec, tc.want, got)
+		}
+	}
+
+	images := ImageCounts{LowDetail: 1, HighDetail: 1, Unknown: 1}
+	for _, profile := range []Profile{ProfileOpenAI, ProfileClaude, ProfileGemini, ProfileQwen} {
+		opts := Options{Strategy: StrategyUltraFast, Profile: profile}
+		overhead := InputOverhead(opts)
+		want := EstimateText("hello", opts).Tokens + overhead.ImageLow + overhead.ImageHigh + overhead.ImageDefault + BaseOverhead + PerMessageOverhead
+		if got := EstimateInput("hello", images, 1, opts).Tokens; got != want {
+			t.Fatalf("%v: expected %d, got %d", profile, want, got)
+		}
+	}
+	if InputOverhead(Options{Profile: ProfileOpenAI}) != DefaultOverhead() {
+		t.Fatal("expected OpenAI to use the package default overhead")
+	}
+	if gemini := InputOverhead(Options{Model: "gemini-2.0-flash"}); gemini.ImageDefault != geminiImageTokensDefault {
+		t.Fatalf("expected Gemini image costs for a Gemini model, got %+v", gemini)
+	}
+}
+
+func TestDeterministicCacheKeys(t *testing.T) {
+	text := strings.Repeat("stable key ", 64)
+	opts := Options{Strategy: StrategyWeighted, Profile: ProfileClaude}
+
+	// Deterministic keys are plain FNV-1a over the key fields, with no per-process seed.
+	c := &cachedEstimator{deterministic: true}
+	first := cacheKeyText(c.newHash(), text, opts)
+	if again := cacheKeyText(c.newHash(), text, opts); again != first {
+		t.Fatalf("expected repeatable keys, got %x and %x", first, again)
+	}
+	h := fnv.New64a()
+	if want := hashKey(h, StrategyWeighted, ProfileClaude, opts, []byte(text), ImageCounts{}, 0, 't'); first != want {
+		t.Fatalf("expected FNV-1a key %x, got %x", want, first)
+	}
+
+	inner := &countEstimator{}
+	cached := WithCacheOptions(inner, CacheConfig{MaxEntries: 4, DeterministicKeys: true})
+	cached.EstimateText(text, opts)
+	cached.EstimateText(text, opts)
+	if inner.calls != 1 {
+		t.Fatalf("expected deterministic cache to hit, got %d inner calls", inner.calls)
+	}
+}
+
+func TestEstimateKeyMatchesDeterministicCache(t *testing.T) {
+	text := strings.Repeat("shared key ", 64)
+	opts := Options{Strategy: StrategyWeighted, Model: "claude-3-opus"}
+	c := &cachedEstimator{deterministic: true}
+
+	if got, want := EstimateKey(text, opts), cacheKeyText(c.newHash(), text, opts); got != want {
+		t.Fatalf("expected text key %x, got %x", want, got)
+	}
+	if got, want := EstimateBytesKey([]byte(text), opts), cacheKeyBytes(c.newHash(), []byte(text), opts); got != want {
+		t.Fatalf("expected bytes key %x, got %x", want, got)
+	}
+	images := ImageCounts{LowDetail: 1}
+	if got, want := EstimateInputKey(text, images, 2, opts), cacheKeyInput(c.newHash(), text, images, 2, opts); got != want {
+		t.Fatalf("expected input key %x, got %x", want, got)
+	}
+
+	// Auto settings are resolved before hashing; distinct kinds and options never collide.
+	if EstimateKey(text, opts) != EstimateKey(text, Options{Strategy: StrategyWeighted, Profile: ProfileClaude}) {
+		t.Fatal("expected the resolved profile to produce the same key")
+	}
+	if EstimateKey(text, opts) == EstimateBytesKey([]byte(text), opts) {
+		t.Fatal("expected text and bytes keys to differ")
+	}
+	if EstimateKey(text, opts) == EstimateKey(text, Options{Strategy: StrategyFast, Model: "claude-3-opus"}) {
+		t.Fatal("expected different strategies to produce different keys")
+	}
+}
+
+func TestWeightedNumericSegmentsScaleWithDigits(t *testing.T) {
+	params := defaultTokenXParams
+	cases := map[string]int{
+		"7":                    1,
+		"123":                  1,
+		"1234":                 2,
+		"12345678901234567890": 7,
+	}
+	for input, want := range cases {
+		var stats tokenXStats
+		if got := estimateTokenXSegment(input, params, &stats); got != want {
+			t.Fatalf("%q: expected %d tokens, got %d", input, want, got)
+		}
+	}
+
+	custom := WeightsForProfile(ProfileOpenAI)
+	custom.DigitsPerToken = 5
+	var stats tokenXStats
+	if got := estimateTokenXSegment("12345678901234567890", custom.segmentation(), &stats); got != 4 {
+		t.Fatalf("expected DigitsPerToken=5 to give 4 tokens, got %d", got)
+	}
+
+	short := EstimateText("id 1", Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI}).Tokens
+	long := EstimateText("id 12345678901234567890", Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI}).Tokens
+	if long <= short+4 {
+		t.Fatalf("expected a 20-digit number to cost several tokens more than %d, got %d", short, long)
+	}
+}
+
+func TestSymbolsPerTokenSetsPunctuationDensity(t *testing.T) {
+	segment := `"}]},{"`
+	var stats tokenXStats
+	if got := estimateTokenXSegment(segment, defaultTokenXParams, &stats); got != 4 {
+		t.Fatalf("expected ceil(7/2)=4 tokens by default, got %d", got)
+	}
+
+	custom := WeightsForProfile(ProfileOpenAI)
+	custom.SymbolsPerToken = 3.5
+	if got := estimateTokenXSegment(segment, custom.segmentation(), &stats); got != 2 {
+		t.Fatalf("expected ceil(7/3.5)=2 tokens, got %d", got)
+	}
+	json := `{"a":[{"b":[{"c":[]}]}]}`
+	if EstimateText(json, Options{Strategy: StrategyWeighted, CustomWeights: &custom}).Tokens >=
+		EstimateText(json, Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI}).Tokens {
+		t.Fatal("expected denser symbol runs to lower the estimate")
+	}
+}
+
+func TestBase64RunsUseBase64Density(t *testing.T) {
+	for _, segment := range []string{"aGVsbG8gd29ybGQhIFRoaXM", "Zm9vYmFyQmF6UXV4MTIz"} {
+		if !isBase64LikeSegment(segment) {
+			t.Fatalf("expected %q to be base64-like", segment)
+		}
+	}
+	for _, segment := range []string{"handleHTTPRequest", "ServeHTTPWithContext", "internationalization", "DEADBEEF12345678"} {
+		if isBase64LikeSegment(segment) {
+			t.Fatalf("expected %q not to be base64-like", segment)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join("datasets", "test", "toxic_base64.txt"))
+	if err != nil {
+		t.Skipf("dataset unavailable: %v", err)
+	}
+	text := string(data)
+	want := float64(len(text)) / base64CharsPerToken
+	for _, strategy := range []Strategy{StrategyWeighted, StrategyFast} {
+		got := float64(EstimateText(text, Options{Strategy: strategy, Profile: ProfileOpenAI}).Tokens)
+		if got < want*0.8 || got > want*1.2 {
+			t.Fatalf("strategy %v: expected ~%.0f tokens for base64, got %.0f", strategy, want, got)
+		}
+	}
+}
+
+func TestResultJSONRoundTrip(t *testing.T) {
+	res := EstimateText("123", Options{Strategy: StrategyWeighted, Profile: ProfileClaude, Explain: true})
+	data, err := json.Marshal(res)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if !strings.Contains(string(data), `"strategy":"weighted"`) || !strings.Contains(string(data), `"profile":"claude"`) {
+		t.Fatalf("expected named strategy and profile, got %s", data)
+	}
+
+	var decoded Result
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if decoded.Strategy != res.Strategy || decoded.Profile != res.Profile || decoded.Tokens != res.Tokens || len(decoded.Breakdown) != len(res.Breakdown) {
+		t.Fatalf("expected %+v, got %+v", res, decoded)
+	}
+
+	var legacy struct {
+		Strategy Strategy
+		Profile  Profile
+	}
+	if err := json.Unmarshal([]byte(`{"Strategy":4,"Profile":"Auto"}`), &legacy); err != nil || legacy.Strategy != StrategyZR || legacy.Profile != ProfileAuto {
+		t.Fatalf("expected numeric and case-insensitive names to decode, got %+v (%v)", legacy, err)
+	}
+	if err := json.Unmarshal([]byte(`"bogus"`), &legacy.Profile); !errors.Is(err, ErrUnknownProfile) {
+		t.Fatalf("expected ErrUnknownProfile, got %v", err)
+	}
+	if _, err := json.Marshal(Strategy(42)); !errors.Is(err, ErrUnknownStrategy) {
+		t.Fatalf("expected ErrUnknownStrategy, got %v", err)
+	}
+}
+
+func TestEstimateTools(t *testing.T) {
+	tools := []byte(`[
+		{"type": "function", "function": {"name": "get_weather", "parameters": {"type": "object", "properties": {"location": {"type": "string"}}}}},
+		{"type": "function", "function": {"name": "get_time", "parameters": {"type": "object", "properties": {}}}}
+	]`)
+	res := EstimateTools(tools, Options{Profile: ProfileOpenAI})
+	if res.Strategy != StrategyWeighted {
+		t.Fatalf("expected Auto to use Weighted, got %v", res.Strategy)
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, tools); err != nil {
+		t.Fatal(err)
+	}
+	code := codeWeightsForProfile(ProfileOpenAI)
+	text := EstimateText(compact.String(), Options{Strategy: StrategyWeighted, CustomWeights: &code}).Tokens
+	if want := text + 2*PerToolOverhead + ToolsArrayOverhead; res.Tokens != want {
+		t.Fatalf("expected %d tokens (compacted text with code weights plus two tools), got %d", want, res.Tokens)
+	}
+	if strict := EstimateTools(tools, Options{Strict: true}); len(strict.Warnings) == 0 {
+		t.Fatal("expected Strict to flag the unresolved profile")
+	}
+
+	explained := EstimateTools(tools, Options{Profile: ProfileOpenAI, Explain: true, GlobalMultiplier: 1.25})
+	sum := 0.0
+	for _, item := range explained.Breakdown {
+		sum += item.Tokens
+	}
+	if int(math.Round(sum)) != explained.Tokens {
+		t.Fatalf("expected breakdown to sum to %d, got %v", explained.Tokens, sum)
+	}
+
+	if got := EstimateTools(nil, Options{}).Tokens; got != 0 {
+		t.Fatalf("expected 0 tokens for no tools, got %d", got)
+	}
+}
+
+func TestCodeWeightsTrackCodeDatasets(t *testing.T) {
+	code := codeWeightsForProfile(ProfileOpenAI)
+	var codeErr, profileErr float64
+	for _, name := range []string{"golang_net_http_server.go", "toxic_minified_js.txt", "toxic_minified_json.txt"} {
+		codeErr += math.Abs(datasetError(t, name, Options{Strategy: StrategyWeighted, CustomWeights: &code}))
+		profileErr += math.Abs(datasetError(t, name, Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI}))
+	}
+	if codeErr >= profileErr {
+		t.Fatalf("expected code weights to lower the error on code datasets, got %.3f vs %.3f", codeErr, profileErr)
+	}
+}
+
+func TestDefaultCJKRangesMatchBuiltin(t *testing.T) {
+	ranges := DefaultCJKRanges()
+	for _, r := range []rune{'a', '中', 'カ', '한', '！', 0x1100, 0xD7FF, 0x10FF} {
+		if got, want := inRuneRanges(r, ranges), isCJKRune(r); got != want {
+			t.Fatalf("%U: ranges say %v, isCJKRune says %v", r, got, want)
+		}
+	}
+
+	var withoutHangul []RuneRange
+	for _, rr := range ranges {
+		switch rr.Lo {
+		case 0xAC00, 0x1100, 0x3130, 0xA960, 0xD7B0:
+			continue
+		}
+		withoutHangul = append(withoutHangul, rr)
+	}
+	params := defaultTokenXParams
+	params.cjkRanges = withoutHangul
+	if _, stats := estimateTokenXWithStats("안녕하세요 你好", params); stats.CJKRunes != 2 {
+		t.Fatalf("expected only Han runes to count as CJK without Hangul, got %d", stats.CJKRunes)
+	}
+
+	ranges[0].Lo = 0
+	if DefaultCJKRanges()[0].Lo == 0 {
+		t.Fatal("expected DefaultCJKRanges to return a copy")
+	}
+}
+
+// testNormalizer composes the decomposed accents these tests use. It stands in for the nfc
+// package, which the core module cannot import.
+var testNormalizer = strings.NewReplacer("e\u0301", "é", "e\u0300", "è", "e\u0323\u0302", "ệ").Replace
+
+// useTestNormalizer registers testNormalizer for the rest of the test.
+func useTestNormalizer(t *testing.T) {
+	t.Helper()
+	RegisterNormalizer(testNormalizer)
+	t.Cleanup(func() { RegisterNormalizer(nil) })
+}
+
+func TestNormalize(t *testing.T) {
+	decomposed := strings.Repeat("Be\u0301be\u0301 de\u0301sire\u0301 ae\u0301re\u0301 ", 20)
+	composed := strings.Repeat("Bébé désiré aéré ", 20)
+	for _, strategy := range []Strategy{StrategyFast, StrategyWeighted, StrategyZR} {
+		opts := Options{Strategy: strategy, Profile: ProfileOpenAI, Normalize: true}
+		want := EstimateText(composed, opts).Tokens
+
+		// Without a registered normalizer the option does nothing and Validate says so.
+		plain := opts
+		plain.Normalize = false
+		if got, unchanged := EstimateText(decomposed, opts).Tokens, EstimateText(decomposed, plain).Tokens; got != unchanged {
+			t.Fatalf("%v: expected Normalize without a normalizer to keep %d tokens, got %d", strategy, unchanged, got)
+		}
+		if err := opts.Validate(); !errors.Is(err, ErrIneffectiveOption) {
+			t.Fatalf("%v: expected Validate to report the missing normalizer, got %v", strategy, err)
+		}
+
+		useTestNormalizer(t)
+		if got := EstimateText(decomposed, opts).Tokens; got != want {
+			t.Fatalf("%v: expected normalized text to match precomposed estimate %d, got %d", strategy, want, got)
+		}
+		if got := EstimateBytes([]byte(decomposed), opts).Tokens; got != want {
+			t.Fatalf("%v: expected EstimateBytes to normalize to %d, got %d", strategy, want, got)
+		}
+		if err := opts.Validate(); err != nil {
+			t.Fatalf("%v: expected Normalize to be valid with a normalizer, got %v", strategy, err)
+		}
+		RegisterNormalizer(nil)
+	}
+
+	// UltraFast does not scan the input, so it ignores Normalize in every entry point.
+	opts := Options{Strategy: StrategyUltraFast, Normalize: true}
+	useTestNormalizer(t)
+	if got, want := EstimateText(decomposed, opts).Tokens, EstimateByteLen(len(decomposed)); got != want {
+		t.Fatalf("expected UltraFast to ignore Normalize: want %d, got %d", want, got)
+	}
+}
+
+func TestCacheKeyTracksNormalizer(t *testing.T) {
+	text := "Cafe\u0301 au lait"
+	opts := Options{Strategy: StrategyFast, Normalize: true}
+	before := EstimateKey(text, opts)
+	useTestNormalizer(t)
+	if EstimateKey(text, opts) == before {
+		t.Fatal("expected registering a normalizer to change the cache key")
+	}
+	opts.Normalize = false
+	if EstimateKey(text, opts) != EstimateKey(text, Options{Strategy: StrategyFast}) {
+		t.Fatal("expected the normalizer to stay out of keys without Normalize")
+	}
+}
+
+func TestWeightedFoldsCombiningMarks(t *testing.T) {
+	// Without Normalize, decomposed accents still cost like their precomposed forms. Only
+	// composed letters reach the language detection, so the language is fixed here.
+	decomposed := strings.Repeat("Be\u0301be\u0301 de\u0301sire\u0301 ae\u0301re\u0301 ", 20)
+	composed := strings.Repeat("Bébé désiré aéré ", 20)
+	opts := Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI, Language: "fr"}
+	if got, want := EstimateText(decomposed, opts).Tokens, EstimateText(composed, opts).Tokens; got != want {
+		t.Fatalf("expected decomposed text to match precomposed estimate %d, got %d", want, got)
+	}
+
+	// Marks that compose with nothing fold into the preceding character's count too.
+	if got := characterCount("x\u0301\u0302yz"); got != 3 {
+		t.Fatalf("expected 3 characters, got %d", got)
+	}
+	if got, want := EstimateText("abcdefgx\u0301\u0302", opts).Tokens, EstimateText("abcdefgx", opts).Tokens; got != want {
+		t.Fatalf("expected uncomposable marks to add nothing: got %d, want %d", got, want)
+	}
+}
+
+func TestEmbeddedDigitsTrackedSeparately(t *testing.T) {
+	_, stats := estimateTokenXWithStats("abc123def 2024", defaultTokenXParams)
+	if stats.DigitRunes != 4 || stats.EmbeddedDigitRunes != 3 {
+		t.Fatalf("expected 4 numeric and 3 embedded digits, got %d and %d", stats.DigitRunes, stats.EmbeddedDigitRunes)
+	}
+
+	text := strings.Repeat("build42 sha256 x86 ", 50)
+	opts := Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI}
+	builtin := EstimateText(text, opts).Tokens
+	custom := WeightsForProfile(ProfileOpenAI)
+	custom.EmbeddedDigitRatioFactor = 0
+	opts.CustomWeights = &custom
+	if without := EstimateText(text, opts).Tokens; without >= builtin {
+		t.Fatalf("expected dropping the embedded digit term to lower %d, got %d", builtin, without)
+	}
+}
+
+func TestURLDelimDotContext(t *testing.T) {
+	cases := map[string]int{
+		"192.168.0.1":               0,
+		"1.0.0":                     0,
+		"a.b.c":                     0,
+		"3.14 v1.2.3":               0,
+		"https://example.com/a.txt": 6, // ':', '/', '/', '.', '/', '.'
+		"WWW.example.com":           2,
+	}
+	for input, want := range cases {
+		if got := countURLDelims(input); got != want {
+			t.Fatalf("%q: expected %d URL delimiters, got %d", input, want, got)
+		}
+	}
+}
+
+func TestURLDelimRatioFactorWeightsOnlyURLs(t *testing.T) {
+	builtin := Options{Strategy: StrategyWeighted, Profile: ProfileGemini}
+	custom := WeightsForProfile(ProfileGemini)
+	custom.URLDelimRatioFactor = 1.2
+	weighted := builtin
+	weighted.CustomWeights = &custom
+
+	for _, text := range []string{"192.168.0.1", "1.0.0", "a.b.c", "release v1.2.3 of 3.14"} {
+		if want, got := EstimateText(text, builtin).Tokens, EstimateText(text, weighted).Tokens; got != want {
+			t.Fatalf("%q: expected dots outside URLs to cost %d, got %d", text, want, got)
+		}
+	}
+
+	url := "see https://example.com/docs/v1.2/index.html?lang=en#intro"
+	want := EstimateText(url, builtin).Tokens
+	if got := EstimateText(url, weighted).Tokens; got <= want {
+		t.Fatalf("expected URL delimiters to add to %d, got %d", want, got)
+	}
+	for _, limit := range []int{want, want + 1, EstimateText(url, weighted).Tokens} {
+		if got, exceeded := ExceedsTokens(url, limit, weighted), EstimateText(url, weighted).Tokens > limit; got != exceeded {
+			t.Fatalf("limit %d: ExceedsTokens returned %v, EstimateText exceeds %v", limit, got, exceeded)
+		}
+	}
+	parts := []string{"see https://example.com", "docs/v1.2/index.html?lang=en#intro"}
+	if got := EstimateConcat(parts, "/", weighted).Tokens; got != EstimateText(url, weighted).Tokens {
+		t.Fatalf("expected EstimateConcat to count URL delimiters across parts, got %d", got)
+	}
+}
+
+func TestCountMathSymbolsUsesContext(t *testing.T) {
+	cases := map[string]int{
+		"state-of-the-art": 0,
+		"x = y + z":        2,
+		"a/b/c":            0,
+		"x - y":            1,
+		"10-5":             1,
+		"6 / 3":            1,
+	}
+	for input, want := range cases {
+		if got := countMathSymbols(input); got != want {
+			t.Fatalf("%q: expected %d math symbols, got %d", input, want, got)
+		}
+	}
+}
+
+func TestMathRatioFactorSkipsHyphensAndPaths(t *testing.T) {
+	builtin := Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI}
+	custom := WeightsForProfile(ProfileOpenAI)
+	custom.MathRatioFactor = 2.68
+	weighted := builtin
+	weighted.CustomWeights = &custom
+	weighted.Explain = true
+
+	for _, text := range []string{"a state-of-the-art, well-known model", "see a/b/c and/or d"} {
+		if want, got := EstimateText(text, builtin).Tokens, EstimateText(text, weighted).Tokens; got != want {
+			t.Fatalf("%q: expected hyphens and slashes to cost %d, got %d", text, want, got)
+		}
+	}
+
+	text := "x = y + z * 2 - w"
+	want := EstimateText(text, builtin).Tokens
+	res := EstimateText(text, weighted)
+	if res.Tokens <= want {
+		t.Fatalf("expected math operators to add to %d, got %d", want, res.Tokens)
+	}
+	found := false
+	for _, item := range res.Breakdown {
+		if item.Category == weightedCategoryMath {
+			found = item.Weight == custom.MathRatioFactor
+		}
+	}
+	if !found {
+		t.Fatalf("expected a %s breakdown entry, got %+v", weightedCategoryMath, res.Breakdown)
+	}
+}
+
+func TestContextLimit(t *testing.T) {
+	cases := map[string]int{
+		"gpt-4o-mini":                128_000,
+		"gpt-4":                      8_192,
+		"gpt-4-0613":                 8_192,
+		"gpt-4-turbo-2024-04-09":     128_000,
+		"claude-3-5-sonnet-20241022": 200_000,
+		"models/gemini-1.5-pro":      2_097_152,
+		"GPT-3.5-Turbo":              16_385,
+	}
+	for model, want := range cases {
+		if got, ok := ContextLimit(model); !ok || got != want {
+			t.Fatalf("%s: expected %d, got %d (ok=%v)", model, want, got, ok)
+		}
+	}
+	if _, ok := ContextLimit("my-local-model"); ok {
+		t.Fatal("expected unknown model to have no limit")
+	}
+}
+
+func TestFitsContext(t *testing.T) {
+	text := strings.Repeat("word ", 10_000)
+	fits, estimated, limit := FitsContext(text, "gpt-4", Options{Strategy: StrategyWeighted})
+	if fits || limit != 8_192 || estimated <= limit {
+		t.Fatalf("expected %d-token text not to fit gpt-4 (limit %d)", estimated, limit)
+	}
+	if fits, _, _ := FitsContext(text, "gpt-4o", Options{Strategy: StrategyWeighted}); !fits {
+		t.Fatal("expected text to fit gpt-4o")
+	}
+	if fits, _, limit := FitsContext(text, "unknown", Options{}); !fits || limit != 0 {
+		t.Fatalf("expected unknown model to fit with zero limit, got fits=%v limit=%d", fits, limit)
+	}
+}
+
+func TestWhitespaceOnlyInputCostsPerRun(t *testing.T) {
+	cases := []struct {
+		text string
+		want int
+	}{
+		{"   ", 1}, {"\n\n\n", 1}, {"\t\t", 1}, {" ", 1}, {" \u3000\r\n", 1},
+		{strings.Repeat(" ", 16), 1},
+		{strings.Repeat(" ", 17), 2},
+		{strings.Repeat(" ", 1000), 63},
+		{strings.Repeat("\n", 20000), 1250},
+	}
+	strategies := []Strategy{StrategyAuto, StrategyUltraFast, StrategyFast, StrategyWeighted, StrategyZR}
+	for _, strategy := range strategies {
+		opts := Options{Strategy: strategy, Explain: true}
+		if got := EstimateText("", opts).Tokens; got != 0 {
+			t.Fatalf("%v: empty input got %d tokens, want 0", strategy, got)
+		}
+		for _, tc := range cases {
+			if got := EstimateText(tc.text, opts).Tokens; got != tc.want {
+				t.Fatalf("%v: EstimateText(%.20q) = %d, want %d", strategy, tc.text, got, tc.want)
+			}
+			if got := EstimateBytes([]byte(tc.text), opts).Tokens; got != tc.want {
+				t.Fatalf("%v: EstimateBytes(%.20q) = %d, want %d", strategy, tc.text, got, tc.want)
+			}
+			acc := NewAccumulator(opts)
+			acc.Write([]byte(tc.text))
+			if got := acc.Tokens(); got != tc.want {
+				t.Fatalf("%v: Accumulator(%.20q) = %d, want %d", strategy, tc.text, got, tc.want)
+			}
+		}
+	}
+
+	// Explain accounts for the tokens, and WhitespaceRunesPerToken applies only to runs within
+	// other text.
+	res := EstimateText(strings.Repeat(" ", 40), Options{Strategy: StrategyWeighted, Explain: true})
+	if item := res.Breakdown; len(item) != 1 || item[0].Tokens != 3 || item[0].BaseUnits*item[0].Weight != item[0].Tokens {
+		t.Fatalf("expected one consistent breakdown entry of 3 tokens, got %+v", res.Breakdown)
+	}
+	weights := weightsForProfile(ProfileOpenAI)
+	weights.WhitespaceRunesPerToken = 4
+	opts := Options{Strategy: StrategyWeighted, CustomWeights: &weights}
+	if got := EstimateText(strings.Repeat(" ", 1000), opts).Tokens; got != 63 {
+		t.Fatalf("expected a whitespace-only run to cost 63 tokens, got %d", got)
+	}
+	if got := EstimateText("x"+strings.Repeat(" ", 1000)+"y", opts).Tokens; got <= 2 {
+		t.Fatalf("expected WhitespaceRunesPerToken to charge a long run, got %d", got)
+	}
+}
+
+func TestExceedsTokensMatchesEstimate(t *testing.T) {
+	texts := []string{
+		"",
+		"Hello, world!",
+		strings.Repeat("The quick brown fox jumps over the lazy dog. ", 200),
+		strings.Repeat("你好世界，这是一个测试。", 100),
+		strings.Repeat(`{"id":12345,"tags":["a","b"]}`, 100),
+		strings.Repeat(" ", 20000),
+		strings.Repeat(" ", 20000) + "x",
+	}
+	// Indentation costs give whitespace runs a base count that blank input does not pay.
+	indented := WeightsForProfile(ProfileOpenAI)
+	indented.WhitespaceRunesPerToken = 4
+	optsList := []Options{
+		{Strategy: StrategyUltraFast},
+		{Strategy: StrategyFast},
+		{Strategy: StrategyWeighted, Profile: ProfileClaude},
+		{Strategy: StrategyWeighted, CustomWeights: &indented},
+		{Strategy: StrategyZR},
+		{Strategy: StrategyWeighted, GlobalMultiplier: 1.3, SpecialTokens: map[string]int{"<|end|>": 1}},
+	}
+	for _, opts := range optsList {
+		for _, text := range texts {
+			for _, text := range []string{text, text + "<|end|>"} {
+				want := EstimateText(text, opts).Tokens
+				for _, limit := range []int{-1, 0, 10, want / 3, want - 1, want, want + 1} {
+					if got := ExceedsTokens(text, limit, opts); got != (want > limit) {
+						t.Fatalf("%v: ExceedsTokens(%.20q, %d) = %v with estimate %d", opts.Strategy, text, limit, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestWeightedExceedsStopsEarly(t *testing.T) {
+	text := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 100_000)
+	calls := 0
+	exceeds := func(tokens int) bool {
+		calls++
+		return tokens > 1000
+	}
+	opts := Options{Strategy: StrategyWeighted}
+	if !weightedExceeds(text, weightsForOptions(opts), segmentationForOptions(opts), exceeds) {
+		t.Fatal("expected Weighted to exceed 1000 tokens")
+	}
+	// Each word and space is a segment; scanning the whole text would call exceeds ~2M times.
+	if calls > 10_000 {
+		t.Fatalf("called exceeds %d times; expected an early stop", calls)
+	}
+}
+
+func TestObserverReportsEachEstimateOnce(t *testing.T) {
+	var observed []Result
+	opts := Options{Strategy: StrategyWeighted, GlobalMultiplier: 1.2, Observer: func(res Result) {
+		observed = append(observed, res)
+	}}
+	text := "Hello, 世界! {\"k\": [1, 2, 3]}"
+	tools := []byte(`[{"name":"get_weather","parameters":{"type":"object"}}]`)
+
+	calls := []struct {
+		name     string
+		estimate func() Result
+	}{
+		{"EstimateText", func() Result { return EstimateText(text, opts) }},
+		{"EstimateBytes", func() Result { return EstimateBytes([]byte(text), opts) }},
+		{"EstimateInput", func() Result { return EstimateInput(text, ImageCounts{LowDetail: 1}, 2, opts) }},
+		{"EstimateInputWithImages", func() Result { return EstimateInputWithImages(text, []ImageSpec{{Width: 512, Height: 512}}, 2, opts) }},
+		{"EstimateOutput", func() Result { return EstimateOutput(text, opts) }},
+		{"EstimateMessages", func() Result { return EstimateMessages([]Message{{Content: text}, {Content: text}}, opts) }},
+		{"EstimateTools", func() Result { return EstimateTools(tools, opts) }},
+		{"EstimateAnthropicContent", func() Result {
+			return EstimateAnthropicContent([]ContentBlock{{Type: "text", Text: text}, {Type: "text", Text: text}}, opts)
+		}},
+		{"EstimateConcat", func() Result { return EstimateConcat([]string{text, text}, " ", opts) }},
+		{"EstimateReader", func() Result {
+			res, _ := EstimateReader(strings.NewReader(text), opts)
+			return res
+		}},
+	}
+	for _, call := range calls {
+		observed = nil
+		res := call.estimate()
+		if len(observed) != 1 || observed[0].Tokens != res.Tokens {
+			t.Fatalf("%s: expected one report of %d tokens, got %+v", call.name, res.Tokens, observed)
+		}
+	}
+
+	observed = nil
+	if results := EstimateBatchWithSharedPrefix(text, []string{"a", "b c"}, opts); len(observed) != len(results) {
+		t.Fatalf("EstimateBatchWithSharedPrefix: expected %d reports, got %d", len(results), len(observed))
+	}
+
+	// Cache hits report too; the miss is reported by the inner estimate.
+	observed = nil
+	cached := WithCacheOptions(DefaultEstimator(), CacheConfig{MaxEntries: 4, MinTextBytes: 1})
+	for i := 0; i < 2; i++ {
+		cached.EstimateText(text, opts)
+	}
+	if len(observed) != 2 {
+		t.Fatalf("expected a report per cached call, got %d", len(observed))
+	}
+}
+
+func TestWithCacheClear(t *testing.T) {
+	inner := &countEstimator{}
+	cached := WithCache(inner, 4)
+	clearable, ok := cached.(Clearable)
+	if !ok {
+		t.Fatal("expected cached estimator to implement Clearable")
+	}
+
+	text := strings.Repeat("a", defaultCacheMinTextBytes)
+	opts := Options{Strategy: StrategyFast}
+	cached.EstimateText(text, opts)
+	if clearable.Len() != 1 {
+		t.Fatalf("expected 1 entry, got %d", clearable.Len())
+	}
+
+	clearable.Clear()
+	if clearable.Len() != 0 {
+		t.Fatalf("expected empty cache after Clear, got %d", clearable.Len())
+	}
+	cached.EstimateText(text, opts)
+	if inner.calls != 2 {
+		t.Fatalf("expected a miss after Clear, got %d inner calls", inner.calls)
+	}
+	if stats := cached.(CacheStatsProvider).Stats(); stats.Misses != 2 || stats.Bytes != len(text) {
+		t.Fatalf("unexpected stats after Clear: %+v", stats)
+	}
+}
+
+func TestInvalidUTF8CostsOneUnitPerByte(t *testing.T) {
+	raw := make([]byte, 0, 128)
+	for b := 0x80; b <= 0xFF; b++ {
+		raw = append(raw, byte(b))
+	}
+	text := string(raw)
+
+	base := 0
+	for _, seg := range Segments(text) {
+		if seg.Category != SegmentCategoryInvalid {
+			t.Fatalf("expected only invalid segments, got %+v", seg)
+		}
+		base += seg.Tokens
+	}
+	if base != len(raw) {
+		t.Fatalf("expected %d base units, got %d", len(raw), base)
+	}
+	if got := EstimateText(text, Options{Strategy: StrategyFast}).Tokens; got != len(raw) {
+		t.Fatalf("expected Fast to cost %d tokens, got %d", len(raw), got)
+	}
+
+	// A literal U+FFFD is a valid rune and is not an invalid segment.
+	for _, seg := range Segments("ab\x80\x81cd\uFFFD") {
+		if want := seg.Start == 2; (seg.Category == SegmentCategoryInvalid) != want {
+			t.Fatalf("unexpected segment %+v", seg)
+		}
+	}
+
+	mixed := "hello " + text + " world"
+	minTokens, maxTokens := math.MaxInt, 0
+	for _, strategy := range []Strategy{StrategyFast, StrategyWeighted, StrategyZR} {
+		opts := Options{Strategy: strategy, Profile: ProfileOpenAI}
+		got := EstimateText(mixed, opts).Tokens
+		minTokens, maxTokens = min(minTokens, got), max(maxTokens, got)
+
+		res, err := EstimateReader(iotest.OneByteReader(strings.NewReader(mixed)), opts)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", strategy, err)
+		}
+		if res.Tokens != got {
+			t.Fatalf("%v: reader estimated %d tokens, in-memory %d", strategy, res.Tokens, got)
+		}
+	}
+	if float64(maxTokens) > 1.1*float64(minTokens) {
+		t.Fatalf("strategies disagree on invalid UTF-8: %d-%d tokens", minTokens, maxTokens)
+	}
+}
+
+func TestSpecialTokensCountOncePerMatch(t *testing.T) {
+	special := map[string]int{"<|im_start|>": 1, "<|im_end|>": 1, "<|im": 5}
+	body := "user\nWhat is the capital of France?"
+	text := "<|im_start|>" + body + "<|im_end|>"
+
+	for _, strategy := range []Strategy{StrategyFast, StrategyWeighted, StrategyZR} {
+		plain := EstimateText(" "+body+" ", Options{Strategy: strategy, Profile: ProfileOpenAI})
+		got := EstimateText(text, Options{Strategy: strategy, Profile: ProfileOpenAI, SpecialTokens: special, Explain: true})
+		if got.Tokens != plain.Tokens+2 {
+			t.Fatalf("%v: expected %d tokens, got %d", strategy, plain.Tokens+2, got.Tokens)
+		}
+		last := got.Breakdown[len(got.Breakdown)-1]
+		if last.Category != specialTokensCategory || last.BaseUnits != 2 || last.Tokens != 2 {
+			t.Fatalf("%v: unexpected breakdown entry %+v", strategy, last)
+		}
+	}
+
+	rest, tokens, matches := extractSpecialTokens("a<|im_start|>b<|imx", special)
+	if rest != "a b x" || tokens != 6 || matches != 2 {
+		t.Fatalf("unexpected extraction: %q, %d tokens, %d matches", rest, tokens, matches)
+	}
+
+	unmarked := EstimateText(text, Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI})
+	marked := EstimateText(text, Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI, SpecialTokens: special})
+	if marked.Tokens >= unmarked.Tokens {
+		t.Fatalf("expected special tokens to lower the estimate, got %d vs %d", marked.Tokens, unmarked.Tokens)
+	}
+}
+
+func TestSpecialTokensOnlyInputCostsTheirSum(t *testing.T) {
+	special := map[string]int{"<|im_start|>": 1, "<|im_end|>": 1, "<|image|>": 3}
+	for _, tc := range []struct {
+		text string
+		want int
+	}{
+		{"<|im_start|>", 1},
+		{"<|im_start|><|im_end|>", 2},
+		{"<|image|><|image|><|im_end|>", 7},
+		// Whitespace the caller wrote is still input.
+		{"<|im_start|>\n<|im_end|>", 3},
+	} {
+		for _, strategy := range []Strategy{StrategyFast, StrategyWeighted, StrategyZR} {
+			opts := Options{Strategy: strategy, Profile: ProfileOpenAI, SpecialTokens: special}
+			if got := EstimateText(tc.text, opts).Tokens; got != tc.want {
+				t.Fatalf("%v: EstimateText(%q) = %d, want %d", strategy, tc.text, got, tc.want)
+			}
+			if got := EstimateBytes([]byte(tc.text), opts).Tokens; got != tc.want {
+				t.Fatalf("%v: EstimateBytes(%q) = %d, want %d", strategy, tc.text, got, tc.want)
+			}
+			acc := NewAccumulator(opts)
+			acc.Write([]byte(tc.text))
+			if got := acc.Tokens(); got != tc.want {
+				t.Fatalf("%v: Accumulator(%q) = %d, want %d", strategy, tc.text, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestWhitespaceRunsCostSublinearly(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("def handler(event):\n")
+	for depth := 1; depth <= 6; depth++ {
+		b.WriteString(strings.Repeat("    ", depth))
+		b.WriteString("if event.level > 0:\n")
+	}
+	b.WriteString(strings.Repeat("    ", 7) + "return event\n")
+	code := b.String()
+
+	plain := WeightsForProfile(ProfileOpenAI)
+	indented := plain
+	indented.WhitespaceRunesPerToken = 8
+	free := EstimateText(code, Options{Strategy: StrategyWeighted, CustomWeights: &plain}).Tokens
+	got := EstimateText(code, Options{Strategy: StrategyWeighted, CustomWeights: &indented}).Tokens
+	if got <= free {
+		t.Fatalf("expected indentation to add tokens, got %d vs %d without", got, free)
+	}
+
+	params := indented.segmentation()
+	cases := map[string]int{
+		" ":                            0,
+		"\n":                           0,
+		"\n    ":                       1,
+		"\n" + strings.Repeat(" ", 16): 2,
+		"\n" + strings.Repeat(" ", 64): 8,
+	}
+	for input, want := range cases {
+		var stats tokenXStats
+		if got := estimateTokenXSegment(input, params, &stats); got != want {
+			t.Fatalf("%q: expected %d tokens, got %d", input, want, got)
+		}
+	}
+}
+
+func TestShortTokenThresholdOption(t *testing.T) {
+	// "tokenizers" (10 runes) costs ceil(10/6)=2 base units by density and 1 once the threshold
+	// covers it.
+	text := "tokenizers"
+	base := Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI, Explain: true}
+	raised := base
+	raised.ShortTokenThreshold = 10
+
+	baseUnits := func(res Result) float64 {
+		for _, entry := range res.Breakdown {
+			if entry.Category == weightedCategoryBase {
+				return entry.BaseUnits
+			}
+		}
+		t.Fatalf("missing base entry in %+v", res.Breakdown)
+		return 0
+	}
+	if got := baseUnits(EstimateText(text, base)); got != 2 {
+		t.Fatalf("expected 2 base units by default, got %v", got)
+	}
+	if got := baseUnits(EstimateText(text, raised)); got != 1 {
+		t.Fatalf("expected 1 base unit with threshold 10, got %v", got)
+	}
+
+	multi := EstimateMulti(text, []Profile{ProfileOpenAI}, Options{ShortTokenThreshold: 10})
+	if want := EstimateText(text, Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI, ShortTokenThreshold: 10}).Tokens; multi[ProfileOpenAI] != want {
+		t.Fatalf("expected EstimateMulti to honor the threshold (%d), got %d", want, multi[ProfileOpenAI])
+	}
+}
+
+func TestCJKPunctuationCostsPerRune(t *testing.T) {
+	for _, r := range []rune{'，', '！', '？', '、', '。', '「', '】', '：', '（'} {
+		if !isCJKPunct(r) || !isTokenXPunct(r) {
+			t.Fatalf("expected %q to be CJK punctuation", r)
+		}
+	}
+	for _, r := range []rune{'你', '々', 'Ａ', '１', 'あ', '　'} {
+		if isCJKPunct(r) {
+			t.Fatalf("expected %q not to be CJK punctuation", r)
+		}
+	}
+
+	text := "你好，世界！"
+	_, stats := estimateTokenXWithStats(text, defaultTokenXParams)
+	if stats.CJKRunes != 6 || stats.PunctRunes != 0 {
+		t.Fatalf("expected 6 CJK and no punctuation runes, got %+v", stats)
+	}
+
+	var categories []string
+	for _, seg := range Segments(text) {
+		categories = append(categories, seg.Category)
+	}
+	want := []string{SegmentCategoryCJK, SegmentCategoryPunct, SegmentCategoryCJK, SegmentCategoryPunct}
+	if strings.Join(categories, ",") != strings.Join(want, ",") {
+		t.Fatalf("expected segments %v, got %v", want, categories)
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	valid := []Options{
+		{},
+		{Strategy: StrategyWeighted, Profile: ProfileClaude, Explain: true, Language: "de", ShortTokenThreshold: 4},
+		{Strategy: StrategyFast, FastSampleFraction: 0.01},
+		{Strategy: StrategyZR, ZRConfig: &ZRConfig{}},
+		{GlobalMultiplier: 1.1, CustomWeights: &Weights{BaseFactor: 1}},
+	}
+	for _, opts := range valid {
+		if err := opts.Validate(); err != nil {
+			t.Fatalf("expected %+v to be valid, got %v", opts, err)
+		}
+	}
+
+	cases := []struct {
+		opts Options
+		want error
+	}{
+		{Options{Strategy: StrategyUltraFast, Explain: true}, ErrIneffectiveOption},
+		{Options{Strategy: StrategyFast, CustomWeights: &Weights{}}, ErrIneffectiveOption},
+		{Options{Strategy: StrategyWeighted, FastSampleWindows: 8}, ErrIneffectiveOption},
+		{Options{Strategy: StrategyWeighted, ZRConfig: &ZRConfig{}}, ErrIneffectiveOption},
+		{Options{Language: "xx"}, ErrIneffectiveOption},
+		{Options{FastSampleFraction: 2}, ErrIneffectiveOption},
+		{Options{GlobalMultiplier: -1}, ErrInvalidMultiplier},
+		{Options{GlobalMultiplier: math.NaN()}, ErrInvalidMultiplier},
+		{Options{Strategy: Strategy(42)}, ErrUnknownStrategy},
+		{Options{Profile: Profile(42)}, ErrUnknownProfile},
+	}
+	for _, tc := range cases {
+		if err := tc.opts.Validate(); !errors.Is(err, tc.want) {
+			t.Fatalf("%+v: expected %v, got %v", tc.opts, tc.want, err)
+		}
+	}
+
+	err := Options{Strategy: StrategyUltraFast, Explain: true, GlobalMultiplier: -2}.Validate()
+	if !errors.Is(err, ErrIneffectiveOption) || !errors.Is(err, ErrInvalidMultiplier) {
+		t.Fatalf("expected both problems to be reported, got %v", err)
+	}
+}
+
+func TestStrictRejectsFallbacks(t *testing.T) {
+	text := "Strict mode should fail loud."
+	for _, opts := range []Options{
+		{Strict: true},
+		{Strict: true, Strategy: StrategyWeighted},
+		{Strict: true, Strategy: Strategy(42), Profile: ProfileClaude},
+		{Strict: true, Strategy: StrategyUltraFast, Explain: true},
+	} {
+		if _, err := EstimateTextCtx(context.Background(), text, opts); !errors.Is(err, ErrStrict) {
+			t.Fatalf("%+v: expected ErrStrict, got %v", opts, err)
+		}
+		if _, err := EstimateReader(strings.NewReader(text), opts); !errors.Is(err, ErrStrict) {
+			t.Fatalf("%+v: expected ErrStrict from EstimateReader, got %v", opts, err)
+		}
+		if res := EstimateText(text, opts); len(res.Warnings) == 0 {
+			t.Fatalf("%+v: expected EstimateText to warn", opts)
+		}
+	}
+	if _, err := EstimateTextCtx(context.Background(), text, Options{Strict: true, Strategy: Strategy(42)}); !errors.Is(err, ErrUnknownStrategy) {
+		t.Fatalf("expected the Validate error to be wrapped, got %v", err)
+	}
+
+	for _, opts := range []Options{
+		{Strict: true, Strategy: StrategyWeighted, Profile: ProfileClaude},
+		{Strict: true, Strategy: StrategyWeighted, Model: "gemini-2.0-flash"},
+		{Strict: true, Strategy: StrategyFast},
+		{Strict: true, Strategy: StrategyZR},
+	} {
+		res, err := EstimateTextCtx(context.Background(), text, opts)
+		if err != nil {
+			t.Fatalf("%+v: unexpected error %v", opts, err)
+		}
+		lenient := opts
+		lenient.Strict = false
+		if want := EstimateText(text, lenient); res.Tokens != want.Tokens || len(res.Warnings) != 0 {
+			t.Fatalf("%+v: got %+v, want %+v", opts, res, want)
+		}
+	}
+
+	res := EstimateBytes([]byte(text), Options{Strict: true})
+	if want := EstimateBytes([]byte(text), Options{}); res.Tokens != want.Tokens || len(res.Warnings) != 1 {
+		t.Fatalf("expected EstimateBytes to estimate as usual and warn, got %+v", res)
+	}
+}
+
+func TestCacheKeysStrictOutcome(t *testing.T) {
+	text := strings.Repeat("Strict results must not leak through the cache. ", 20)
+	cached := WithCache(DefaultEstimator(), 8)
+	withModel := Options{Strict: true, Strategy: StrategyWeighted, Model: "gpt-4o"}
+	withoutModel := Options{Strict: true, Strategy: StrategyWeighted}
+
+	// Both resolve to ProfileOpenAI, but only the call without a Model warns.
+	if res := cached.EstimateText(text, withModel); len(res.Warnings) != 0 {
+		t.Fatalf("expected no warning with a Model, got %v", res.Warnings)
+	}
+	if res := cached.EstimateText(text, withoutModel); len(res.Warnings) != 1 {
+		t.Fatalf("expected a strict warning without a Model, got %v", res.Warnings)
+	}
+	if res := cached.EstimateText(text, withModel); len(res.Warnings) != 0 {
+		t.Fatalf("expected the cached warning-free result, got %v", res.Warnings)
+	}
+	if EstimateKey(text, withModel) == EstimateKey(text, withoutModel) {
+		t.Fatal("expected different keys for different strict outcomes")
+	}
+	if EstimateKey(text, withModel) != EstimateKey(text, Options{Strategy: StrategyWeighted, Model: "gpt-4o"}) {
+		t.Fatal("expected Strict without a violation to share the non-strict key")
+	}
+}
+
+func TestHangulSegmentsUseHangulCost(t *testing.T) {
+	text := "안녕하세요, 한국어 문장입니다. 你好"
+	want := []struct {
+		category string
+		tokens   int
+	}{
+		{SegmentCategoryHangul, 5}, {SegmentCategoryShort, 1}, {SegmentCategoryWhitespace, 0},
+		{SegmentCategoryHangul, 3}, {SegmentCategoryWhitespace, 0}, {SegmentCategoryHangul, 5},
+		{SegmentCategoryShort, 1}, {SegmentCategoryWhitespace, 0}, {SegmentCategoryCJK, 2},
+	}
+	segments := Segments(text)
+	if len(segments) != len(want) {
+		t.Fatalf("expected %d segments, got %+v", len(want), segments)
+	}
+	// By default a syllable costs one token, like a Han character.
+	for i, seg := range segments {
+		if seg.Category != want[i].category || seg.Tokens != want[i].tokens {
+			t.Fatalf("segment %d: expected %s/%d, got %+v", i, want[i].category, want[i].tokens, seg)
+		}
+	}
+
+	custom := WeightsForProfile(ProfileOpenAI)
+	custom.HangulTokensPerSyllable = 1.5
+	var stats tokenXStats
+	if got := estimateTokenXSegment("안녕하세요", custom.segmentation(), &stats); got != 8 {
+		t.Fatalf("expected ceil(5*1.5)=8 tokens, got %d", got)
+	}
+	if got := estimateTokenXSegment("你好", custom.segmentation(), &stats); got != 2 {
+		t.Fatalf("expected Han to keep one token per rune, got %d", got)
+	}
+	base := EstimateText(text, Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI}).Tokens
+	if got := EstimateText(text, Options{Strategy: StrategyWeighted, CustomWeights: &custom}).Tokens; got <= base {
+		t.Fatalf("expected a higher Hangul cost to raise the estimate above %d, got %d", base, got)
+	}
+}
+
+func TestEstimateOutputAddsProfileOverhead(t *testing.T) {
+	text := "The answer is 42."
+	for _, tc := range []struct {
+		opts Options
+		want int
+	}{
+		{Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI}, 0},
+		{Options{Strategy: StrategyWeighted, Profile: ProfileClaude}, 0},
+		{Options{Strategy: StrategyWeighted, Model: "qwen2.5-72b-instruct"}, 1},
+		{Options{Strategy: StrategyWeighted, Profile: ProfileQwen, Overhead: &OverheadConfig{Output: 3}}, 3},
+	} {
+		textTokens := EstimateText(text, tc.opts).Tokens
+		if got := EstimateOutput(text, tc.opts).Tokens; got != textTokens+tc.want {
+			t.Fatalf("%+v: expected %d output tokens, got %d", tc.opts, textTokens+tc.want, got)
+		}
+	}
+
+	// Output and text results are cached under different keys.
+	long := strings.Repeat("generated output ", 64)
+	opts := Options{Strategy: StrategyWeighted, Profile: ProfileQwen}
+	cached := WithCache(DefaultEstimator(), 4)
+	textTokens := cached.EstimateText(long, opts).Tokens
+	if got := cached.EstimateOutput(long, opts).Tokens; got != textTokens+1 {
+		t.Fatalf("expected cached output to add 1 token to %d, got %d", textTokens, got)
+	}
+}
+
+func TestEstimateConcatMatchesJoinedText(t *testing.T) {
+	parts := []string{
+		"First document: hello, world!",
+		"第二个文档，你好",
+		"",
+		"x",
+		"func main() { fmt.Println(42) }",
+		"3.14",
+		"trailing words ",
+	}
+	for _, sep := range []string{"\n\n", "", "-", " ", "1"} {
+		joined := strings.Join(parts, sep)
+		for _, strategy := range []Strategy{StrategyUltraFast, StrategyFast, StrategyWeighted, StrategyZR} {
+			opts := Options{Strategy: strategy, Profile: ProfileOpenAI}
+			want := EstimateText(joined, opts).Tokens
+			if got := EstimateConcat(parts, sep, opts).Tokens; got != want {
+				t.Fatalf("%v, sep %q: expected %d tokens, got %d", strategy, sep, want, got)
+			}
+		}
+	}
+
+	if got := EstimateConcat(nil, "\n\n", Options{}).Tokens; got != 0 {
+		t.Fatalf("expected no parts to cost 0, got %d", got)
+	}
+	sep := EstimateConcat([]string{"alpha", "beta", "gamma"}, " | ", Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI}).Tokens
+	plain := EstimateConcat([]string{"alpha", "beta", "gamma"}, " ", Options{Strategy: StrategyWeighted, Profile: ProfileOpenAI}).Tokens
+	if sep <= plain {
+		t.Fatalf("expected separators to add tokens, got %d vs %d", sep, plain)
+	}
+}
+
+func TestASCIIFastPathMatchesRuneSegmentation(t *testing.T) {
+	texts := []string{
+		"a",
+		"Hello, world! It's 2024.",
+		"user@example.com visited https://example.com/a?b=c&d=e at 3.14pm",
+		"func main() {\n\tfmt.Println(\"hi\")\n}\n\n\n",
+		"aGVsbG8gd29ybGQgdGhpcyBpcyBhIHRlc3Qgc3RyaW5nIGZvciBiYXNlNjQ=",
+		"x ... y --- z !!! 1,000,000 v1.2-rc3 abc123def",
+		"  \t\v\f\r\n  ",
+		"café 你好，world 👋🏽 \xff\xfe ok",
+	}
+	for _, name := range []string{"bible_kjv_en.txt", "faust_de.txt", "toxic_minified_json.txt", "mixed5_01_zh_en_de_fr_code.txt"} {
+		data, err := os.ReadFile(filepath.Join("datasets", "test", name))
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		texts = append(texts, string(data))
+	}
+
+	custom := defaultTokenXParams
+	custom.shortTokenThreshold = 0
+	custom.symbolsPerToken = 0.5
+	custom.whitespaceRunesPerToken = 2
+	custom.languageCharsPerToken = 3.1
+	quotes := defaultTokenXParams
+	quotes.classes = punctuationClasses(DefaultPunctuation + "\"'")
+
+	for _, params := range []tokenXParams{defaultTokenXParams, custom, quotes} {
+		for _, text := range texts {
+			gotTokens, gotStats := estimateTokenXWithStats(text, params)
+
+			// Reference: segment by ranging over runes and cost every segment rune by rune.
+			wantTokens, wantStats := 0, tokenXStats{}
+			start, segmentType := 0, tokenXSegmentTypeNone
+			for idx, r := range text {
+				current := params.classTable().typeAt(text, idx, r)
+				if idx > 0 && current != segmentType {
+					tokens, _ := estimateTokenXSegmentRunes(text[start:idx], params, &wantStats)
+					wantTokens += tokens
+					start = idx
+				}
+				segmentType = current
+			}
+			tokens, _ := estimateTokenXSegmentRunes(text[start:], params, &wantStats)
+			wantTokens += tokens
+
+			if gotTokens != wantTokens || gotStats != wantStats {
+				t.Fatalf("ASCII path diverged for %.40q: got %d %+v, want %d %+v", text, gotTokens, gotStats, wantTokens, wantStats)
+			}
+		}
+	}
+}
+
+func TestPunctuationClassCacheIsBounded(t *testing.T) {
+	for i := 0; i < 4*punctuationClassCacheSize; i++ {
+		punct := fmt.Sprintf("%s%d", DefaultPunctuation, i)
+		classes := punctuationClasses(punct)
+		if classes['.'] != tokenXSegmentTypePunctuation || classes['"'] == tokenXSegmentTypePunctuation {
+			t.Fatalf("%q: unexpected classification", punct)
+		}
+	}
+	entries := 0
+	punctuationClassCache.Range(func(_, _ any) bool { entries++; return true })
+	if entries > punctuationClassCacheSize || int(punctuationClassCount.Load()) != entries {
+		t.Fatalf("expected at most %d cached sets (counted %d), got %d", punctuationClassCacheSize, punctuationClassCount.Load(), entries)
+	}
+}
+
+func TestWeightsPunctuationIsConsistent(t *testing.T) {
+	text := `She said, "it's 'fine'" and "won't" he'd "quote" 'every' "word".`
+	countSegments := func(params tokenXParams) int {
+		n := 0
+		forEachTokenXSegment(text, params.classTable(), func(start, end int) { n++ })
+		return n
+	}
+
+	defaults := Weights{}.segmentation()
+	if got := punctuationClasses(DefaultPunctuation); *got != asciiSegmentTypes {
+		t.Fatal("DefaultPunctuation table differs from the built-in classification")
+	}
+	_, stats := estimateTokenXWithStats(text, defaults)
+	if stats.PunctRunes != 2 {
+		t.Fatalf("default punctuation counted %d runes, want 2 (quotes excluded)", stats.PunctRunes)
+	}
+
+	quoted := Weights{Punctuation: DefaultPunctuation + "\"'"}.segmentation()
+	_, quotedStats := estimateTokenXWithStats(text, quoted)
+	quoteRunes := strings.Count(text, `"`) + strings.Count(text, "'")
+	if quotedStats.PunctRunes != 2+quoteRunes {
+		t.Fatalf("custom punctuation counted %d runes, want %d", quotedStats.PunctRunes, 2+quoteRunes)
+	}
+	if countSegments(quoted) <= countSegments(defaults) {
+		t.Fatalf("quotes did not split segments: %d <= %d", countSegments(quoted), countSegments(defaults))
+	}
+
+	restricted := Weights{Punctuation: "."}.segmentation()
+	_, restrictedStats := estimateTokenXWithStats(text, restricted)
+	if restrictedStats.PunctRunes != 1 {
+		t.Fatalf("restricted punctuation counted %d runes, want 1", restrictedStats.PunctRunes)
+	}
+
+	weights := weightsForProfile(ProfileOpenAI)
+	base := EstimateText(text, Options{Strategy: StrategyWeighted, CustomWeights: &weights})
+	weights.Punctuation = DefaultPunctuation + "\"'"
+	custom := EstimateText(text, Options{Strategy: StrategyWeighted, CustomWeights: &weights})
+	// By default a quoted word is one mixed segment costed rune by rune; splitting the quotes
+	// off leaves plain words at one token each.
+	if custom.Tokens >= base.Tokens {
+		t.Fatalf("splitting quotes should lower the estimate: %d >= %d", custom.Tokens, base.Tokens)
+	}
+}
+
+func FuzzEstimateNonNegative(f *testing.F) {
+	seeds := []string{
+		"",
+		"hello world",
+		"你好，世界！",
+		"안녕하세요",
+		"👨‍👩‍👧‍👦🏽",
+		"\xff\xfe\xfd",
+		"{\"a\":[1,2,3]}",
+		"0123456789abcdef0123456789abcdef",
+		"aGVsbG8gd29ybGQ=",
+		"   \t\n\n",
+		"3.14 1,000 v1.2-rc3",
+	}
+	for _, seed := range seeds {
+		f.Add(seed, uint8(StrategyWeighted), uint8(ProfileOpenAI), 1.0, 0.0)
+	}
+	f.Add("x", uint8(StrategyZR), uint8(ProfileAuto), 1e308, 0.0)
+	f.Add("x", uint8(StrategyFast), uint8(ProfileClaude), -1.0, 0.0)
+	f.Add("x", uint8(StrategyUltraFast), uint8(ProfileGemini), math.NaN(), 0.0)
+	f.Add("hello, world", uint8(StrategyWeighted), uint8(ProfileOpenAI), 1.0, -2.5)
+
+	f.Fuzz(func(t *testing.T, text string, strategy, profile uint8, multiplier, baseFactor float64) {
+		opts := Options{
+			Strategy:         Strategy(strategy % uint8(StrategyZR+1)),
+			Profile:          Profile(profile % uint8(ProfileQwen+1)),
+			GlobalMultiplier: multiplier,
+			Explain:          true,
+		}
+		// Finite but arbitrary base factors, including negative ones, with the lower clamp off.
+		if baseFactor != 0 && !math.IsNaN(baseFactor) && !math.IsInf(baseFactor, 0) {
+			weights := WeightsForProfile(opts.Profile)
+			weights.BaseFactor = baseFactor
+			weights.ClampMin = 0
+			opts.CustomWeights = &weights
+		}
+		check := func(name string, res Result) {
+			if res.Tokens < 0 || res.MinTokens < 0 || res.MaxTokens < 0 {
+				t.Fatalf("%s(%q, %+v) returned negative tokens: %+v", name, text, opts, res)
+			}
+			if math.IsNaN(res.Confidence) || res.Confidence < 0 || res.Confidence > 1 {
+				t.Fatalf("%s(%q, %+v) returned confidence %v", name, text, opts, res.Confidence)
+			}
+			for _, item := range res.Breakdown {
+				if math.IsNaN(item.Tokens) || math.IsInf(item.Tokens, 0) || math.IsNaN(item.Weight) || math.IsInf(item.Weight, 0) {
+					t.Fatalf("%s(%q, %+v) returned non-finite breakdown %+v", name, text, opts, item)
+				}
+			}
+		}
+		check("EstimateText", EstimateText(text, opts))
+		check("EstimateBytes", EstimateBytes([]byte(text), opts))
+		check("EstimateOutput", EstimateOutput(text, opts))
+	})
+}
+
+func TestFastExplainReportsSampledRatios(t *testing.T) {
+	ratios := func(res Result) (cjk, punct float64) {
+		t.Helper()
+		found := 0
+		for _, item := range res.Breakdown {
+			if item.Tokens != 0 {
+				t.Fatalf("expected zero-token ratio entries, got %+v", item)
+			}
+			switch item.Category {
+			case fastCategoryCJKRatio:
+				cjk = item.BaseUnits
+				found++
+			case fastCategoryPunctRatio:
+				punct = item.BaseUnits
+				found++
+			}
+		}
+		if found != 2 {
+			t.Fatalf("expected both sampled ratios, got %+v", res.Breakdown)
+		}
+		return cjk, punct
+	}
+
+	opts := Options{Strategy: StrategyFast, Explain: true}
+	// 4 CJK runes and 6 ASCII runes, one of them punctuation.
+	cjk, punct := ratios(EstimateText("你好世界 hi, x", opts))
+	if math.Abs(cjk-0.4) > 1e-9 || math.Abs(punct-0.1) > 1e-9 {
+		t.Fatalf("expected ratios 0.4/0.1, got %v/%v", cjk, punct)
+	}
+	if cjk, _ := ratios(EstimateBytes([]byte(strings.Repeat("中文", 1000)), opts)); cjk != 1 {
+		t.Fatalf("expected an all-CJK sample, got %v", cjk)
+	}
+
+	acc := NewAccumulator(opts)
+	acc.Write([]byte("你好世界 hi, x"))
+	if cjk, _ := ratios(acc.Result()); math.Abs(cjk-0.4) > 1e-9 {
+		t.Fatalf("expected the accumulator to report the same CJK ratio, got %v", cjk)
+	}
+
+	if res := EstimateText("你好世界 hi, x", Options{Strategy: StrategyFast}); res.Breakdown != nil {
+		t.Fatalf("expected no breakdown without Explain, got %+v", res.Breakdown)
+	}
+}
+
+func TestBenchmarkStrategyReportsThroughput(t *testing.T) {
+	sample := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 50)
+	for _, strategy := range []Strategy{StrategyUltraFast, StrategyFast, StrategyWeighted, StrategyZR} {
+		nsPerOp, bytesPerSec := BenchmarkStrategy(strategy, sample)
+		if nsPerOp <= 0 || bytesPerSec <= 0 {
+			t.Fatalf("%v: expected positive timings, got %d ns/op, %v B/s", strategy, nsPerOp, bytesPerSec)
+		}
+		if want := float64(len(sample)) * 1e9 / float64(nsPerOp); math.Abs(bytesPerSec-want) > want*1e-9 {
+			t.Fatalf("%v: expected %v B/s from %d ns/op, got %v", strategy, want, nsPerOp, bytesPerSec)
+		}
+	}
+	if nsPerOp, bytesPerSec := BenchmarkStrategy(StrategyWeighted, ""); nsPerOp != 0 || bytesPerSec != 0 {
+		t.Fatalf("expected zero timings for an empty sample, got %d, %v", nsPerOp, bytesPerSec)
+	}
+}
+
+func TestWithEscalationUpgradesHardInputs(t *testing.T) {
+	prose := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 20)
+	dense := strings.Repeat(`{"id":[1,2],"ok":{}},`, 40)
+
+	disagree := EscalateOnDisagreement(defaultEscalationFraction)
+	if disagree(prose) {
+		t.Fatal("expected UltraFast and Fast to agree on English prose")
+	}
+	if !disagree(dense) {
+		t.Fatal("expected UltraFast and Fast to disagree on punctuation-dense JSON")
+	}
+	if disagree("") {
+		t.Fatal("expected empty text not to escalate")
+	}
+
+	est := WithEscalation(WithStrategy(nil, StrategyFast), WithStrategy(nil, StrategyWeighted), disagree)
+	for _, tc := range []struct {
+		text string
+		want Strategy
+	}{{prose, StrategyFast}, {dense, StrategyWeighted}} {
+		if got := est.EstimateText(tc.text, Options{}).Strategy; got != tc.want {
+			t.Fatalf("EstimateText: expected %v, got %v", tc.want, got)
+		}
+		if got := est.EstimateBytes([]byte(tc.text), Options{}).Strategy; got != tc.want {
+			t.Fatalf("EstimateBytes: expected %v, got %v", tc.want, got)
+		}
+		if got := est.EstimateInput(tc.text, ImageCounts{}, 1, Options{}).Strategy; got != tc.want {
+			t.Fatalf("EstimateInput: expected %v, got %v", tc.want, got)
+		}
+		if got := est.EstimateOutput(tc.text, Options{}).Strategy; got != tc.want {
+			t.Fatalf("EstimateOutput: expected %v, got %v", tc.want, got)
+		}
+	}
+
+	custom := WithEscalation(nil, WithStrategy(nil, StrategyZR), func(text string) bool { return strings.Contains(text, "{") })
+	if got := custom.EstimateText(`{"a":1}`, Options{}).Strategy; got != StrategyZR {
+		t.Fatalf("expected the caller predicate to escalate, got %v", got)
+	}
+}
+
+// countdownContext reports context.Canceled after its Err has been called n times.
+type countdownContext struct {
+	context.Context
+	n int
+}
+
+func (c *countdownContext) Err() error {
+	if c.n <= 0 {
+		return context.Canceled
+	}
+	c.n--
+	return nil
+}
+
+func TestEstimateTextCtxCancels(t *testing.T) {
+	text := strings.Repeat("hello, world 你好 ", 40000)
+	for _, strategy := range []Strategy{StrategyUltraFast, StrategyFast, StrategyWeighted, StrategyZR} {
+		opts := Options{Strategy: strategy, Explain: true}
+		res, err := EstimateTextCtx(context.Background(), text, opts)
+		if err != nil || res.Tokens != EstimateText(text, opts).Tokens {
+			t.Fatalf("%v: expected a live context to match EstimateText, got %+v, %v", strategy, res, err)
+		}
+
+		canceled, cancel := context.WithCancel(context.Background())
+		cancel()
+		if res, err := EstimateTextCtx(canceled, text, opts); !errors.Is(err, context.Canceled) || res.Tokens != 0 {
+			t.Fatalf("%v: expected context.Canceled and a zero result, got %+v, %v", strategy, res, err)
+		}
+	}
+
+	// Cancellation during segmentation stops Weighted and ZR part-way through the input.
+	for _, strategy := range []Strategy{StrategyWeighted, StrategyZR} {
+		ctx := &countdownContext{Context: context.Background(), n: 3}
+		if _, err := EstimateTextCtx(ctx, text, Options{Strategy: strategy}); !errors.Is(err, context.Canceled) {
+			t.Fatalf("%v: expected cancellation mid-segmentation, got %v", strategy, err)
+		}
+	}
+}
+
+func TestGeminiNumericCostScalesWithDigits(t *testing.T) {
+	opts := Options{Strategy: StrategyWeighted, Profile: ProfileGemini}
+	prev := 0
+	for _, number := range []string{"42", "4200000", "4200000000", "420000000000000"} {
+		got := EstimateText(number, opts).Tokens
+		if got <= prev {
+			t.Fatalf("expected %q to cost more than %d tokens, got %d", number, prev, got)
+		}
+		prev = got
+	}
+	if short, long := EstimateText("42", opts).Tokens, EstimateText("4200000000", opts).Tokens; long < 2*short {
+		t.Fatalf("expected a 10-digit number to cost at least twice a 2-digit one, got %d vs %d", long, short)
+	}
+}
+
+func TestSetDefaults(t *testing.T) {
+	t.Cleanup(func() { SetDefaults(Options{}) })
+	text := "Hello, 世界! {\"k\": [1, 2, 3]}"
+
+	SetDefaults(Options{Strategy: StrategyZR, Profile: ProfileClaude})
+	res := EstimateText(text, Options{})
+	if res.Strategy != StrategyZR || res.Profile != ProfileClaude {
+		t.Fatalf("expected defaults ZR/claude, got %v/%v", res.Strategy, res.Profile)
+	}
+	if got := EstimateBytes([]byte(text), Options{}).Strategy; got != StrategyZR {
+		t.Fatalf("expected EstimateBytes to use the default strategy, got %v", got)
+	}
+	if got := NewAccumulator(Options{}).strategy; got != StrategyZR {
+		t.Fatalf("expected Accumulator to use the default strategy, got %v", got)
+	}
+
+	// Explicit options and model hints still win.
+	res = EstimateText(text, Options{Strategy: StrategyFast, Model: "gemini-1.5-pro"})
+	if res.Strategy != StrategyFast || res.Profile != ProfileGemini {
+		t.Fatalf("expected explicit fast/gemini, got %v/%v", res.Strategy, res.Profile)
+	}
+
+	SetDefaults(Options{})
+	res = EstimateText(text, Options{})
+	if res.Strategy != StrategyWeighted || res.Profile != ProfileOpenAI {
+		t.Fatalf("expected built-in resolution after reset, got %v/%v", res.Strategy, res.Profile)
+	}
+	if got := EstimateBytes([]byte(text), Options{}).Strategy; got != StrategyUltraFast {
+		t.Fatalf("expected UltraFast for bytes after reset, got %v", got)
+	}
+}
+
+func TestStatsCountsEveryCategory(t *testing.T) {
+	text := "Hi 你好, 안녕 42 🙂\xff \"don't\" é"
+	got := Stats(text)
+	want := InputStats{
+		Bytes:        len(text),
+		Runes:        24,
+		Letters:      7, // H i d o n t é
+		Digits:       2,
+		CJK:          2,
+		Hangul:       2,
+		Punctuation:  1,
+		Whitespace:   6,
+		Emoji:        1,
+		Other:        3, // " ' "
+		InvalidBytes: 1,
+		Words:        5, // Hi 42 don t é
+	}
+	if got != want {
+		t.Fatalf("Stats(%q):\n got %+v\nwant %+v", text, got, want)
+	}
+	if sum := got.Letters + got.Digits + got.CJK + got.Hangul + got.Punctuation + got.Whitespace + got.Emoji + got.Other; sum != got.Runes {
+		t.Fatalf("categories sum to %d, want %d runes", sum, got.Runes)
+	}
+	if (Stats("") != InputStats{}) {
+		t.Fatal("expected zero stats for empty input")
+	}
+}
+
+func TestCalibrateMultiplier(t *testing.T) {
+	samples := []string{
+		"The quick brown fox jumps over the lazy dog.",
+		"func main() { fmt.Println(\"hello\") }",
+		"你好，世界。这是一个测试。",
+	}
+	opts := Options{Strategy: StrategyWeighted, Profile: ProfileClaude}
+	estimated := 0
+	actual := make([]int, len(samples))
+	for i, sample := range samples {
+		tokens := EstimateText(sample, opts).Tokens
+		estimated += tokens
+		actual[i] = tokens * 3 / 2
+	}
+	observed := 0
+	for _, n := range actual {
+		observed += n
+	}
+
+	got := CalibrateMultiplier(samples, actual, opts.Strategy, opts.Profile)
+	if want := float64(observed) / float64(estimated); math.Abs(got-want) > 1e-12 {
+		t.Fatalf("CalibrateMultiplier = %v, want %v", got, want)
+	}
+	opts.GlobalMultiplier = got
+	calibrated := 0
+	for _, sample := range samples {
+		calibrated += EstimateText(sample, opts).Tokens
+	}
+	if calibrated < observed || calibrated > observed+len(samples) {
+		t.Fatalf("calibrated total %d, want about %d", calibrated, observed)
+	}
+
+	first := EstimateText(samples[0], Options{Strategy: StrategyWeighted, Profile: ProfileClaude}).Tokens
+	if got := CalibrateMultiplier(samples, actual[:1], opts.Strategy, opts.Profile); got != float64(actual[0])/float64(first) {
+		t.Fatalf("expected extra samples to be ignored, got %v", got)
+	}
+	for _, tc := range []struct {
+		samples []string
+		actual  []int
+	}{
+		{nil, nil},
+		{[]string{""}, []int{5}},
+		{[]string{"hello"}, []int{-1}},
+		{[]string{"hello"}, []int{0}},
+	} {
+		if got := CalibrateMultiplier(tc.samples, tc.actual, StrategyWeighted, ProfileOpenAI); got != 1.0 {
+			t.Fatalf("CalibrateMultiplier(%q, %v) = %v, want 1.0", tc.samples, tc.actual, got)
+		}
+	}
+}
+
+func TestRepeatedRunsUseRepeatedDensity(t *testing.T) {
+	for _, text := range []string{strings.Repeat("a", 10000), strings.Repeat("=", 10000)} {
+		runes := utf8.RuneCountInString(text)
+		repeated := int(math.Ceil(float64(runes) / repeatedRunCharsPerToken))
+		if got, _ := estimateTokenXWithStats(text, defaultTokenXParams); got != repeated {
+			t.Fatalf("%q...: expected base %d, got %d", text[:4], repeated, got)
+		}
+		for _, strategy := range []Strategy{StrategyWeighted, StrategyZR} {
+			got := EstimateText(text, Options{Strategy: strategy}).Tokens
+			if got > runes/5 {
+				t.Fatalf("%v on %q...: expected at most %d tokens, got %d", strategy, text[:4], runes/5, got)
+			}
+		}
+	}
+
+	baseTokens := func(text string) int {
+		tokens, _ := estimateTokenXWithStats(text, defaultTokenXParams)
+		return tokens
+	}
+	// Short repeats and mixed runs keep the regular densities.
+	short := strings.Repeat("a", repeatedRunMinRunes-1)
+	if got, want := baseTokens(short), int(math.Ceil(float64(len(short))/defaultCharsPerToken)); got != want {
+		t.Fatalf("expected %d for a short repeat, got %d", want, got)
+	}
+	if got, want := baseTokens(strings.Repeat("ab", 5000)), int(math.Ceil(10000/defaultCharsPerToken)); got != want {
+		t.Fatalf("expected %d for an alternating run, got %d", want, got)
+	}
+}
+
+type panicEstimator struct{}
+
+func (panicEstimator) EstimateBytes([]byte, Options) Result { panic("bytes") }
+func (panicEstimator) EstimateText(string, Options) Result  { panic("text") }
+func (panicEstimator) EstimateInput(string, ImageCounts, int, Options) Result {
+	panic("input")
+}
+func (panicEstimator) EstimateOutput(string, Options) Result { panic("output") }
+
+func TestWithFallbackRecoversPanics(t *testing.T) {
+	var logged bytes.Buffer
+	shared := []string{"shared"}
+	est := WithFallbackOptions(panicEstimator{}, FallbackConfig{
+		Fallback: func() Result {
+			return Result{Tokens: 7, Strategy: StrategyUltraFast, Warnings: shared}
+		},
+		OnPanic: func(recovered any, stack []byte) {
+			fmt.Fprintf(&logged, "recovered estimator panic: %v\n%s", recovered, stack)
+		},
+	})
+	var observed []Result
+	opts := Options{Observer: func(res Result) { observed = append(observed, res) }}
+	results := []Result{
+		est.EstimateBytes([]byte("x"), opts),
+		est.EstimateText("x", opts),
+		est.EstimateInput("x", ImageCounts{}, 1, opts),
+		est.EstimateOutput("x", opts),
+	}
+	for i, want := range []string{"bytes", "text", "input", "output"} {
+		res := results[i]
+		if res.Tokens != 7 || len(res.Warnings) != 2 || !strings.Contains(res.Warnings[1], want) {
+			t.Fatalf("%s: expected the fallback with a panic warning, got %+v", want, res)
+		}
+		if !strings.Contains(logged.String(), "recovered estimator panic: "+want) {
+			t.Fatalf("%s: expected the panic to be logged, got %q", want, logged.String())
+		}
+	}
+	if len(observed) != 4 || len(shared) != 1 || cap(shared) != 1 {
+		t.Fatalf("expected 4 observed results and an untouched shared slice, got %d, %v", len(observed), shared)
+	}
+
+	if res := WithFallback(panicEstimator{}, nil).EstimateText("x", Options{}); res.Tokens != 0 || len(res.Warnings) != 1 {
+		t.Fatalf("expected a zero Result with a warning for a nil fallback, got %+v", res)
+	}
+	text := "no panic here"
+	if got, want := WithFallback(nil, nil).EstimateText(text, Options{}), EstimateText(text, Options{}); got.Tokens != want.Tokens || len(got.Warnings) != 0 {
+		t.Fatalf("expected the inner estimate, got %+v want %+v", got, want)
+	}
+}
+
+func TestWithFallbackRecoversObserverPanics(t *testing.T) {
+	var recovered []any
+	est := WithFallbackOptions(nil, FallbackConfig{
+		Fallback: func() Result { return Result{Tokens: 3} },
+		OnPanic:  func(r any, _ []byte) { recovered = append(recovered, r) },
+	})
+	calls := 0
+	opts := Options{Observer: func(Result) {
+		calls++
+		panic("observer")
+	}}
+	// The Observer panics on the inner estimate and again on the fallback Result; neither escapes.
+	res := est.EstimateText("x", opts)
+	if res.Tokens != 3 || len(res.Warnings) != 1 || !strings.Contains(res.Warnings[0], "observer") {
+		t.Fatalf("expected the fallback with the observer panic, got %+v", res)
+	}
+	if calls != 2 || len(recovered) != 2 {
+		t.Fatalf("expected 2 observer calls and 2 recovered panics, got %d and %v", calls, recovered)
+	}
+
+	// Without OnPanic the panics are dropped rather than logged.
+	if res := WithFallback(nil, nil).EstimateText("x", opts); res.Tokens != 0 || len(res.Warnings) != 1 {
+		t.Fatalf("expected a zero Result with a warning, got %+v", res)
+	}
+}
+
+func TestNumericSegmentsCostAlikeAcrossStrategies(t *testing.T) {
+	// Segment costs; strategy's TestEstimateZRNumericSegmentGroupsDigits pins the same values.
+	segments := map[string]int{
+		"7":                    1,
+		"2024":                 2,
+		"3.14":                 3,
+		"1,234,567":            5,
+		"12345678901234567890": 7,
+	}
+	for input, want := range segments {
+		var stats tokenXStats
+		if got := estimateTokenXSegment(input, defaultTokenXParams, &stats); got != want {
+			t.Fatalf("%q: expected Weighted segment cost %d, got %d", input, want, got)
+		}
+	}
+
+	for _, input := range []string{"3.14", "1,234,567", "pi is 3.14159", "v1.2.3", "2024-01-01", "id 12345678901234567890"} {
+		weighted, _ := estimateTokenXWithStats(input, defaultTokenXParams)
+		if zr := zrstrategy.EstimateZRDetail(input).BaseTokens; zr != weighted {
+			t.Fatalf("%q: expected ZR base tokens to match Weighted %d, got %d", input, weighted, zr)
+		}
+	}
+}
+
+func TestSegmentCategoriesMatchCostingBranch(t *testing.T) {
+	params := defaultTokenXParams
+	cases := []struct {
+		segment  string
+		category string
+		tokens   int
+	}{
+		{"   ", SegmentCategoryWhitespace, 0},
+		{"\xff\xfe", SegmentCategoryInvalid, 2},
+		{"\U0001F44D\U0001F3FD\U0001F680", SegmentCategoryEmoji, 2},
+		{"你好世界", SegmentCategoryCJK, 4},
+		{"안녕하세요", SegmentCategoryHangul, 5},
+		{"1,234,567", SegmentCategoryNumeric, 5},
+		{"abc", SegmentCategoryShort, 1},
+		{"x\u0301\u0302y", SegmentCategoryShort, 1}, // 4 runes, 2 characters
+		{strings.Repeat("a", 40), SegmentCategoryRepeated, 5},
+		{strings.Repeat("=", 40), SegmentCategoryRepeated, 5},
+		{"});//", SegmentCategoryPunct, 3},
+		{"aGVsbG9Xb3JsZEZvb0Jhcg", SegmentCategoryBase64, int(math.Ceil(22 / base64CharsPerToken))},
+		{"tokenization", SegmentCategoryAlnum, 2},
+	}
+	for _, tc := range cases {
+		var stats tokenXStats
+		tokens, category := costTokenXSegment(tc.segment, params, &stats)
+		if category != tc.category || tokens != tc.tokens {
+			t.Fatalf("%q: expected %s/%d, got %s/%d", tc.segment, tc.category, tc.tokens, category, tokens)
+		}
+		// The rune path must label ASCII segments as the bytewise fast path does.
+		if isASCII(tc.segment) {
+			runeTokens, runeCategory := estimateTokenXSegmentRunes(tc.segment, params, &stats)
+			if runeCategory != category || runeTokens != tokens {
+				t.Fatalf("%q: rune path gave %s/%d, ASCII path %s/%d", tc.segment, runeCategory, runeTokens, category, tokens)
+			}
+		}
+	}
+
+	// Segments reports the same category and tokens for each segment of a mixed text.
+	text := "Status: 👍🏽 " + strings.Repeat("-", 32) + " aGVsbG9Xb3JsZEZvb0Jhcg 你好"
+	for _, seg := range Segments(text) {
+		var stats tokenXStats
+		tokens, category := costTokenXSegment(text[seg.Start:seg.End], params, &stats)
+		if seg.Category != category || seg.Tokens != tokens {
+			t.Fatalf("%q: Segments reported %s/%d, costing %s/%d", text[seg.Start:seg.End], seg.Category, seg.Tokens, category, tokens)
+		}
+	}
+}
@@ -1,6 +1,66 @@
 package tokenest
 
-const defaultCharsPerToken = 6.0
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/EZ-Api/tokenest/internal/cjk"
+)
+
+const (
+	defaultCharsPerToken = 6.0
+
+	// defaultLanguageMinMatchRatio is the minimum fraction of a segment's runes that must belong
+	// to a language's rune set, so a single loanword diacritic (e.g. "café") does not switch
+	// the whole word to that language's chars-per-token.
+	defaultLanguageMinMatchRatio = 0.3
+	// germanMinMatchRatio admits ordinary German words, which often carry a single umlaut or ß
+	// in 5 to 10 letters ("schön", "Mädchen", "Fußball").
+	germanMinMatchRatio = 0.09
+
+	// base64CharsPerToken is the observed o200k density of random base64 (about 1.46 chars per
+	// token on datasets/test/toxic_base64.txt and adversary_weighted_05_base64.txt).
+	base64CharsPerToken = 1.46
+	// base64MinSegmentRunes is the shortest alphanumeric segment considered base64-like.
+	base64MinSegmentRunes = 12
+	// base64MinCaseRatio is the minimum share of both upper- and lower-case letters. Random
+	// base64 is ~42% each; camelCase identifiers rarely exceed 25% upper case.
+	base64MinCaseRatio = 0.25
+	// base64MaxLowerRun is the maximum average length of lower-case runs. Random base64
+	// averages ~1.7; words and identifiers average 3 or more.
+	base64MaxLowerRun = 2.5
+
+	// repeatedRunCharsPerToken is the density of a segment that repeats one character, such as
+	// "aaaa..." or a "=====" separator. BPE vocabularies merge such runs into long tokens:
+	// cl100k_base and o200k_base encode runs of a letter at about 8 characters per token and runs
+	// of "=" or "-" at more, while the alphanumeric and punctuation densities charge 2 to 6.
+	repeatedRunCharsPerToken = 8.0
+	// repeatedRunMinRunes is the shortest segment costed as a repeated run; shorter repeats such
+	// as "...." or "!!!!" keep the regular densities.
+	repeatedRunMinRunes = 16
+)
+
+// isRepeatedRun reports whether segment, of runeCount runes, is at least repeatedRunMinRunes
+// repetitions of a single character.
+func isRepeatedRun(segment string, runeCount int) bool {
+	if runeCount < repeatedRunMinRunes {
+		return false
+	}
+	first, size := utf8.DecodeRuneInString(segment)
+	for _, r := range segment[size:] {
+		if r != first {
+			return false
+		}
+	}
+	return true
+}
 
 func isNumericSegment(segment string) bool {
 	hasDigit := false
@@ -24,10 +84,22 @@ func isNumericSegment(segment string) bool {
 }
 
 func isAlphanumericSegment(segment string) bool {
-	for _, r := range segment {
+	var masks map[rune]uint64
+	for i, r := range segment {
 		if isLatinAlphaNum(r) {
 			continue
 		}
+		// A combining mark belongs to the character before it (see characterCount).
+		if i > 0 && isCombiningMark(r) {
+			continue
+		}
+		// Letters of a language set beyond Latin-1, such as Polish "ł" or a registered language's.
+		if masks == nil {
+			masks = currentLanguages().masks
+		}
+		if masks[r] != 0 {
+			continue
+		}
 		return false
 	}
 	return true
@@ -49,57 +121,195 @@ func isLatinAlphaNum(r rune) bool {
 	return false
 }
 
-func isCJKSegment(segment string) bool {
-	if segment == "" {
+// base64Class buckets ASCII runes for base64 detection: 1 upper, 2 lower, 3 digit, 4 '+', '/',
+// or '=', and 0 for anything outside the base64 alphabet.
+func base64Class(r rune) uint8 {
+	switch {
+	case r >= 'A' && r <= 'Z':
+		return 1
+	case r >= 'a' && r <= 'z':
+		return 2
+	case r >= '0' && r <= '9':
+		return 3
+	case r == '+' || r == '/' || r == '=':
+		return 4
+	default:
+		return 0
+	}
+}
+
+// isBase64LikeSegment reports alphanumeric segments that look like a slice of a base64 blob:
+// long, rich in both cases, and without the long lower-case runs of words and identifiers.
+func isBase64LikeSegment(segment string) bool {
+	if len(segment) < base64MinSegmentRunes {
 		return false
 	}
-	for _, r := range segment {
-		if !isCJKRune(r) {
+	var run base64Run
+	for i := 0; i < len(segment); i++ {
+		class := base64Class(rune(segment[i]))
+		if class == 0 || class == 4 {
 			return false
 		}
+		run.add(class)
 	}
-	return true
+	return run.qualifies(base64MinSegmentRunes)
+}
+
+// base64Run accumulates statistics for a run of base64-alphabet runes.
+type base64Run struct {
+	length    int
+	upper     int
+	lower     int
+	lowerRuns int
+	symbols   int
+	prev      uint8
+}
+
+func (r *base64Run) add(class uint8) {
+	switch class {
+	case 1:
+		r.upper++
+	case 2:
+		r.lower++
+		if r.prev != 2 {
+			r.lowerRuns++
+		}
+	case 4:
+		r.symbols++
+	}
+	r.prev = class
+	r.length++
+}
+
+func (r *base64Run) qualifies(minLength int) bool {
+	if r.length < minLength || r.lowerRuns == 0 {
+		return false
+	}
+	n := float64(r.length)
+	return float64(r.upper)/n >= base64MinCaseRatio &&
+		float64(r.lower)/n >= base64MinCaseRatio &&
+		float64(r.lower)/float64(r.lowerRuns) <= base64MaxLowerRun
+}
+
+// RuneRange is an inclusive range of runes.
+type RuneRange struct {
+	Lo rune
+	Hi rune
+}
+
+func inRuneRanges(r rune, ranges []RuneRange) bool {
+	for _, rr := range ranges {
+		if r >= rr.Lo && r <= rr.Hi {
+			return true
+		}
+	}
+	return false
 }
 
 func isCJKRune(r rune) bool {
-	switch {
-	case r >= 0x4E00 && r <= 0x9FFF:
-		return true
-	case r >= 0x3400 && r <= 0x4DBF:
-		return true
-	case r >= 0x3000 && r <= 0x303F:
-		return true
-	case r >= 0xFF00 && r <= 0xFFEF:
-		return true
-	case r >= 0x30A0 && r <= 0x30FF:
-		return true
-	case r >= 0x2E80 && r <= 0x2EFF:
-		return true
-	case r >= 0x31C0 && r <= 0x31EF:
-		return true
-	case r >= 0x3200 && r <= 0x32FF:
-		return true
-	case r >= 0x3300 && r <= 0x33FF:
-		return true
-	case r >= 0xAC00 && r <= 0xD7AF:
-		return true
-	case r >= 0x1100 && r <= 0x11FF:
-		return true
-	case r >= 0x3130 && r <= 0x318F:
-		return true
-	case r >= 0xA960 && r <= 0xA97F:
-		return true
-	case r >= 0xD7B0 && r <= 0xD7FF:
-		return true
-	default:
+	return cjk.IsRune(r)
+}
+
+// DefaultCJKRanges returns the built-in CJK rune ranges: Han ideographs, CJK symbols and
+// punctuation, fullwidth forms, Katakana, radicals, strokes, compatibility blocks, and Hangul.
+// Filter the result into Options.CJKRanges to drop blocks (e.g. Hangul for a tokenizer that
+// splits Korean like Latin text).
+func DefaultCJKRanges() []RuneRange {
+	table := cjk.Ranges()
+	ranges := make([]RuneRange, len(table))
+	for i, rr := range table {
+		ranges[i] = RuneRange{Lo: rr.Lo, Hi: rr.Hi}
+	}
+	return ranges
+}
+
+// isCJKPunct reports full-width punctuation and symbols from the CJK Symbols and Punctuation
+// block (、。「」【】) and the Halfwidth and Fullwidth Forms block (，！？：（）). Fullwidth letters
+// and digits, and CJK iteration marks such as 々, are not punctuation.
+func isCJKPunct(r rune) bool {
+	if r < 0x3001 {
 		return false
 	}
+	if (r <= 0x303F) || (r >= 0xFF01 && r <= 0xFF65) {
+		return unicode.IsPunct(r) || unicode.IsSymbol(r)
+	}
+	return false
+}
+
+// isCJKPunctSegment reports whether segment consists only of CJK punctuation.
+func isCJKPunctSegment(segment string) bool {
+	if segment == "" {
+		return false
+	}
+	for _, r := range segment {
+		if !isCJKPunct(r) {
+			return false
+		}
+	}
+	return true
 }
 
 func isAtSign(r rune) bool {
 	return r == '@'
 }
 
+// isInvalidByteAt reports whether r, decoded by ranging over text at byte offset idx, stands for
+// a single byte that is not valid UTF-8 rather than a literal U+FFFD. Every strategy costs such
+// bytes as one symbol unit each, since byte-level BPE tokenizers fall back to one token per
+// unmergeable byte.
+func isInvalidByteAt(text string, idx int, r rune) bool {
+	if r != utf8.RuneError {
+		return false
+	}
+	_, size := utf8.DecodeRuneInString(text[idx:])
+	return size == 1
+}
+
+// isInvalidUTF8Segment reports whether segment is a run of invalid bytes. Segmentation never mixes
+// invalid bytes with valid runes, so checking the first byte is enough.
+func isInvalidUTF8Segment(segment string) bool {
+	r, size := utf8.DecodeRuneInString(segment)
+	return r == utf8.RuneError && size == 1
+}
+
+// countURLDelims counts URL delimiter runes in text. '.' only counts inside words with URL
+// context (a "://" scheme or a leading "www."), so decimals, versions, IPs, and dotted names
+// such as "3.14", "1.0.0", and "a.b.c" contribute nothing.
+func countURLDelims(text string) int {
+	count := 0
+	start := -1
+	for i, r := range text {
+		if unicode.IsSpace(r) {
+			if start >= 0 {
+				count += countWordURLDelims(text[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		count += countWordURLDelims(text[start:])
+	}
+	return count
+}
+
+func countWordURLDelims(word string) int {
+	urlContext := strings.Contains(word, "://") || (len(word) >= 4 && strings.EqualFold(word[:4], "www."))
+	count := 0
+	for _, r := range word {
+		if r == '.' && !urlContext {
+			continue
+		}
+		if isURLDelim(r) {
+			count++
+		}
+	}
+	return count
+}
+
 func isURLDelim(r rune) bool {
 	switch r {
 	case ':', '/', '.', '?', '&', '=', '#', '%':
@@ -109,6 +319,27 @@ func isURLDelim(r rune) bool {
 	}
 }
 
+// countMathSymbols counts math operator runes in text. A '-' between two letters is a
+// word-internal hyphen ("state-of-the-art") and a '/' touching a letter is a path or "and/or"
+// separator ("a/b/c"); both stay plain punctuation. Standalone operators ("x = y + z") count.
+func countMathSymbols(text string) int {
+	count := 0
+	prev := rune(-1)
+	for i, r := range text {
+		if isMathSymbol(r) {
+			next, _ := utf8.DecodeRuneInString(text[i+utf8.RuneLen(r):])
+			switch {
+			case r == '-' && unicode.IsLetter(prev) && unicode.IsLetter(next):
+			case r == '/' && (unicode.IsLetter(prev) || unicode.IsLetter(next)):
+			default:
+				count++
+			}
+		}
+		prev = r
+	}
+	return count
+}
+
 func isMathSymbol(r rune) bool {
 	switch r {
 	case '+', '-', '*', '/', '=', '^', '<', '>':
@@ -118,8 +349,62 @@ func isMathSymbol(r rune) bool {
 	}
 }
 
+const (
+	zeroWidthJoiner     = '\u200D'
+	variationSelector16 = '\uFE0F'
+)
+
+// isEmojiComponent reports runes that attach to a preceding emoji rather than forming their own:
+// the zero-width joiner, the emoji variation selector, and Fitzpatrick skin-tone modifiers.
+func isEmojiComponent(r rune) bool {
+	return r == zeroWidthJoiner || r == variationSelector16 || (r >= 0x1F3FB && r <= 0x1F3FF)
+}
+
+// emojiClusters counts visual emoji in segment, grouping ZWJ sequences, skin-tone modifiers,
+// and variation selectors with their base emoji, and pairing regional indicators into flags
+// ("🇺🇸🇯🇵" is two). emojiOnly is true when every rune belongs to an emoji cluster.
+func emojiClusters(segment string) (clusters int, emojiOnly bool) {
+	emojiOnly = segment != ""
+	joined := false
+	openFlag := false
+	for _, r := range segment {
+		switch {
+		case isEmojiComponent(r):
+			if r == zeroWidthJoiner {
+				joined = true
+			}
+			continue
+		case isRegionalIndicator(r):
+			// The second indicator of a pair completes the flag opened by the first.
+			if !openFlag && !joined {
+				clusters++
+			}
+			openFlag = !openFlag
+			joined = false
+			continue
+		case isEmoji(r):
+			if !joined {
+				clusters++
+			}
+		default:
+			emojiOnly = false
+		}
+		joined = false
+		openFlag = false
+	}
+	return clusters, emojiOnly && clusters > 0
+}
+
+// isRegionalIndicator reports the regional indicator symbols U+1F1E6-1F1FF, which pair up into
+// flag emoji.
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
 func isEmoji(r rune) bool {
 	switch {
+	case isRegionalIndicator(r):
+		return true
 	case r >= 0x1F300 && r <= 0x1F5FF:
 		return true
 	case r >= 0x1F600 && r <= 0x1F64F:
@@ -142,31 +427,162 @@ func isEmoji(r rune) bool {
 }
 
 func getLanguageSpecificCharsPerToken(segment string) float64 {
-	for _, cfg := range defaultLanguageConfigs {
-		if cfg.matches(segment) {
+	// Language sets only contain non-ASCII runes, so pure-ASCII segments never match.
+	if isASCII(segment) {
+		return 0
+	}
+
+	// One pass counts matches for every language; the first language over its ratio wins.
+	table := currentLanguages()
+	var matched [maxLanguages]int
+	total := 0
+	for i, r := range segment {
+		if i > 0 && isCombiningMark(r) {
+			continue
+		}
+		total++
+		if r < utf8.RuneSelf {
+			continue
+		}
+		for mask := table.masks[r]; mask != 0; mask &= mask - 1 {
+			matched[bits.TrailingZeros64(mask)]++
+		}
+	}
+	for i, cfg := range table.configs {
+		if matched[i] > 0 && float64(matched[i])/float64(total) >= cfg.matchRatio() {
 			return cfg.avgCharsPerToken
 		}
 	}
 	return 0
 }
 
+// languageCharsPerToken returns the chars-per-token for a document language hint such as "de"
+// or "fr-FR". English returns fallback, disabling the accent heuristic; unknown or empty codes
+// return 0, leaving the per-segment heuristic in place.
+func languageCharsPerToken(language string, fallback float64) float64 {
+	if i := strings.IndexAny(language, "-_"); i >= 0 {
+		language = language[:i]
+	}
+	language = strings.ToLower(language)
+	if language == "en" {
+		return fallback
+	}
+	for _, cfg := range currentLanguages().configs {
+		if cfg.code != "" && cfg.code == language {
+			return cfg.avgCharsPerToken
+		}
+	}
+	return 0
+}
+
+// characterCount returns the rune count of segment with each combining mark (U+0300-U+036F)
+// after the first rune folded into the character it follows, so "e" + U+0301 counts as one
+// character like "é" and a stack of Vietnamese tone and vowel marks adds nothing.
+func characterCount(segment string) int {
+	if !hasCombiningMark(segment) {
+		return utf8.RuneCountInString(segment)
+	}
+	n := 0
+	for i, r := range segment {
+		if i > 0 && isCombiningMark(r) {
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
 type languageConfig struct {
+	// code is the ISO 639-1 code accepted by Options.Language.
+	code             string
 	avgCharsPerToken float64
-	set              map[rune]struct{}
+	// minMatchRatio overrides defaultLanguageMinMatchRatio when > 0.
+	minMatchRatio float64
+	set           map[rune]struct{}
 }
 
-func (c languageConfig) matches(segment string) bool {
-	for _, r := range segment {
-		if _, ok := c.set[r]; ok {
-			return true
+// matchRatio returns the minimum share of a segment's runes that must be in c.set.
+func (c languageConfig) matchRatio() float64 {
+	if c.minMatchRatio > 0 {
+		return c.minMatchRatio
+	}
+	return defaultLanguageMinMatchRatio
+}
+
+// maxLanguages is the number of language configs a languageTable can hold, one mask bit each.
+const maxLanguages = 64
+
+// languageTable is an immutable snapshot of the language configs in match order, with masks
+// mapping every rune of their sets to a bitmask of the configs containing it (bit i for
+// configs[i]), so a segment is matched against all languages with one lookup per rune.
+// fingerprint hashes the configs, so cache keys change whenever registration changes estimates.
+type languageTable struct {
+	configs     []languageConfig
+	masks       map[rune]uint64
+	fingerprint uint64
+}
+
+func newLanguageTable(configs []languageConfig) *languageTable {
+	masks := make(map[rune]uint64)
+	for i, cfg := range configs {
+		for r := range cfg.set {
+			masks[r] |= 1 << i
 		}
 	}
-	return false
+	return &languageTable{configs: configs, masks: masks, fingerprint: languageFingerprint(configs)}
+}
+
+// languageFingerprint hashes configs in match order, with each rune set sorted, so equal tables
+// hash alike in every process.
+func languageFingerprint(configs []languageConfig) uint64 {
+	h := fnv.New64a()
+	writeUint64(h, uint64(len(configs)))
+	for _, cfg := range configs {
+		writeString(h, cfg.code)
+		writeUint64(h, math.Float64bits(cfg.avgCharsPerToken))
+		writeUint64(h, math.Float64bits(cfg.matchRatio()))
+		runes := make([]rune, 0, len(cfg.set))
+		for r := range cfg.set {
+			runes = append(runes, r)
+		}
+		slices.Sort(runes)
+		writeUint64(h, uint64(len(runes)))
+		for _, r := range runes {
+			writeUint64(h, uint64(r))
+		}
+	}
+	return h.Sum64()
+}
+
+// languages holds the current languageTable. RegisterLanguage replaces the snapshot under mu;
+// estimates load it without locking, so concurrent estimates share it safely.
+var languages struct {
+	mu    sync.Mutex
+	table atomic.Pointer[languageTable]
+}
+
+func init() {
+	languages.table.Store(newLanguageTable(defaultLanguageConfigs[:]))
+}
+
+func currentLanguages() *languageTable {
+	return languages.table.Load()
 }
 
-var defaultLanguageConfigs = []languageConfig{
+var defaultLanguageConfigs = [...]languageConfig{
 	{
+		code:             "de",
 		avgCharsPerToken: 3,
+		minMatchRatio:    germanMinMatchRatio,
 		set: map[rune]struct{}{
 			'\u00E4': {},
 			'\u00F6': {},
@@ -176,6 +592,7 @@ var defaultLanguageConfigs = []languageConfig{
 		},
 	},
 	{
+		code:             "fr",
 		avgCharsPerToken: 3,
 		set: map[rune]struct{}{
 			'\u00E9': {},
@@ -202,6 +619,7 @@ var defaultLanguageConfigs = []languageConfig{
 		},
 	},
 	{
+		code:             "pl",
 		avgCharsPerToken: 3.5,
 		set: map[rune]struct{}{
 			'\u0105': {},
@@ -0,0 +1,83 @@
+package tokenest
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// PerToolOverhead covers the framing providers add around each tool definition when they render
+// the tools array into the prompt (namespace/function wrappers and separators), and
+// ToolsArrayOverhead the framing around the whole array. Both are the gpt-4o values (func_init
+// and func_end) of num_tokens_for_tools in the OpenAI Cookbook's "How to count tokens with
+// tiktoken", which were measured against the prompt tokens the API reports.
+const (
+	PerToolOverhead    = 7
+	ToolsArrayOverhead = 12
+)
+
+// EstimateTools estimates the prompt cost of a serialized "tools" array of function definitions
+// (JSON Schema). Valid JSON is compacted first, since providers re-render the schema rather than
+// sending the caller's indentation; invalid JSON is estimated as-is. A top-level array counts one
+// tool per element and any other value counts as a single tool, each adding PerToolOverhead, and
+// any tools add ToolsArrayOverhead once.
+//
+// Schemas are dense in braces, quotes, and type keywords, so StrategyAuto uses Weighted
+// regardless of size, and Weighted uses the profile's weights refitted on code and JSON unless
+// Options.CustomWeights is set; explicit strategies are honored.
+func EstimateTools(tools []byte, opts Options) Result {
+	multiplier := opts.GlobalMultiplier
+	opts.GlobalMultiplier = 1.0
+	observer := opts.Observer
+	opts.Observer = nil
+	opts.Strategy = defaultStrategy(opts.Strategy)
+	if opts.Strategy == StrategyAuto {
+		opts.Strategy = StrategyWeighted
+	}
+
+	// The profile clause of Strict is checked before the code weights replace the profile's.
+	strictErr := opts.strictError(opts.Strategy)
+	if opts.Strategy == StrategyWeighted && opts.CustomWeights == nil {
+		weights := codeWeightsForProfile(resolveProfile(opts))
+		opts.CustomWeights = &weights
+		opts.Strict = false
+	}
+
+	text := tools
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, tools); err == nil {
+		text = compact.Bytes()
+	}
+
+	result := EstimateText(string(text), opts)
+	if !opts.Strict && strictErr != nil {
+		result.Warnings = append(result.Warnings, strictErr.Error())
+	}
+	textTokens := result.Tokens
+	overheadTokens := 0
+	if n := countTools(text); n > 0 {
+		overheadTokens = n*PerToolOverhead + ToolsArrayOverhead
+	}
+	result.Tokens = applyMultiplier(textTokens+overheadTokens, multiplier)
+	if opts.Explain {
+		result.Breakdown = appendInputBreakdown(result.Breakdown, textTokens, 0, 0, overheadTokens, result.Tokens)
+	}
+	result.setRange()
+	return notify(observer, result)
+}
+
+// countTools returns the number of elements of a top-level JSON array, 1 for any other
+// non-empty input, and 0 for empty input or an empty array.
+func countTools(data []byte) int {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return 0
+	}
+	if data[0] != '[' {
+		return 1
+	}
+	var elems []json.RawMessage
+	if err := json.Unmarshal(data, &elems); err != nil {
+		return 1
+	}
+	return len(elems)
+}
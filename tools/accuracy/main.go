@@ -37,9 +37,23 @@ type sampleData struct {
 
 func main() {
 	reportDirFlag := flag.String("report-dir", "", "write markdown + xlsx reports to this directory (default: <repo>/tokenest/report)")
+	actualsFlag := flag.String("actuals", "", "take the actual counts from this earlier testAccuracy markdown report instead of tokenizing (samples it lacks are skipped)")
 	flag.Parse()
 
-	enc := mustEncoding()
+	note := "Generated by `tokenest/tools/accuracy` using `o200k_base`."
+	var enc *tiktoken.Tiktoken
+	var actuals map[string]int
+	if *actualsFlag != "" {
+		var err error
+		actuals, err = loadReportActuals(*actualsFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "actuals error: %v\n", err)
+			os.Exit(1)
+		}
+		note += fmt.Sprintf(" Actual counts are taken from `%s`, so tiktoken-go times are not measured.", filepath.Base(*actualsFlag))
+	} else {
+		enc = mustEncoding()
+	}
 	repoRoot := findRepoRoot()
 	tokenxFixtureDir := filepath.Join(repoRoot, "tokenx", "test", "fixtures", "ebooks")
 	tokenxTypescript := filepath.Join(repoRoot, "tokenx", "node_modules", "typescript", "lib", "lib.es5.d.ts")
@@ -131,9 +145,20 @@ func main() {
 		s := item.sample
 		text := item.text
 
-		actual, gptAvg := timedCount(func() int {
-			return len(enc.Encode(text, nil, nil))
-		}, len(text))
+		var actual int
+		var gptAvg time.Duration
+		if actuals != nil {
+			count, ok := actuals[s.name]
+			if !ok {
+				fmt.Printf("%s\t%s\n", s.name, "no recorded actual count")
+				continue
+			}
+			actual = count
+		} else {
+			actual, gptAvg = timedCount(func() int {
+				return len(enc.Encode(text, nil, nil))
+			}, len(text))
+		}
 
 		ultra, ultraAvg := timedCount(func() int {
 			res := tokenest.EstimateText(text, tokenest.Options{
@@ -172,20 +197,25 @@ func main() {
 			return res.Tokens
 		}, len(text))
 
-		gptTokenizerCount := 0
-		var gptTokenizerAvg time.Duration
+		// Without gpt-tokenizer (e.g. no node_modules) its columns read n/a rather than 0.
+		gptTokenizerCell, gptTokenizerDeviation, gptTokenizerTime := "n/a", "n/a", "n/a"
 		if gptTokenizerErr == nil {
+			gptTokenizerCount := 0
+			var gptTokenizerAvg time.Duration
 			if result, ok := gptTokenizerResults[s.name]; ok {
 				gptTokenizerCount = result.Count
 				gptTokenizerAvg = time.Duration(result.AvgNs)
 			}
+			gptTokenizerCell = strconv.Itoa(gptTokenizerCount)
+			gptTokenizerDeviation = fmt.Sprintf("%.2f%%", deviationSigned(actual, gptTokenizerCount))
+			gptTokenizerTime = formatDuration(gptTokenizerAvg)
 		}
 
 		row := []string{
 			s.name,
 			strconv.Itoa(actual),
-			strconv.Itoa(gptTokenizerCount),
-			fmt.Sprintf("%.2f%%", deviationSigned(actual, gptTokenizerCount)),
+			gptTokenizerCell,
+			gptTokenizerDeviation,
 			strconv.Itoa(ultra),
 			fmt.Sprintf("%.2f%%", deviationSigned(actual, ultra)),
 			strconv.Itoa(fast),
@@ -199,7 +229,7 @@ func main() {
 			strconv.Itoa(zrCount),
 			fmt.Sprintf("%.2f%%", deviationSigned(actual, zrCount)),
 			formatDuration(gptAvg),
-			formatDuration(gptTokenizerAvg),
+			gptTokenizerTime,
 			formatDuration(ultraAvg),
 			formatDuration(fastAvg),
 			formatDuration(tokenxAvg),
@@ -212,7 +242,7 @@ func main() {
 	}
 
 	if reportDir != "" {
-		if err := writeReports(reportDir, header, rows); err != nil {
+		if err := writeReports(reportDir, note, header, rows); err != nil {
 			fmt.Fprintf(os.Stderr, "report write error: %v\n", err)
 		}
 	}
@@ -253,6 +283,32 @@ func loadDatasetSamples(dir string) []sample {
 	return samples
 }
 
+// loadReportActuals reads the Description and Actual columns of a testAccuracy markdown report,
+// keyed by description.
+func loadReportActuals(path string) (map[string]int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	actuals := make(map[string]int)
+	for _, line := range strings.Split(string(data), "\n") {
+		cols := strings.Split(strings.Trim(strings.TrimSpace(line), "|"), " | ")
+		if len(cols) < 2 {
+			continue
+		}
+		count, err := strconv.Atoi(strings.TrimSpace(cols[1]))
+		if err != nil {
+			// Header and separator rows.
+			continue
+		}
+		actuals[strings.TrimSpace(cols[0])] = count
+	}
+	if len(actuals) == 0 {
+		return nil, fmt.Errorf("no actual counts in %s", path)
+	}
+	return actuals, nil
+}
+
 func mustEncoding() *tiktoken.Tiktoken {
 	enc, err := tiktoken.GetEncoding("o200k_base")
 	if err != nil {
@@ -317,7 +373,7 @@ func countGPTTokenizer(samples []sampleData) (map[string]gptTokenizerResult, err
 	return resp.Results, nil
 }
 
-func writeReports(dir string, header []string, rows [][]string) error {
+func writeReports(dir, note string, header []string, rows [][]string) error {
 	if dir == "" {
 		return nil
 	}
@@ -328,11 +384,11 @@ func writeReports(dir string, header []string, rows [][]string) error {
 	now := time.Now().UTC()
 	normalized := normalizeRows(header, rows)
 
-	if err := writeMarkdownReport(dir, now, header, normalized); err != nil {
+	if err := writeMarkdownReport(dir, now, note, header, normalized); err != nil {
 		return err
 	}
 
-	if err := writeXLSXReport(dir, now, header, normalized); err != nil {
+	if err := writeXLSXReport(dir, now, note, header, normalized); err != nil {
 		return err
 	}
 
@@ -352,7 +408,7 @@ func normalizeRows(header []string, rows [][]string) [][]string {
 	return out
 }
 
-func writeMarkdownReport(dir string, now time.Time, header []string, rows [][]string) error {
+func writeMarkdownReport(dir string, now time.Time, note string, header []string, rows [][]string) error {
 	fileName := fmt.Sprintf("testAccuracy-%s.md", now.Format("20060102-150405Z"))
 	path := filepath.Join(dir, fileName)
 
@@ -363,7 +419,8 @@ func writeMarkdownReport(dir string, now time.Time, header []string, rows [][]st
 
 	var b strings.Builder
 	b.WriteString("# testAccuracy\n\n")
-	b.WriteString("Generated by `tokenest/tools/accuracy` using `o200k_base`.\n")
+	b.WriteString(note)
+	b.WriteString("\n")
 	b.WriteString("Generated at: ")
 	b.WriteString(now.Format(time.RFC3339))
 	b.WriteString("\n\n")
@@ -398,12 +455,12 @@ type accuracyXLSXPayload struct {
 	DeviationColumns []xlsxColumn `json:"deviation_columns"`
 }
 
-func writeXLSXReport(dir string, now time.Time, header []string, rows [][]string) error {
+func writeXLSXReport(dir string, now time.Time, note string, header []string, rows [][]string) error {
 	payload := accuracyXLSXPayload{
 		ReportType:  "accuracy",
 		Title:       "testAccuracy",
 		GeneratedAt: now.Format(time.RFC3339),
-		Note:        "Generated by `tokenest/tools/accuracy` using `o200k_base`.",
+		Note:        note,
 		Header:      header,
 		Rows:        rows,
 	}
@@ -12,6 +12,7 @@ type cliOptions struct {
 
 	RidgeLambda float64
 	OutZRConfig string
+	ActualsPath string
 
 	JSONLPath       string
 	JSONLTextPath   string
@@ -39,6 +40,7 @@ func parseCLI() (cliOptions, error) {
 		asymAlpha     = flag.Float64("asym-alpha", 2.0, "Underestimation penalty multiplier for asym_huber_rel")
 		ridgeLambda   = flag.Float64("ridge-lambda", 0.0, "Ridge regularization lambda (0 disables)")
 		outZRConfig   = flag.String("out-zr-config", "", "Write ZR config JSON to path")
+		actualsPath   = flag.String("actuals", "", "Take dataset token counts from a testAccuracy-*.md report instead of tiktoken (fits whole files)")
 		selectMetric  = flag.String("select", "train_mape", "selection metric in grid mode: train_mape|val_mape")
 		noGrid        = flag.Bool("no-grid", false, "Skip hyperparameter grid search and use fixed thresholds")
 		jsonlPath     = flag.String("jsonl", "", "JSONL dataset path (one JSON object per line)")
@@ -112,10 +114,21 @@ func parseCLI() (cliOptions, error) {
 		return cliOptions{}, fmt.Errorf("invalid -select %q (use train_mape|val_mape)", sel)
 	}
 
+	actuals := strings.TrimSpace(*actualsPath)
+	if actuals != "" {
+		if strings.TrimSpace(*jsonlPath) != "" {
+			return cliOptions{}, fmt.Errorf("-actuals cannot be combined with -jsonl (use -jsonl-tokens)")
+		}
+		if sel == "val_mape" {
+			return cliOptions{}, fmt.Errorf("-select val_mape needs a test split, which -actuals does not have")
+		}
+	}
+
 	return cliOptions{
 		Loss:        loss,
 		RidgeLambda: *ridgeLambda,
 		OutZRConfig: strings.TrimSpace(*outZRConfig),
+		ActualsPath: actuals,
 		JSONLPath:   strings.TrimSpace(*jsonlPath),
 
 		JSONLTextPath:   strings.TrimSpace(*jsonlText),
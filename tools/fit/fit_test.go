@@ -4,7 +4,10 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/EZ-Api/tokenest/strategy"
 )
 
 func TestFitByCategory_HuberDownweightsOutlier(t *testing.T) {
@@ -165,3 +168,78 @@ func TestWriteZRConfigFile_RoundTrip(t *testing.T) {
 		t.Fatalf("expected created_at to be set")
 	}
 }
+
+func TestJSONLSource_SkipsOverlongLines(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.jsonl")
+	long := "{\"text\":\"" + strings.Repeat("x", 256) + "\",\"tokens\":10}\n"
+	if err := os.WriteFile(path, []byte(
+		"{\"text\":\"hello\",\"tokens\":10}\n"+
+			long+
+			"{\"text\":\"world\",\"tokens\":10}",
+	), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	src := jsonlSource{
+		path:       path,
+		textPath:   "text",
+		tokensPath: "tokens",
+		cfg: searchConfig{
+			charsPerToken:       3,
+			shortThreshold:      6,
+			capitalThreshold:    0.3,
+			denseThreshold:      0.01,
+			hexThreshold:        0.9,
+			alnumPunctThreshold: 0.03,
+		},
+		wantSplit:    splitAny,
+		lenBounds:    []int{32, 64},
+		maxLineBytes: 128,
+	}
+
+	count := 0
+	skipped, err := src.IterateCounting(func(row fitRow) error {
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("iterate: %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("expected rows around the overlong line to be kept, got %d", count)
+	}
+	if skipped != 1 {
+		t.Fatalf("expected 1 skipped line, got %d", skipped)
+	}
+}
+
+func TestTokenXBaseMatchesZR(t *testing.T) {
+	// The fitted coefficients only apply to ZR if both count the same base.
+	cfg := searchConfig{
+		charsPerToken:       3.0,
+		shortThreshold:      6,
+		capitalThreshold:    0.30,
+		denseThreshold:      0.01,
+		hexThreshold:        0.90,
+		alnumPunctThreshold: 0.03,
+	}
+	inputs := []string{"3.14", "1,234,567", "id 12345678901234567890", "Hello, 世界! {\"k\": [1, 2, 3]}"}
+	files, err := filepath.Glob(filepath.Join("..", "..", "datasets", "test", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, string(data))
+	}
+	for i, input := range inputs {
+		base, _ := estimateTokenXWithStats(input, cfg)
+		if zr := strategy.EstimateZRDetail(input).BaseTokens; base != zr {
+			t.Fatalf("input %d: expected the fit base to match ZR's %d, got %d", i, zr, base)
+		}
+	}
+}
@@ -8,11 +8,15 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"sync"
 	"unicode"
 	"unicode/utf8"
 
 	"github.com/EZ-Api/tokenest"
+	"github.com/EZ-Api/tokenest/internal/cjk"
+	"github.com/EZ-Api/tokenest/internal/numeric"
 	"github.com/pkoukk/tiktoken-go"
 )
 
@@ -51,6 +55,10 @@ const (
 	CatText
 )
 
+// fitDigitsPerToken groups the digits of numeric segments, matching the ZR strategy's default
+// digitsPerToken so the fitted coefficients apply to the same base count.
+const fitDigitsPerToken = 3
+
 type searchConfig struct {
 	charsPerToken       float64
 	shortThreshold      int
@@ -67,7 +75,17 @@ func main() {
 		os.Exit(1)
 	}
 
-	enc := mustEncoding()
+	var enc *tiktoken.Tiktoken
+	var actuals map[string]int
+	if opts.ActualsPath != "" {
+		actuals, err = loadReportActuals(opts.ActualsPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "actuals error: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		enc = mustEncoding()
+	}
 	repoRoot := findRepoRoot()
 	datasetsDir := filepath.Join(repoRoot, "tokenest", "datasets", "test")
 
@@ -151,36 +169,58 @@ func main() {
 	var trainItems []sampleData
 	var testItems []sampleData
 
-	// We split each sample text into train/test parts to ensure coverage
-	for _, item := range loaded {
-		runes := []rune(item.text)
-		if len(runes) == 0 {
-			continue
+	if actuals != nil {
+		// The report only has counts for whole dataset files, so fit on those and leave the
+		// test split empty.
+		withActuals := loaded[:0]
+		for _, item := range loaded {
+			count, ok := actuals[reportDescription(item.sample)]
+			if !ok {
+				fmt.Printf("%s\t%s\n", item.sample.name, "no actual count in report, skipped")
+				continue
+			}
+			item.actual = float64(count)
+			withActuals = append(withActuals, item)
 		}
+		loaded = withActuals
+		trainItems = loaded
+	} else {
+		// We split each sample text into train/test parts to ensure coverage
+		for _, item := range loaded {
+			runes := []rune(item.text)
+			if len(runes) == 0 {
+				continue
+			}
 
-		splitIdx := int(float64(len(runes)) * 0.8)
-		if splitIdx == 0 && len(runes) > 0 {
-			splitIdx = len(runes)
-		}
+			splitIdx := int(float64(len(runes)) * 0.8)
+			if splitIdx == 0 && len(runes) > 0 {
+				splitIdx = len(runes)
+			}
 
-		trainText := string(runes[:splitIdx])
-		testText := string(runes[splitIdx:])
+			trainText := string(runes[:splitIdx])
+			testText := string(runes[splitIdx:])
 
-		if len(trainText) > 0 {
-			trainItems = append(trainItems, sampleData{sample: item.sample, text: trainText})
-		}
-		if len(testText) > 0 {
-			testItems = append(testItems, sampleData{sample: item.sample, text: testText})
+			if len(trainText) > 0 {
+				trainItems = append(trainItems, sampleData{sample: item.sample, text: trainText})
+			}
+			if len(testText) > 0 {
+				testItems = append(testItems, sampleData{sample: item.sample, text: testText})
+			}
 		}
 	}
 
 	// Pre-calculate actual tokens
-	fmt.Println("Pre-calculating ground truth tokens...")
-	for i := range trainItems {
-		trainItems[i].actual = float64(len(enc.Encode(trainItems[i].text, nil, nil)))
-	}
-	for i := range testItems {
-		testItems[i].actual = float64(len(enc.Encode(testItems[i].text, nil, nil)))
+	if enc != nil {
+		fmt.Println("Pre-calculating ground truth tokens...")
+		for i := range trainItems {
+			trainItems[i].actual = float64(len(enc.Encode(trainItems[i].text, nil, nil)))
+		}
+		for i := range testItems {
+			testItems[i].actual = float64(len(enc.Encode(testItems[i].text, nil, nil)))
+		}
+		for i := range loaded {
+			loaded[i].actual = float64(len(enc.Encode(loaded[i].text, nil, nil)))
+		}
 	}
 
 	if opts.JSONLPath != "" {
@@ -191,7 +231,7 @@ func main() {
 		return
 	}
 	if opts.NoGrid {
-		if err := runFixedConfigFit(enc, opts, trainItems, testItems, loaded); err != nil {
+		if err := runFixedConfigFit(opts, trainItems, testItems, loaded); err != nil {
 			fmt.Fprintf(os.Stderr, "fit error: %v\n", err)
 			os.Exit(1)
 		}
@@ -340,8 +380,7 @@ func main() {
 
 		anchorRows := make([]fitRow, 0, len(loaded))
 		for _, item := range loaded {
-			actual := float64(len(enc.Encode(item.text, nil, nil)))
-			anchorRows = append(anchorRows, makeFeatureRowWithActual(item.sample.name, item.text, actual, bestConfig))
+			anchorRows = append(anchorRows, makeFeatureRowWithActual(item.sample.name, item.text, item.actual, bestConfig))
 		}
 		anchorMetrics, _ := computeMetrics(sliceSource{rows: anchorRows}, bestCoeffs)
 
@@ -369,7 +408,7 @@ func main() {
 			Strategy: tokenest.StrategyWeighted,
 			Profile:  tokenest.ProfileOpenAI,
 		})
-		fmt.Printf("%s\tactual=%d\tweighted=%d\n", item.sample.name, len(enc.Encode(item.text, nil, nil)), res.Tokens)
+		fmt.Printf("%s\tactual=%.0f\tweighted=%d\n", item.sample.name, item.actual, res.Tokens)
 	}
 }
 
@@ -530,6 +569,42 @@ func findRepoRoot() string {
 	return filepath.Clean(filepath.Join(wd, "..", "..", ".."))
 }
 
+// reportDescription is the Description a testAccuracy report gives s: the accuracy tool lists
+// dataset files as "Dataset: " plus the file name without extension, underscores as spaces.
+func reportDescription(s sample) string {
+	if s.path == "" {
+		return s.name
+	}
+	base := strings.TrimSuffix(filepath.Base(s.path), filepath.Ext(s.path))
+	return "Dataset: " + strings.ReplaceAll(base, "_", " ")
+}
+
+// loadReportActuals reads the Description and Actual columns of a testAccuracy markdown report,
+// keyed by description.
+func loadReportActuals(path string) (map[string]int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	actuals := make(map[string]int)
+	for _, line := range strings.Split(string(data), "\n") {
+		cols := strings.Split(strings.Trim(strings.TrimSpace(line), "|"), " | ")
+		if len(cols) < 2 {
+			continue
+		}
+		count, err := strconv.Atoi(strings.TrimSpace(cols[1]))
+		if err != nil {
+			// Header and separator rows.
+			continue
+		}
+		actuals[strings.TrimSpace(cols[0])] = count
+	}
+	if len(actuals) == 0 {
+		return nil, fmt.Errorf("no actual counts in %s", path)
+	}
+	return actuals, nil
+}
+
 func loadSample(s sample) string {
 	text := s.inline
 	if text == "" && s.path != "" {
@@ -789,7 +864,7 @@ func estimateTokenXSegment(segment string, stats *tokenXStats, cfg searchConfig)
 	}
 
 	if isNumericSegment(segment) {
-		return 1
+		return numeric.Tokens(segment, fitDigitsPerToken)
 	}
 
 	if runeCount <= cfg.shortThreshold {
@@ -854,38 +929,7 @@ func isCJKSegment(segment string) bool {
 }
 
 func isCJKRune(r rune) bool {
-	switch {
-	case r >= 0x4e00 && r <= 0x9fff:
-		return true
-	case r >= 0x3400 && r <= 0x4dbf:
-		return true
-	case r >= 0x3000 && r <= 0x303f:
-		return true
-	case r >= 0xff00 && r <= 0xffef:
-		return true
-	case r >= 0x30a0 && r <= 0x30ff:
-		return true
-	case r >= 0x2e80 && r <= 0x2eff:
-		return true
-	case r >= 0x31c0 && r <= 0x31ef:
-		return true
-	case r >= 0x3200 && r <= 0x32ff:
-		return true
-	case r >= 0x3300 && r <= 0x33ff:
-		return true
-	case r >= 0xac00 && r <= 0xd7af:
-		return true
-	case r >= 0x1100 && r <= 0x11ff:
-		return true
-	case r >= 0x3130 && r <= 0x318f:
-		return true
-	case r >= 0xa960 && r <= 0xa97f:
-		return true
-	case r >= 0xd7b0 && r <= 0xd7ff:
-		return true
-	default:
-		return false
-	}
+	return cjk.IsRune(r)
 }
 
 func isNumericSegment(segment string) bool {
@@ -2,11 +2,12 @@ package main
 
 import (
 	"fmt"
+	"path/filepath"
 
 	"github.com/pkoukk/tiktoken-go"
 )
 
-func runFixedConfigFit(enc *tiktoken.Tiktoken, opts cliOptions, trainItems, testItems []sampleData, loaded []sampleData) error {
+func runFixedConfigFit(opts cliOptions, trainItems, testItems []sampleData, loaded []sampleData) error {
 	cfg := opts.FixedConfig
 
 	trainRows := make([]fitRow, 0, len(trainItems))
@@ -55,8 +56,7 @@ func runFixedConfigFit(enc *tiktoken.Tiktoken, opts cliOptions, trainItems, test
 
 	anchorRows := make([]fitRow, 0, len(loaded))
 	for _, item := range loaded {
-		actual := float64(len(enc.Encode(item.text, nil, nil)))
-		anchorRows = append(anchorRows, makeFeatureRowWithActual(item.sample.name, item.text, actual, cfg))
+		anchorRows = append(anchorRows, makeFeatureRowWithActual(item.sample.name, item.text, item.actual, cfg))
 	}
 	anchorMetrics, _ := computeMetrics(sliceSource{rows: anchorRows}, fitRes.Coeffs)
 	fmt.Printf("\n=== ANCHOR EVAL (Full Text) ===\n")
@@ -83,6 +83,11 @@ func runFixedConfigFit(enc *tiktoken.Tiktoken, opts cliOptions, trainItems, test
 			Val:        &valMetrics,
 			Anchor:     &anchorMetrics,
 		}
+		if opts.ActualsPath != "" {
+			// Whole files only; there is no test split to report.
+			meta.Dataset = "curated-fixed:" + filepath.Base(opts.ActualsPath)
+			meta.Val = nil
+		}
 		if err := writeZRConfigFile(opts.OutZRConfig, cfg, fitRes.Coeffs, meta); err != nil {
 			return err
 		}
@@ -184,8 +189,7 @@ func runJSONLFit(enc *tiktoken.Tiktoken, opts cliOptions, loaded []sampleData) e
 
 	anchorRows := make([]fitRow, 0, len(loaded))
 	for _, item := range loaded {
-		actual := float64(len(enc.Encode(item.text, nil, nil)))
-		anchorRows = append(anchorRows, makeFeatureRowWithActual(item.sample.name, item.text, actual, cfg))
+		anchorRows = append(anchorRows, makeFeatureRowWithActual(item.sample.name, item.text, item.actual, cfg))
 	}
 	anchorMetrics, _ := computeMetrics(sliceSource{rows: anchorRows}, fitRes.Coeffs)
 	fmt.Printf("\nAnchor: count=%d mae=%.2f mape=%.2f%% p50=%.2f%% p90=%.2f%% under=%.2f%%\n",
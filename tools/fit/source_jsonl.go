@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"hash/fnv"
+	"io"
 	"os"
 	"strconv"
 	"strings"
@@ -34,18 +35,36 @@ type jsonlSource struct {
 	lenBounds  []int
 	bucketCap  int
 	maxSamples int
+
+	// maxLineBytes bounds a single JSONL line; longer lines are skipped and counted.
+	// Zero uses defaultJSONLMaxLineBytes.
+	maxLineBytes int
 }
 
+// API logs can have very large lines (full request bodies with embedded images).
+const defaultJSONLMaxLineBytes = 16 * 1024 * 1024
+
 func (s jsonlSource) Iterate(fn func(fitRow) error) error {
+	skipped, err := s.IterateCounting(fn)
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "warning: %s: skipped %d line(s) longer than %d bytes; large samples are missing\n",
+			s.path, skipped, s.lineLimit())
+	}
+	return err
+}
+
+// IterateCounting is Iterate that also reports how many lines were skipped for exceeding
+// maxLineBytes, so callers can notice a dataset biased toward small samples.
+func (s jsonlSource) IterateCounting(fn func(fitRow) error) (int, error) {
 	f, err := os.Open(s.path)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer f.Close()
 
-	scanner := bufio.NewScanner(f)
-	// API logs can have very large lines; raise scanner limits.
-	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	reader := bufio.NewReaderSize(f, 64*1024)
+	limit := s.lineLimit()
+	skipped := 0
 
 	numBuckets := len(s.lenBounds) + 1
 	var bucketCounts []int
@@ -54,8 +73,22 @@ func (s jsonlSource) Iterate(fn func(fitRow) error) error {
 	}
 
 	seen := 0
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+	var buf []byte
+	for {
+		raw, tooLong, err := readJSONLLine(reader, limit, buf[:0])
+		if err == io.EOF && len(raw) == 0 && !tooLong {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return skipped, err
+		}
+		buf = raw
+		if tooLong {
+			skipped++
+			continue
+		}
+
+		line := strings.TrimSpace(string(raw))
 		if line == "" {
 			continue
 		}
@@ -116,7 +149,7 @@ func (s jsonlSource) Iterate(fn func(fitRow) error) error {
 			bucket:   bucket,
 		}
 		if err := fn(row); err != nil {
-			return err
+			return skipped, err
 		}
 
 		seen++
@@ -124,10 +157,36 @@ func (s jsonlSource) Iterate(fn func(fitRow) error) error {
 			break
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		return err
+	return skipped, nil
+}
+
+func (s jsonlSource) lineLimit() int {
+	if s.maxLineBytes > 0 {
+		return s.maxLineBytes
+	}
+	return defaultJSONLMaxLineBytes
+}
+
+// readJSONLLine reads one line into dst. Lines longer than limit are consumed and discarded,
+// reported via tooLong. It returns io.EOF once the input is exhausted.
+func readJSONLLine(r *bufio.Reader, limit int, dst []byte) (line []byte, tooLong bool, err error) {
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return dst, tooLong, err
+		}
+		if !tooLong {
+			if len(dst)+len(chunk) > limit {
+				tooLong = true
+				dst = dst[:0]
+			} else {
+				dst = append(dst, chunk...)
+			}
+		}
+		if !isPrefix {
+			return dst, tooLong, nil
+		}
 	}
-	return nil
 }
 
 func (s jsonlSource) isVal(text string) bool {
@@ -0,0 +1,107 @@
+package tokenest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Validate reports option values that are invalid or that the selected strategy ignores, so
+// misconfiguration can be caught in tests instead of producing confusing estimates. Estimation
+// never requires it: every function accepts any Options and falls back to defaults.
+//
+// Each problem is a separate error wrapping ErrInvalidMultiplier, ErrUnknownStrategy,
+// ErrUnknownProfile, or ErrIneffectiveOption, combined with errors.Join. Settings that only
+// apply to some strategies are checked against an explicit Strategy; StrategyAuto may pick
+// Weighted or Fast depending on input size, so it accepts both. A zero GlobalMultiplier is the
+// unset value (1.0) and is not reported. Validate returns nil when nothing is wrong.
+func (o Options) Validate() error {
+	var errs []error
+	ineffective := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrIneffectiveOption, fmt.Sprintf(format, args...)))
+	}
+
+	if _, ok := parseStrategy(o.Strategy.String()); !ok {
+		errs = append(errs, fmt.Errorf("%w: %d", ErrUnknownStrategy, int(o.Strategy)))
+	}
+	if o.Profile != ProfileAuto {
+		if _, ok := parseProfile(o.Profile.String()); !ok {
+			errs = append(errs, fmt.Errorf("%w: %d", ErrUnknownProfile, int(o.Profile)))
+		}
+	}
+	if m := o.GlobalMultiplier; m < 0 || math.IsNaN(m) || math.IsInf(m, 0) {
+		errs = append(errs, fmt.Errorf("%w: %v", ErrInvalidMultiplier, m))
+	}
+
+	weighted := o.Strategy == StrategyAuto || o.Strategy == StrategyWeighted
+	fast := o.Strategy == StrategyAuto || o.Strategy == StrategyFast
+
+	if o.Explain && o.Strategy == StrategyUltraFast {
+		ineffective("Explain with %v produces no breakdown", o.Strategy)
+	}
+	if len(o.SpecialTokens) > 0 && o.Strategy == StrategyUltraFast {
+		ineffective("SpecialTokens is ignored by %v", o.Strategy)
+	}
+	if o.Normalize {
+		if o.Strategy == StrategyUltraFast {
+			ineffective("Normalize is ignored by %v", o.Strategy)
+		} else if fn, _ := currentNormalizer(); fn == nil {
+			ineffective("Normalize has no effect until a normalizer is registered")
+		}
+	}
+	if o.FastSampleFraction < 0 || o.FastSampleFraction > 1 {
+		ineffective("FastSampleFraction %v is outside [0, 1]", o.FastSampleFraction)
+	}
+	if o.FastSampleWindows < 0 {
+		ineffective("FastSampleWindows %d is negative", o.FastSampleWindows)
+	}
+	if (o.FastSampleFraction != 0 || o.FastSampleWindows != 0) && !fast {
+		ineffective("Fast sampling options are ignored by %v", o.Strategy)
+	}
+	if o.FastSampleFraction > 0 && o.FastSampleWindows > 0 {
+		ineffective("FastSampleWindows is ignored when FastSampleFraction is set")
+	}
+	if o.ShortTokenThreshold < 0 {
+		ineffective("ShortTokenThreshold %d is negative", o.ShortTokenThreshold)
+	}
+	if !weighted {
+		if o.CustomWeights != nil {
+			ineffective("CustomWeights is ignored by %v", o.Strategy)
+		}
+		if o.CJKRanges != nil {
+			ineffective("CJKRanges is ignored by %v", o.Strategy)
+		}
+		if o.Language != "" {
+			ineffective("Language is ignored by %v", o.Strategy)
+		}
+		if o.ShortTokenThreshold > 0 {
+			ineffective("ShortTokenThreshold is ignored by %v", o.Strategy)
+		}
+	}
+	if o.Language != "" && languageCharsPerToken(o.Language, defaultCharsPerToken) == 0 {
+		ineffective("Language %q is not recognized", o.Language)
+	}
+	if o.ZRConfig != nil && o.Strategy != StrategyZR {
+		ineffective("ZRConfig is ignored by %v", o.Strategy)
+	}
+	return errors.Join(errs...)
+}
+
+// strictError returns why o cannot be honored exactly once resolved to strategy, wrapping
+// ErrStrict, or nil when Strict is off or nothing was left to fallback.
+func (o Options) strictError(strategy Strategy) error {
+	if !o.Strict {
+		return nil
+	}
+	if err := o.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrStrict, err)
+	}
+	if defaultStrategy(o.Strategy) == StrategyAuto {
+		return fmt.Errorf("%w: Strategy is auto (resolved to %v)", ErrStrict, strategy)
+	}
+	if strategy == StrategyWeighted && o.CustomWeights == nil && o.Profile == ProfileAuto &&
+		o.Model == "" && o.ProviderType == "" && defaults.profile == ProfileAuto {
+		return fmt.Errorf("%w: Profile is auto with no Model or ProviderType (resolved to %v)", ErrStrict, resolveProfile(o))
+	}
+	return nil
+}
@@ -1,54 +1,252 @@
 package tokenest
 
 import (
+	"context"
 	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"unicode"
 	"unicode/utf8"
+
+	"github.com/EZ-Api/tokenest/internal/numeric"
 )
 
 const (
 	weightedClampMin          = 0.85
 	weightedClampMax          = 1.20
 	tokenXShortTokenThreshold = 3
+	// tokenXDigitsPerToken is how many digits tokenizers typically merge into one token.
+	tokenXDigitsPerToken = 3
+
+	// cjkRepeatMinRun is the shortest run of one repeated CJK rune that BPE tends to merge.
+	cjkRepeatMinRun = 3
+	// cjkRepeatRunesPerToken is the number of repeated CJK runes charged as one token.
+	cjkRepeatRunesPerToken = 2
+
+	// weightedDigitRatioFactor is the built-in profiles' DigitRatioFactor, refitted against the
+	// o200k_base counts of datasets/test once numeric segments cost one token per digit group.
+	weightedDigitRatioFactor = 0.15
+
+	// weightedWhitespaceRunesPerToken is the built-in profiles' WhitespaceRunesPerToken. Against
+	// the o200k_base counts of datasets/test, every value from 1 to 32 left Weighted's MAPE within
+	// 0.03 points and pushed golang_net_http_server.go from +4.1% to at least +7.1%, since
+	// BaseFactor was fitted with indentation free.
+	weightedWhitespaceRunesPerToken = 0
+
+	// tokenXSymbolsPerToken is the default density of segments containing punctuation.
+	tokenXSymbolsPerToken = 2.0
+
+	// hangulTokensPerSyllable is the default cost of a Hangul syllable, the same as a Han
+	// character. It is not calibrated: datasets/test and the accuracy reports have no Korean
+	// text, so there are no o200k_base counts to fit it against.
+	hangulTokensPerSyllable = 1.0
+
+	// codeBaseFactor and codePunctRatioFactor are codeWeightsForProfile's factors.
+	codeBaseFactor       = 0.9765
+	codePunctRatioFactor = -0.1240
 )
 
-type weightedTuning struct {
-	baseFactor       float64
-	cjkRatioFactor   float64
-	punctRatioFactor float64
-	digitRatioFactor float64
-	clampMin         float64
-	clampMax         float64
+// DefaultPunctuation is the ASCII punctuation set Weighted segments on when Weights.Punctuation
+// is empty. Quotes and apostrophes are not in it, so don't and "hi" stay single segments; the
+// built-in profiles add the double quote. CJK punctuation is always punctuation and is not
+// affected by Weights.Punctuation.
+const DefaultPunctuation = ".,!?;(){}[]<>:/\\|@#$%^&*+=`~_-"
+
+// profilePunctuation is the built-in profiles' Weights.Punctuation. Without the double quote,
+// a quoted JSON key or string such as "xxxxxxxxxxxx" fell through to one token per rune and
+// toxic_minified_json.txt estimated 81% over its o200k_base count; with it, 0.3% under.
+const profilePunctuation = DefaultPunctuation + "\""
+
+// Weights is the Weighted strategy's tuning table. The estimate is
+// base*(BaseFactor + cjkRatio*CJKRatioFactor + punctRatio*PunctRatioFactor
+// + digitRatio*DigitRatioFactor + embeddedDigitRatio*EmbeddedDigitRatioFactor
+// + urlDelimRatio*URLDelimRatioFactor + mathRatio*MathRatioFactor), clamped to
+// [base*ClampMin, base*ClampMax], where base is the tokenx segmentation count. A zero ClampMax
+// disables the upper bound.
+type Weights struct {
+	BaseFactor       float64
+	CJKRatioFactor   float64
+	PunctRatioFactor float64
+	// DigitRatioFactor weights digits in numeric segments, which already cost one token per
+	// DigitsPerToken digits, so it is small.
+	DigitRatioFactor float64
+	// EmbeddedDigitRatioFactor weights digits inside non-numeric segments (e.g. "abc123def"),
+	// which DigitRatioFactor does not cover. The built-in profiles keep the 0.4569 both ratios
+	// were originally fitted at, since embedded digits are not costed by digit groups.
+	EmbeddedDigitRatioFactor float64
+	// URLDelimRatioFactor weights URL delimiters (':', '/', '?', '&', '=', '#', '%'), with '.'
+	// counted only in words with a "://" scheme or a leading "www.", so the dots in "3.14",
+	// "192.168.0.1", and "a.b.c" are not URL delimiters. The built-in profiles leave it zero;
+	// the delimiters are counted, in an extra pass over the text, only when it is set.
+	URLDelimRatioFactor float64
+	// MathRatioFactor weights math operators ('+', '-', '*', '/', '=', '^', '<', '>'), except a
+	// '-' between two letters ("state-of-the-art") and a '/' touching a letter ("a/b/c"), which
+	// stay plain punctuation. Like URLDelimRatioFactor, the built-in profiles leave it zero and
+	// the operators are counted only when it is set.
+	MathRatioFactor float64
+	ClampMin        float64
+	ClampMax        float64
+
+	// ShortTokenThreshold is the rune count at or below which a segment costs one token.
+	// Zero uses the default of 3.
+	ShortTokenThreshold int
+
+	// CharsPerToken is the fallback density for alphanumeric segments with no language match.
+	// Zero uses the default of 6.
+	CharsPerToken float64
+
+	// DigitsPerToken is the digit-group size for numeric segments, which cost
+	// ceil(digits/DigitsPerToken) tokens. Zero uses the default of 3.
+	DigitsPerToken int
+
+	// SymbolsPerToken is the density of segments longer than ShortTokenThreshold that contain
+	// punctuation (e.g. "});", "://"), which cost ceil(runes/SymbolsPerToken) tokens.
+	// Zero uses the default of 2.
+	SymbolsPerToken float64
+
+	// WhitespaceRunesPerToken makes indentation cost ceil((n-1)/WhitespaceRunesPerToken) tokens
+	// for n whitespace runes after a run's last line break: the last rune is free, as BPE merges
+	// it into the next word, and the rest grow sublinearly like the space-run tokens tokenizers
+	// use for indentation. Line breaks stay free. Zero keeps all whitespace free; the built-in
+	// profiles use zero, as no value improved their fit to o200k_base counts.
+	WhitespaceRunesPerToken int
+
+	// HangulTokensPerSyllable is the cost of each syllable in segments made only of Hangul
+	// syllables (U+AC00-U+D7A3), which are costed apart from Han characters; the total is rounded
+	// up per segment. The built-in profiles use 1.0, matching Han, as no Korean tokenizer counts
+	// have been collected to calibrate it. Zero uses the default of 1.0.
+	HangulTokensPerSyllable float64
+
+	// Punctuation, when non-empty, replaces DefaultPunctuation as the ASCII runes that split
+	// punctuation segments and count toward the punctuation ratio, e.g.
+	// DefaultPunctuation + "\"'" for tokenizers that split quotes off words. Whitespace and
+	// non-ASCII runes in it are ignored. Empty uses DefaultPunctuation.
+	Punctuation string
+}
+
+// WeightsForProfile returns the built-in Weights for profile, e.g. as a starting point
+// for Options.CustomWeights. ProfileAuto returns the OpenAI weights.
+func WeightsForProfile(profile Profile) Weights {
+	return weightsForProfile(profile)
 }
 
-func tuningForProfile(profile Profile) weightedTuning {
+// segmentation returns the base segmentation parameters for these weights.
+func (w Weights) segmentation() tokenXParams {
+	params := defaultTokenXParams
+	if w.ShortTokenThreshold > 0 {
+		params.shortTokenThreshold = w.ShortTokenThreshold
+	}
+	if w.CharsPerToken > 0 {
+		params.charsPerToken = w.CharsPerToken
+	}
+	if w.DigitsPerToken > 0 {
+		params.digitsPerToken = w.DigitsPerToken
+	}
+	if w.SymbolsPerToken > 0 {
+		params.symbolsPerToken = w.SymbolsPerToken
+	}
+	if w.WhitespaceRunesPerToken > 0 {
+		params.whitespaceRunesPerToken = w.WhitespaceRunesPerToken
+	}
+	if w.HangulTokensPerSyllable > 0 {
+		params.hangulTokensPerSyllable = w.HangulTokensPerSyllable
+	}
+	if w.Punctuation != "" {
+		params.classes = punctuationClasses(w.Punctuation)
+	}
+	return params
+}
+
+// weightsForOptions returns Options.CustomWeights when set, otherwise the resolved profile's
+// weights.
+func weightsForOptions(opts Options) Weights {
+	if opts.CustomWeights != nil {
+		return *opts.CustomWeights
+	}
+	return weightsForProfile(resolveProfile(opts))
+}
+
+// codeWeightsForProfile returns profile's weights with BaseFactor and PunctRatioFactor refitted
+// against the o200k_base counts of golang_net_http_server.go, toxic_minified_js.txt, and
+// toxic_minified_json.txt in report/testAccuracy-20260107-113910Z.md.
+func codeWeightsForProfile(profile Profile) Weights {
+	w := weightsForProfile(profile)
+	w.BaseFactor = codeBaseFactor
+	w.PunctRatioFactor = codePunctRatioFactor
+	return w
+}
+
+func weightsForProfile(profile Profile) Weights {
 	switch profile {
 	case ProfileClaude:
-		return weightedTuning{
-			baseFactor:       0.9467,
-			cjkRatioFactor:   0.0514,
-			punctRatioFactor: -0.0616,
-			digitRatioFactor: 0.4569,
-			clampMin:         weightedClampMin,
-			clampMax:         weightedClampMax,
+		return Weights{
+			BaseFactor:               0.9467,
+			CJKRatioFactor:           0.0514,
+			PunctRatioFactor:         -0.0616,
+			DigitRatioFactor:         weightedDigitRatioFactor,
+			EmbeddedDigitRatioFactor: 0.4569,
+			ClampMin:                 weightedClampMin,
+			ClampMax:                 weightedClampMax,
+
+			ShortTokenThreshold:     tokenXShortTokenThreshold,
+			CharsPerToken:           defaultCharsPerToken,
+			SymbolsPerToken:         tokenXSymbolsPerToken,
+			WhitespaceRunesPerToken: weightedWhitespaceRunesPerToken,
+			HangulTokensPerSyllable: hangulTokensPerSyllable,
+			Punctuation:             profilePunctuation,
 		}
 	case ProfileGemini:
-		return weightedTuning{
-			baseFactor:       0.9467,
-			cjkRatioFactor:   0.0514,
-			punctRatioFactor: -0.0616,
-			digitRatioFactor: 0.4569,
-			clampMin:         weightedClampMin,
-			clampMax:         weightedClampMax,
+		return Weights{
+			BaseFactor:               0.9467,
+			CJKRatioFactor:           0.0514,
+			PunctRatioFactor:         -0.0616,
+			DigitRatioFactor:         weightedDigitRatioFactor,
+			EmbeddedDigitRatioFactor: 0.4569,
+			ClampMin:                 weightedClampMin,
+			ClampMax:                 weightedClampMax,
+
+			ShortTokenThreshold:     tokenXShortTokenThreshold,
+			CharsPerToken:           defaultCharsPerToken,
+			SymbolsPerToken:         tokenXSymbolsPerToken,
+			WhitespaceRunesPerToken: weightedWhitespaceRunesPerToken,
+			HangulTokensPerSyllable: hangulTokensPerSyllable,
+			Punctuation:             profilePunctuation,
+		}
+	case ProfileQwen:
+		// Qwen splits Chinese more finely than o200k_base; pure CJK lands near 1.0x base.
+		return Weights{
+			BaseFactor:               0.9467,
+			CJKRatioFactor:           0.0533,
+			PunctRatioFactor:         -0.0616,
+			DigitRatioFactor:         weightedDigitRatioFactor,
+			EmbeddedDigitRatioFactor: 0.4569,
+			ClampMin:                 weightedClampMin,
+			ClampMax:                 weightedClampMax,
+
+			ShortTokenThreshold:     tokenXShortTokenThreshold,
+			CharsPerToken:           defaultCharsPerToken,
+			SymbolsPerToken:         tokenXSymbolsPerToken,
+			WhitespaceRunesPerToken: weightedWhitespaceRunesPerToken,
+			HangulTokensPerSyllable: hangulTokensPerSyllable,
+			Punctuation:             profilePunctuation,
 		}
 	default:
-		return weightedTuning{
-			baseFactor:       0.9467,
-			cjkRatioFactor:   0.0514,
-			punctRatioFactor: -0.0616,
-			digitRatioFactor: 0.4569,
-			clampMin:         weightedClampMin,
-			clampMax:         weightedClampMax,
+		return Weights{
+			BaseFactor:               0.9467,
+			CJKRatioFactor:           0.0514,
+			PunctRatioFactor:         -0.0616,
+			DigitRatioFactor:         weightedDigitRatioFactor,
+			EmbeddedDigitRatioFactor: 0.4569,
+			ClampMin:                 weightedClampMin,
+			ClampMax:                 weightedClampMax,
+
+			ShortTokenThreshold:     tokenXShortTokenThreshold,
+			CharsPerToken:           defaultCharsPerToken,
+			SymbolsPerToken:         tokenXSymbolsPerToken,
+			WhitespaceRunesPerToken: weightedWhitespaceRunesPerToken,
+			HangulTokensPerSyllable: hangulTokensPerSyllable,
+			Punctuation:             profilePunctuation,
 		}
 	}
 }
@@ -58,134 +256,384 @@ const (
 	weightedCategoryCJKRatio   = "ratio_cjk"
 	weightedCategoryPunctRatio = "ratio_punct"
 	weightedCategoryDigitRatio = "ratio_digit"
+	weightedCategoryEmbedDigit = "ratio_embedded_digit"
+	weightedCategoryURLDelim   = "ratio_url_delim"
+	weightedCategoryMath       = "ratio_math"
 	weightedCategoryClamp      = "clamp"
 )
 
-var weightedBreakdownOrder = []string{
+var weightedBreakdownOrder = [...]string{
 	weightedCategoryBase,
 	weightedCategoryCJKRatio,
 	weightedCategoryPunctRatio,
 	weightedCategoryDigitRatio,
+	weightedCategoryEmbedDigit,
+	weightedCategoryURLDelim,
+	weightedCategoryMath,
 	weightedCategoryClamp,
 }
 
+// tokenXParams controls base tokenx segmentation density.
+type tokenXParams struct {
+	tokenXCosts
+	// cjkRanges overrides isCJKRune when non-nil.
+	cjkRanges []RuneRange
+}
+
+// tokenXCosts is the comparable part of tokenXParams, so segmentation passes can be keyed on it.
+type tokenXCosts struct {
+	shortTokenThreshold int
+	charsPerToken       float64
+	digitsPerToken      int
+	symbolsPerToken     float64
+	// whitespaceRunesPerToken, when > 0, charges whitespace runs (see
+	// Weights.WhitespaceRunesPerToken).
+	whitespaceRunesPerToken int
+	hangulTokensPerSyllable float64
+	// classes, when non-nil, replaces asciiSegmentTypes (see Weights.Punctuation).
+	classes *tokenXClasses
+	// languageCharsPerToken, when > 0, replaces the per-segment accent heuristic for
+	// alphanumeric segments (see Options.Language).
+	languageCharsPerToken float64
+}
+
+// isCJK reports whether r counts as a CJK character. CJK punctuation is excluded even inside
+// the CJK ranges: it splits segments like other punctuation.
+func (p tokenXParams) isCJK(r rune) bool {
+	if isCJKPunct(r) {
+		return false
+	}
+	if p.cjkRanges != nil {
+		return inRuneRanges(r, p.cjkRanges)
+	}
+	return isCJKRune(r)
+}
+
+// classTable returns the segment types of ASCII bytes under p.
+func (p tokenXParams) classTable() *tokenXClasses {
+	if p.classes != nil {
+		return p.classes
+	}
+	return &asciiSegmentTypes
+}
+
+// isPunct reports whether r counts as punctuation under p.
+func (p tokenXParams) isPunct(r rune) bool {
+	if r < utf8.RuneSelf {
+		return p.classTable()[r] == tokenXSegmentTypePunctuation
+	}
+	return isTokenXPunct(r)
+}
+
+func (p tokenXParams) containsPunct(segment string) bool {
+	for _, r := range segment {
+		if p.isPunct(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p tokenXParams) isCJKSegment(segment string) bool {
+	if segment == "" {
+		return false
+	}
+	for _, r := range segment {
+		if !p.isCJK(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// segmentationForOptions returns the segmentation parameters for opts, including the short-token,
+// CJK, and language overrides.
+func segmentationForOptions(opts Options) tokenXParams {
+	params := weightsForOptions(opts).segmentation()
+	if opts.ShortTokenThreshold > 0 {
+		params.shortTokenThreshold = opts.ShortTokenThreshold
+	}
+	params.cjkRanges = opts.CJKRanges
+	params.languageCharsPerToken = languageCharsPerToken(opts.Language, params.charsPerToken)
+	return params
+}
+
+var defaultTokenXParams = tokenXParams{tokenXCosts: tokenXCosts{
+	shortTokenThreshold:     tokenXShortTokenThreshold,
+	charsPerToken:           defaultCharsPerToken,
+	digitsPerToken:          tokenXDigitsPerToken,
+	symbolsPerToken:         tokenXSymbolsPerToken,
+	hangulTokensPerSyllable: hangulTokensPerSyllable,
+}}
+
 type tokenXStats struct {
-	TotalRunes    int
-	CJKRunes      int
-	PunctRunes    int
-	DigitRunes    int
-	Whitespace    int
-	EmojiCount    int
-	MathCount     int
-	URLDelimCount int
-	AtCount       int
-}
-
-func estimateWeighted(text string, profile Profile, explain bool, breakdown *[]CategoryBreakdown) int {
+	TotalRunes int
+	CJKRunes   int
+	PunctRunes int
+	// DigitRunes counts digits in segments costed by the numeric path ("2024", "3.14").
+	DigitRunes int
+	// EmbeddedDigitRunes counts digits inside other segments ("abc123def", "v1.2-rc3").
+	EmbeddedDigitRunes int
+	Whitespace         int
+	EmojiCount         int
+	AtCount            int
+	// URLDelimRunes counts URL delimiters in context (see countURLDelims). It is filled by
+	// Weights.addContextStats only for weights that use it.
+	URLDelimRunes int
+	// MathRunes counts math operators in context (see countMathSymbols), filled like
+	// URLDelimRunes.
+	MathRunes int
+}
+
+// contextual reports whether w weights stats that depend on context across segments, which
+// addContextStats takes from the whole text.
+func (w Weights) contextual() bool {
+	return w.URLDelimRatioFactor != 0 || w.MathRatioFactor != 0
+}
+
+// addContextStats fills the stats of text that depend on context across segments, such as
+// whether a '.' sits in a URL or a number, or a '-' in a word or an expression. Only the counts
+// w weights are taken, so the built-in profiles skip the extra pass.
+func (w Weights) addContextStats(text string, stats *tokenXStats) {
+	if w.URLDelimRatioFactor != 0 {
+		stats.URLDelimRunes = countURLDelims(text)
+	}
+	if w.MathRatioFactor != 0 {
+		stats.MathRunes = countMathSymbols(text)
+	}
+}
+
+func estimateWeighted(ctx context.Context, text string, tuning Weights, params tokenXParams, explain bool, breakdown *[]CategoryBreakdown) (int, error) {
 	if text == "" {
-		return 0
+		return 0, nil
 	}
 
-	baseTokens, stats := estimateTokenXWithStats(text)
-	if baseTokens == 0 {
-		return 0
+	baseTokens, stats, err := estimateTokenXWithStatsCtx(ctx, text, params)
+	if err != nil {
+		return 0, err
 	}
+	tuning.addContextStats(text, &stats)
+	return applyWeightedTuning(baseTokens, stats, tuning, explain, breakdown), nil
+}
+
+// weightedExceeds reports whether exceeds holds for the Weighted estimate of text, where
+// exceeds is monotone in its argument. Once text holds a rune other than whitespace, the
+// estimate is at least the tokenx base count times the lower of ClampMin and ClampMax, and the
+// base count only grows while segmenting, so the scan is canceled once exceeds holds for that
+// floor; cancellation is noticed within ctxCheckBytes. Whitespace seen before that is costed by
+// blankInputTokens if nothing else follows, so it sets no floor. Weights without a positive
+// floor are estimated in full.
+func weightedExceeds(text string, tuning Weights, params tokenXParams, exceeds func(tokens int) bool) bool {
+	floor := tuning.ClampMin
+	if tuning.ClampMax > 0 && tuning.ClampMax < floor {
+		floor = tuning.ClampMax
+	}
+	if !(floor > 0) {
+		baseTokens, stats := estimateTokenXWithStats(text, params)
+		tuning.addContextStats(text, &stats)
+		return exceeds(applyWeightedTuning(baseTokens, stats, tuning, false, nil))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	baseTokens, stats, exceeded := 0, tokenXStats{}, false
+	_ = forEachTokenXSegmentCtx(ctx, text, params.classTable(), func(start, end int) {
+		if exceeded {
+			return
+		}
+		baseTokens += estimateTokenXSegment(text[start:end], params, &stats)
+		if stats.TotalRunes > 0 && exceeds(saturatingInt(math.Ceil(float64(baseTokens)*floor))) {
+			exceeded = true
+			cancel()
+		}
+	})
+	if exceeded {
+		return true
+	}
+	tuning.addContextStats(text, &stats)
+	return exceeds(applyWeightedTuning(baseTokens, stats, tuning, false, nil))
+}
 
-	tuning := tuningForProfile(profile)
-	totalRunes := stats.TotalRunes
-	if totalRunes == 0 {
-		totalRunes = 1
+// applyWeightedTuning applies profile ratio tuning to a precomputed tokenx base count.
+// Punctuation enters through the PunctRunes ratio, and URL delimiters and math operators also
+// through their own ratios when the weights set them.
+func applyWeightedTuning(baseTokens int, stats tokenXStats, tuning Weights, explain bool, breakdown *[]CategoryBreakdown) int {
+	if stats.TotalRunes == 0 {
+		// Empty or whitespace-only input; the latter costs blankInputTokens however its runs are
+		// charged.
+		tokens := blankInputTokens(stats.Whitespace)
+		if explain && breakdown != nil && tokens > 0 {
+			*breakdown = append(*breakdown, CategoryBreakdown{Category: weightedCategoryClamp, BaseUnits: float64(tokens), Weight: 1, Tokens: float64(tokens)})
+		}
+		return tokens
 	}
 
-	cjkRatio := float64(stats.CJKRunes) / float64(totalRunes)
-	punctRatio := float64(stats.PunctRunes) / float64(totalRunes)
-	digitRatio := float64(stats.DigitRunes) / float64(totalRunes)
+	totalRunes := float64(stats.TotalRunes)
+	cjkRatio := float64(stats.CJKRunes) / totalRunes
+	punctRatio := float64(stats.PunctRunes) / totalRunes
+	digitRatio := float64(stats.DigitRunes) / totalRunes
+	embeddedDigitRatio := float64(stats.EmbeddedDigitRunes) / totalRunes
+	urlDelimRatio := float64(stats.URLDelimRunes) / totalRunes
+	mathRatio := float64(stats.MathRunes) / totalRunes
 
 	base := float64(baseTokens)
-	tokens := base*tuning.baseFactor +
-		base*cjkRatio*tuning.cjkRatioFactor +
-		base*punctRatio*tuning.punctRatioFactor +
-		base*digitRatio*tuning.digitRatioFactor
-
-	minTokens := base * tuning.clampMin
-	maxTokens := base * tuning.clampMax
+	tokens := base*tuning.BaseFactor +
+		base*cjkRatio*tuning.CJKRatioFactor +
+		base*punctRatio*tuning.PunctRatioFactor +
+		base*digitRatio*tuning.DigitRatioFactor +
+		base*embeddedDigitRatio*tuning.EmbeddedDigitRatioFactor +
+		base*urlDelimRatio*tuning.URLDelimRatioFactor +
+		base*mathRatio*tuning.MathRatioFactor
+
+	minTokens := base * tuning.ClampMin
+	maxTokens := base * tuning.ClampMax
 	if tokens < minTokens {
 		tokens = minTokens
 	}
-	if tokens > maxTokens {
+	if tuning.ClampMax > 0 && tokens > maxTokens {
 		tokens = maxTokens
 	}
+	// Custom weights can drive the sum below zero.
+	if !(tokens > 0) {
+		tokens = 0
+	}
 
 	if explain && breakdown != nil {
-		items := make([]CategoryBreakdown, 0, len(weightedBreakdownOrder))
-		appendBreakdownItem := func(category string, units float64, weight float64) {
-			if units == 0 || weight == 0 {
-				return
+		// Accumulate into a fixed array and materialize the slice once.
+		var items [len(weightedBreakdownOrder)]CategoryBreakdown
+		n := 0
+		sum := 0.0
+		for _, item := range [...]struct {
+			category string
+			units    float64
+			weight   float64
+		}{
+			{weightedCategoryBase, base, tuning.BaseFactor},
+			{weightedCategoryCJKRatio, base * cjkRatio, tuning.CJKRatioFactor},
+			{weightedCategoryPunctRatio, base * punctRatio, tuning.PunctRatioFactor},
+			{weightedCategoryDigitRatio, base * digitRatio, tuning.DigitRatioFactor},
+			{weightedCategoryEmbedDigit, base * embeddedDigitRatio, tuning.EmbeddedDigitRatioFactor},
+			{weightedCategoryURLDelim, base * urlDelimRatio, tuning.URLDelimRatioFactor},
+			{weightedCategoryMath, base * mathRatio, tuning.MathRatioFactor},
+		} {
+			if item.units == 0 || item.weight == 0 {
+				continue
+			}
+			items[n] = CategoryBreakdown{
+				Category:  item.category,
+				BaseUnits: item.units,
+				Weight:    item.weight,
+				Tokens:    item.units * item.weight,
 			}
-			items = append(items, CategoryBreakdown{
-				Category:  category,
-				BaseUnits: units,
-				Weight:    weight,
-				Tokens:    units * weight,
-			})
+			sum += items[n].Tokens
+			n++
 		}
 
-		appendBreakdownItem(weightedCategoryBase, base, tuning.baseFactor)
-		appendBreakdownItem(weightedCategoryCJKRatio, base*cjkRatio, tuning.cjkRatioFactor)
-		appendBreakdownItem(weightedCategoryPunctRatio, base*punctRatio, tuning.punctRatioFactor)
-		appendBreakdownItem(weightedCategoryDigitRatio, base*digitRatio, tuning.digitRatioFactor)
-
-		sum := 0.0
-		for _, item := range items {
-			sum += item.Tokens
-		}
-		clampDelta := tokens - sum
-		if clampDelta != 0 {
-			items = append(items, CategoryBreakdown{
+		if clampDelta := tokens - sum; clampDelta != 0 {
+			items[n] = CategoryBreakdown{
 				Category:  weightedCategoryClamp,
 				BaseUnits: clampDelta,
 				Weight:    1,
 				Tokens:    clampDelta,
-			})
+			}
+			n++
 		}
 
-		*breakdown = items
+		*breakdown = append((*breakdown)[:0], items[:n]...)
 	}
 
-	return int(math.Ceil(tokens))
+	return saturatingInt(math.Ceil(tokens))
+}
+
+func estimateTokenXWithStats(text string, params tokenXParams) (int, tokenXStats) {
+	baseTokens, stats, _ := estimateTokenXWithStatsCtx(context.Background(), text, params)
+	return baseTokens, stats
 }
 
-func estimateTokenXWithStats(text string) (int, tokenXStats) {
+// estimateTokenXWithStatsCtx is estimateTokenXWithStats that stops with ctx.Err() once ctx is
+// done (see forEachTokenXSegmentCtx).
+func estimateTokenXWithStatsCtx(ctx context.Context, text string, params tokenXParams) (int, tokenXStats, error) {
 	stats := tokenXStats{}
 	if text == "" {
-		return 0, stats
+		return 0, stats, nil
 	}
 
 	baseTokens := 0
+	err := forEachTokenXSegmentCtx(ctx, text, params.classTable(), func(start, end int) {
+		baseTokens += estimateTokenXSegment(text[start:end], params, &stats)
+	})
+	if err != nil {
+		return 0, tokenXStats{}, err
+	}
+
+	return baseTokens, stats, nil
+}
+
+// forEachTokenXSegment calls fn with the byte range of each tokenx segment in text, in order.
+// ASCII bytes are classified by lookup in classes; only other bytes are decoded as UTF-8.
+func forEachTokenXSegment(text string, classes *tokenXClasses, fn func(start, end int)) {
+	_ = forEachTokenXSegmentCtx(context.Background(), text, classes, fn)
+}
+
+// ctxCheckBytes is how much input the segmentation loops scan between checks of ctx.
+const ctxCheckBytes = 64 << 10
+
+// forEachTokenXSegmentCtx is forEachTokenXSegment that checks ctx every ctxCheckBytes and
+// returns ctx.Err() without visiting the remaining segments once ctx is done.
+func forEachTokenXSegmentCtx(ctx context.Context, text string, classes *tokenXClasses, fn func(start, end int)) error {
 	segmentStart := 0
 	segmentType := tokenXSegmentTypeNone
-	first := true
+	nextCheck := ctxCheckBytes
 
-	for idx, r := range text {
-		currentType := tokenXSegmentTypeForRune(r)
-		if first {
-			first = false
-			segmentType = currentType
-			continue
+	for idx := 0; idx < len(text); {
+		if idx >= nextCheck {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			nextCheck = idx + ctxCheckBytes
 		}
-
-		if currentType != segmentType {
-			baseTokens += estimateTokenXSegment(text[segmentStart:idx], &stats)
+		var currentType tokenXSegmentType
+		size := 1
+		if c := text[idx]; c < utf8.RuneSelf {
+			currentType = classes[c]
+		} else {
+			var r rune
+			r, size = utf8.DecodeRuneInString(text[idx:])
+			currentType = classes.typeOfDecoded(r, size)
+		}
+		if idx == 0 {
+			segmentType = currentType
+		} else if currentType != segmentType {
+			fn(segmentStart, idx)
 			segmentStart = idx
 			segmentType = currentType
 		}
+		idx += size
 	}
 
 	if segmentStart < len(text) {
-		baseTokens += estimateTokenXSegment(text[segmentStart:], &stats)
+		fn(segmentStart, len(text))
+	}
+	return nil
+}
+
+// lastTokenXSegmentStart returns the byte offset where the trailing tokenx segment of text begins.
+func lastTokenXSegmentStart(text string, classes *tokenXClasses) int {
+	if text == "" {
+		return 0
 	}
 
-	return baseTokens, stats
+	last, size := utf8.DecodeLastRuneInString(text)
+	lastType := classes.typeOfDecoded(last, size)
+	start := len(text) - size
+	for start > 0 {
+		r, size := utf8.DecodeLastRuneInString(text[:start])
+		if classes.typeOfDecoded(r, size) != lastType {
+			break
+		}
+		start -= size
+	}
+	return start
 }
 
 type tokenXSegmentType int
@@ -195,6 +643,8 @@ const (
 	tokenXSegmentTypeWhitespace
 	tokenXSegmentTypePunctuation
 	tokenXSegmentTypeOther
+	// tokenXSegmentTypeInvalid groups bytes that are not valid UTF-8.
+	tokenXSegmentTypeInvalid
 )
 
 func tokenXSegmentTypeForRune(r rune) tokenXSegmentType {
@@ -207,96 +657,343 @@ func tokenXSegmentTypeForRune(r rune) tokenXSegmentType {
 	return tokenXSegmentTypeOther
 }
 
-func estimateTokenXSegment(segment string, stats *tokenXStats) int {
+// tokenXClasses holds the segment type of every ASCII byte; it is how Weights.Punctuation
+// changes segmentation. Non-ASCII runes always use tokenXSegmentTypeForRune.
+type tokenXClasses [utf8.RuneSelf]tokenXSegmentType
+
+func (c *tokenXClasses) typeOf(r rune) tokenXSegmentType {
+	if r >= 0 && r < utf8.RuneSelf {
+		return c[r]
+	}
+	return tokenXSegmentTypeForRune(r)
+}
+
+// typeAt classifies r, decoded by ranging over text at byte offset idx.
+func (c *tokenXClasses) typeAt(text string, idx int, r rune) tokenXSegmentType {
+	if isInvalidByteAt(text, idx, r) {
+		return tokenXSegmentTypeInvalid
+	}
+	return c.typeOf(r)
+}
+
+// typeOfDecoded classifies a rune decoded with the utf8 package, where a utf8.RuneError of
+// size 1 is an invalid byte.
+func (c *tokenXClasses) typeOfDecoded(r rune, size int) tokenXSegmentType {
+	if r == utf8.RuneError && size == 1 {
+		return tokenXSegmentTypeInvalid
+	}
+	return c.typeOf(r)
+}
+
+// asciiSegmentTypes caches tokenXSegmentTypeForRune for every ASCII byte, with
+// DefaultPunctuation as the punctuation set.
+var asciiSegmentTypes = func() (types tokenXClasses) {
+	for b := range types {
+		types[b] = tokenXSegmentTypeForRune(rune(b))
+	}
+	return types
+}()
+
+// punctuationClassCacheSize bounds punctuationClassCache, since Weights.Punctuation is caller
+// input; sets beyond it are built on every use.
+const punctuationClassCacheSize = 64
+
+var (
+	// punctuationClassCache maps Weights.Punctuation strings to their tables, so custom sets are
+	// built once rather than on every estimate. punctuationClassCount counts its entries.
+	punctuationClassCache sync.Map
+	punctuationClassCount atomic.Int32
+)
+
+// punctuationClasses returns the ASCII segment types with the runes of punct as punctuation.
+func punctuationClasses(punct string) *tokenXClasses {
+	if cached, ok := punctuationClassCache.Load(punct); ok {
+		return cached.(*tokenXClasses)
+	}
+	classes := newPunctuationClasses(punct)
+	if punctuationClassCount.Add(1) > punctuationClassCacheSize {
+		punctuationClassCount.Add(-1)
+		return classes
+	}
+	cached, loaded := punctuationClassCache.LoadOrStore(punct, classes)
+	if loaded {
+		punctuationClassCount.Add(-1)
+	}
+	return cached.(*tokenXClasses)
+}
+
+// newPunctuationClasses builds the ASCII segment types with the runes of punct as punctuation.
+func newPunctuationClasses(punct string) *tokenXClasses {
+	classes := asciiSegmentTypes
+	for b, t := range classes {
+		if t == tokenXSegmentTypePunctuation {
+			classes[b] = tokenXSegmentTypeOther
+		}
+	}
+	for i := 0; i < len(punct); i++ {
+		if c := punct[i]; c < utf8.RuneSelf && classes[c] == tokenXSegmentTypeOther {
+			classes[c] = tokenXSegmentTypePunctuation
+		}
+	}
+	return &classes
+}
+
+// estimateTokenXSegmentASCII is costTokenXSegment for a segment without a byte >= 0x80. ASCII
+// holds no invalid bytes, emoji, CJK, or language-specific runes, so the segment is costed
+// bytewise without decoding runes, with the same result.
+func estimateTokenXSegmentASCII(segment string, params tokenXParams, stats *tokenXStats) (int, string) {
+	n := len(segment)
+	isNumeric := isNumericSegment(segment)
+	var whitespace, punct, digits, at int
+	alphanumeric := true
+	classes := params.classTable()
+	for i := 0; i < n; i++ {
+		c := segment[i]
+		switch classes[c] {
+		case tokenXSegmentTypeWhitespace:
+			whitespace++
+		case tokenXSegmentTypePunctuation:
+			punct++
+		}
+		switch {
+		case c >= '0' && c <= '9':
+			digits++
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		default:
+			alphanumeric = false
+			if c == '@' {
+				at++
+			}
+		}
+	}
+
+	if whitespace == n {
+		stats.Whitespace += n
+		return estimateWhitespaceSegment(segment, params.whitespaceRunesPerToken), SegmentCategoryWhitespace
+	}
+	stats.TotalRunes += n
+	stats.PunctRunes += punct
+	stats.AtCount += at
+	if isNumeric {
+		stats.DigitRunes += digits
+		return numeric.Tokens(segment, params.digitsPerToken), SegmentCategoryNumeric
+	}
+	stats.EmbeddedDigitRunes += digits
+
+	if n <= params.shortTokenThreshold {
+		return 1, SegmentCategoryShort
+	}
+	if isRepeatedRun(segment, n) {
+		return int(math.Ceil(float64(n) / repeatedRunCharsPerToken)), SegmentCategoryRepeated
+	}
+	if punct > 0 {
+		if n > 1 {
+			return int(math.Ceil(float64(n) / params.symbolsPerToken)), SegmentCategoryPunct
+		}
+		return 1, SegmentCategoryPunct
+	}
+	if isBase64LikeSegment(segment) {
+		return int(math.Ceil(float64(n) / base64CharsPerToken)), SegmentCategoryBase64
+	}
+	if alphanumeric {
+		avg := params.languageCharsPerToken
+		if avg <= 0 {
+			avg = params.charsPerToken
+		}
+		return int(math.Ceil(float64(n) / avg)), SegmentCategoryAlnum
+	}
+	return n, SegmentCategoryOther
+}
+
+func estimateTokenXSegment(segment string, params tokenXParams, stats *tokenXStats) int {
+	tokens, _ := costTokenXSegment(segment, params, stats)
+	return tokens
+}
+
+// costTokenXSegment returns a segment's base tokens and the SegmentCategory* of the branch that
+// costed it, so Segments and ExplainRanges label segments exactly as they are costed.
+func costTokenXSegment(segment string, params tokenXParams, stats *tokenXStats) (int, string) {
 	if segment == "" {
-		return 0
+		return 0, SegmentCategoryOther
 	}
+	// Custom CJK ranges may cover ASCII, so only the default ranges take the fast path.
+	if params.cjkRanges == nil && isASCII(segment) {
+		return estimateTokenXSegmentASCII(segment, params, stats)
+	}
+	return estimateTokenXSegmentRunes(segment, params, stats)
+}
 
+// estimateTokenXSegmentRunes costs a segment of any content rune by rune.
+func estimateTokenXSegmentRunes(segment string, params tokenXParams, stats *tokenXStats) (int, string) {
 	if isTokenXWhitespace(segment) {
-		stats.Whitespace += utf8.RuneCountInString(segment)
-		return 0
+		runeCount := utf8.RuneCountInString(segment)
+		stats.Whitespace += runeCount
+		return estimateWhitespaceSegment(segment, params.whitespaceRunesPerToken), SegmentCategoryWhitespace
+	}
+
+	// Invalid UTF-8 costs one symbol unit per byte.
+	if isInvalidUTF8Segment(segment) {
+		stats.TotalRunes += len(segment)
+		return len(segment), SegmentCategoryInvalid
 	}
 
-	runeCount := utf8.RuneCountInString(segment)
+	// Combining marks fold into their base character's count, so decomposed accents cost like
+	// their precomposed forms.
+	runeCount := characterCount(segment)
+
+	// A ZWJ sequence or modified emoji is one visual unit; count it once so it neither
+	// inflates the emoji count nor dilutes the density ratios.
+	clusters, emojiOnly := emojiClusters(segment)
+	if emojiOnly {
+		stats.TotalRunes += clusters
+		stats.EmojiCount += clusters
+		return clusters, SegmentCategoryEmoji
+	}
 	stats.TotalRunes += runeCount
+	stats.EmojiCount += clusters
 
+	isNumeric := isNumericSegment(segment)
 	for _, r := range segment {
-		if isCJKRune(r) {
+		// CJK punctuation is costed per rune like CJK characters, and the ratio factors were
+		// fitted with it in the CJK ratio, so it is weighted there rather than as punctuation.
+		if params.isCJK(r) || isCJKPunct(r) {
 			stats.CJKRunes++
-		}
-		if isTokenXPunct(r) {
+		} else if params.isPunct(r) {
 			stats.PunctRunes++
 		}
 		if r >= '0' && r <= '9' {
-			stats.DigitRunes++
-		}
-		if isEmoji(r) {
-			stats.EmojiCount++
-		}
-		if isMathSymbol(r) {
-			stats.MathCount++
-		}
-		if isURLDelim(r) {
-			stats.URLDelimCount++
+			if isNumeric {
+				stats.DigitRunes++
+			} else {
+				stats.EmbeddedDigitRunes++
+			}
 		}
 		if isAtSign(r) {
 			stats.AtCount++
 		}
 	}
 
-	if isCJKSegment(segment) {
-		return runeCount
+	if params.isCJKSegment(segment) {
+		if isHangulSegment(segment) {
+			return int(math.Ceil(float64(runeCount) * params.hangulTokensPerSyllable)), SegmentCategoryHangul
+		}
+		return estimateCJKSegment(segment), SegmentCategoryCJK
+	}
+
+	if isNumeric {
+		return numeric.Tokens(segment, params.digitsPerToken), SegmentCategoryNumeric
 	}
 
-	if isNumericSegment(segment) {
-		return 1
+	// o200k_base spends about a token on each CJK punctuation mark rather than merging a
+	// cluster such as "：「" into one, so these cost per rune like CJK characters.
+	if isCJKPunctSegment(segment) {
+		return estimateCJKSegment(segment), SegmentCategoryPunct
 	}
 
-	if runeCount <= tokenXShortTokenThreshold {
-		return 1
+	if runeCount <= params.shortTokenThreshold {
+		return 1, SegmentCategoryShort
 	}
 
-	if containsTokenXPunct(segment) {
+	if isRepeatedRun(segment, runeCount) {
+		return int(math.Ceil(float64(runeCount) / repeatedRunCharsPerToken)), SegmentCategoryRepeated
+	}
+
+	if params.containsPunct(segment) {
 		if runeCount > 1 {
-			return int(math.Ceil(float64(runeCount) / 2.0))
+			return int(math.Ceil(float64(runeCount) / params.symbolsPerToken)), SegmentCategoryPunct
 		}
-		return 1
+		return 1, SegmentCategoryPunct
+	}
+
+	if isBase64LikeSegment(segment) {
+		return int(math.Ceil(float64(runeCount) / base64CharsPerToken)), SegmentCategoryBase64
 	}
 
 	if isAlphanumericSegment(segment) {
-		avg := getLanguageSpecificCharsPerToken(segment)
+		avg := params.languageCharsPerToken
+		if avg <= 0 {
+			avg = getLanguageSpecificCharsPerToken(segment)
+		}
 		if avg <= 0 {
-			avg = defaultCharsPerToken
+			avg = params.charsPerToken
 		}
-		return int(math.Ceil(float64(runeCount) / avg))
+		return int(math.Ceil(float64(runeCount) / avg)), SegmentCategoryAlnum
 	}
 
-	return runeCount
+	return runeCount, SegmentCategoryOther
 }
 
-func isTokenXWhitespace(segment string) bool {
+// estimateWhitespaceSegment charges the indentation in a whitespace run: the runes after its last
+// line break. Line breaks stay free, as the ratio factors were fitted with them free.
+func estimateWhitespaceSegment(segment string, runesPerToken int) int {
+	if runesPerToken <= 0 {
+		return 0
+	}
+	return indentTokens(utf8.RuneCountInString(segment[strings.LastIndexAny(segment, "\r\n")+1:]), runesPerToken)
+}
+
+// indentTokens costs indent runes of indentation: the last is free, as BPE merges it into the next
+// word, and the rest cost one token per runesPerToken. A non-positive runesPerToken makes it free.
+func indentTokens(indent, runesPerToken int) int {
+	if runesPerToken <= 0 || indent <= 1 {
+		return 0
+	}
+	return (indent - 1 + runesPerToken - 1) / runesPerToken
+}
+
+// isHangulSegment reports whether segment consists only of Hangul syllables.
+func isHangulSegment(segment string) bool {
 	for _, r := range segment {
-		if !unicode.IsSpace(r) {
+		if r < 0xAC00 || r > 0xD7A3 {
 			return false
 		}
 	}
 	return segment != ""
 }
 
-func containsTokenXPunct(segment string) bool {
+// estimateCJKSegment charges one token per CJK rune, except for runs of the same rune
+// (e.g. "哈哈哈哈", "啊啊啊") which are discounted to approximate BPE merging.
+func estimateCJKSegment(segment string) int {
+	tokens := 0
+	var prev rune
+	run := 0
+	flush := func() {
+		if run >= cjkRepeatMinRun {
+			tokens += (run + cjkRepeatRunesPerToken - 1) / cjkRepeatRunesPerToken
+		} else {
+			tokens += run
+		}
+	}
+
 	for _, r := range segment {
-		if isTokenXPunct(r) {
-			return true
+		if run > 0 && r == prev {
+			run++
+			continue
 		}
+		flush()
+		prev = r
+		run = 1
 	}
-	return false
+	flush()
+
+	return tokens
+}
+
+func isTokenXWhitespace(segment string) bool {
+	for _, r := range segment {
+		if !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return segment != ""
 }
 
+// isTokenXPunct reports whether r is punctuation under DefaultPunctuation.
 func isTokenXPunct(r rune) bool {
 	switch r {
 	case '.', ',', '!', '?', ';', '(', ')', '{', '}', '[', ']', '<', '>', ':', '/', '\\', '|', '@', '#', '$', '%', '^', '&', '*', '+', '=', '`', '~', '_', '-':
 		return true
 	default:
-		return false
+		return isCJKPunct(r)
 	}
 }
@@ -0,0 +1,22 @@
+package tokenest
+
+import (
+	"fmt"
+	"io"
+
+	zrstrategy "github.com/EZ-Api/tokenest/strategy"
+)
+
+// ZRConfig holds ZR thresholds and coefficients for Options.ZRConfig.
+type ZRConfig = zrstrategy.ZRConfig
+
+// LoadZRConfig parses the ZR config JSON written by the fit tool (tools/fit -out-zr-config),
+// so fitted coefficients can be used without rebuilding. Zero thresholds and missing
+// coefficient rows keep the built-in defaults. Parse errors wrap ErrConfigInvalid.
+func LoadZRConfig(r io.Reader) (*ZRConfig, error) {
+	cfg, err := zrstrategy.LoadZRConfig(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrConfigInvalid, err)
+	}
+	return cfg, nil
+}